@@ -36,22 +36,29 @@ func main() {
     lb := proxy.NewLoadBalancer(cfg, sugar)
 
     // Запускаем HTTP-сервер в отдельной горутине
+    served := make(chan error, 1)
     go func() {
-        addr := fmt.Sprintf(":%d", cfg.Port)
-        if err := lb.ListenAndServe(addr); err != nil {
-            // Если сервер завершился с ошибкой — фатальный лог и выход
-            sugar.Fatalf("server failed: %v", err)
-        }
+        served <- lb.ListenAndServe(fmt.Sprintf(":%d", cfg.Port))
     }()
 
     // Настраиваем канал для перехвата системных сигналов (SIGINT/SIGTERM)
     quit := make(chan os.Signal, 1)
     signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-    // Блокируем выполнение, пока не получим сигнал завершения
-    <-quit
-    sugar.Info("received shutdown signal")
+    // Блокируем выполнение, пока не получим сигнал завершения или сервер не упадёт
+    select {
+    case <-quit:
+        sugar.Info("received shutdown signal")
+    case err := <-served:
+        if err != nil {
+            // Сервер не запустился или упал — всё равно сохраняем состояние перед выходом
+            sugar.Errorf("server failed: %v", err)
+            lb.Shutdown()
+            logger.Sync()
+            os.Exit(1)
+        }
+    }
 
-    
+    // Shutdown дожидается запросов, TCP-соединений и сохраняет снимки лимитов и квот
     lb.Shutdown()
 }
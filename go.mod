@@ -3,8 +3,18 @@ module github.com/Manzo48/loadBalancer
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/klauspost/compress v1.17.9
 	go.uber.org/zap v1.27.0
+	golang.org/x/net v0.35.0
+	google.golang.org/grpc v1.66.2
+	google.golang.org/protobuf v1.34.1
 	gopkg.in/yaml.v2 v2.4.0
 )
 
-require go.uber.org/multierr v1.10.0 // indirect
+require (
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
+)
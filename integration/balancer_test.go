@@ -2,11 +2,16 @@
 package integration
 
 import (
+    "fmt"
     "net/http"
+    "os"
+    "path/filepath"
+    "strings"
     "sync"
     "testing"
     "time"
 
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
     "github.com/Manzo48/loadBalancer/pkg/config"
     "github.com/Manzo48/loadBalancer/pkg/proxy"
     "go.uber.org/zap"
@@ -54,4 +59,73 @@ func BenchmarkBalancer(b *testing.B) {
             resp.Body.Close()
         }
     }
-}
\ No newline at end of file
+}
+// Тот же путь, что и в cmd/loadbalancer: ListenAndServe в горутине, затем Shutdown по сигналу
+func TestShutdown_ServeReturnsNilAndSavesSnapshot(t *testing.T) {
+    backend := newNamedBackend(t, "app")
+    snapshot := filepath.Join(t.TempDir(), "buckets.json")
+
+    cfg := &config.Config{Port: freePort(t), Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    cfg.RateLimit.SnapshotFile = snapshot
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    addr := fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+    served := make(chan error, 1)
+    go func() { served <- lb.ListenAndServe(addr) }()
+    waitForListener(t, addr)
+
+    resp, err := http.Get("http://" + addr + "/")
+    if err != nil {
+        t.Fatalf("request failed: %v", err)
+    }
+    resp.Body.Close()
+
+    lb.Shutdown()
+    select {
+    case err := <-served:
+        if err != nil {
+            t.Fatalf("ListenAndServe should return nil after Shutdown, got %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("ListenAndServe did not return after Shutdown")
+    }
+    if _, err := os.Stat(snapshot); err != nil {
+        t.Fatalf("rate limiter snapshot was not written: %v", err)
+    }
+}
+
+func TestBalancer_LeastConnectionsRotatesTies(t *testing.T) {
+    urls := []string{"http://10.0.0.1", "http://10.0.0.2", "http://10.0.0.3"}
+    b, err := balancer.New(balancer.StrategyLeastConnections, urls, balancer.HealthCheck{Disabled: true}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Короткие запросы: к моменту выбора следующего backend'а все свободны
+    picks := map[string]int{}
+    for i := 0; i < 30; i++ {
+        be, err := b.NextBackend("")
+        if err != nil {
+            t.Fatal(err)
+        }
+        picks[be.URL.Host]++
+        be.Release()
+    }
+    for _, u := range urls {
+        if n := picks[strings.TrimPrefix(u, "http://")]; n != 10 {
+            t.Fatalf("expected equal share for tied backends, got %v", picks)
+        }
+    }
+
+    // Загруженный backend по-прежнему обходится стороной
+    busy, _ := b.NextBackend("")
+    for i := 0; i < 10; i++ {
+        be, _ := b.NextBackend("")
+        if be == busy {
+            t.Fatalf("least_connections picked the busy backend %s", be.URL)
+        }
+        be.Release()
+    }
+    busy.Release()
+}
@@ -0,0 +1,404 @@
+package integration
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+)
+
+func TestCache_FreshnessRevalidationAndStale(t *testing.T) {
+    var (
+        mu      sync.Mutex
+        calls   = map[string]int{}
+        notMod  atomic.Int32
+        failing atomic.Bool
+        version atomic.Int32
+    )
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        calls[r.URL.Path]++
+        n := calls[r.URL.Path]
+        mu.Unlock()
+
+        switch r.URL.Path {
+        case "/fresh":
+            w.Header().Set("Cache-Control", "max-age=60")
+            w.Header().Set("ETag", `"f1"`)
+        case "/vary":
+            w.Header().Set("Cache-Control", "max-age=60")
+            w.Header().Set("Vary", "Accept-Language")
+            fmt.Fprint(w, r.Header.Get("Accept-Language"))
+            return
+        case "/revalidate":
+            w.Header().Set("Cache-Control", "no-cache")
+            w.Header().Set("ETag", `"r1"`)
+            if r.Header.Get("If-None-Match") == `"r1"` {
+                notMod.Add(1)
+                w.WriteHeader(http.StatusNotModified)
+                return
+            }
+        case "/stale":
+            if failing.Load() {
+                http.Error(w, "down", http.StatusInternalServerError)
+                return
+            }
+            w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=30, stale-if-error=30")
+            fmt.Fprintf(w, "v%d", version.Load())
+            return
+        case "/slow":
+            time.Sleep(200 * time.Millisecond)
+            w.Header().Set("Cache-Control", "max-age=60")
+        case "/private":
+            w.Header().Set("Cache-Control", "private, max-age=60")
+        case "/Docs/Intro":
+            w.Header().Set("Cache-Control", "max-age=60")
+        }
+        fmt.Fprintf(w, "%s#%d", r.URL.Path, n)
+    }))
+    t.Cleanup(backend.Close)
+
+    cfg := &config.Config{
+        Backends: []string{backend.URL},
+        Routes:   []config.Route{{Name: "site", Pool: "default", Cache: config.RouteCache{Enabled: true}}},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    handler := lb.Handler()
+
+    get := func(path string, header ...string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", "http://site.example.com"+path, nil)
+        for i := 0; i+1 < len(header); i += 2 {
+            req.Header.Set(header[i], header[i+1])
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+    expect := func(rec *httptest.ResponseRecorder, code int, body, xcache string) {
+        t.Helper()
+        if rec.Code != code || rec.Body.String() != body || rec.Header().Get("X-Cache") != xcache {
+            t.Fatalf("expected %d %q (X-Cache %s), got %d %q (X-Cache %s)",
+                code, body, xcache, rec.Code, rec.Body.String(), rec.Header().Get("X-Cache"))
+        }
+    }
+
+    // Свежий ответ отдаётся из кэша, в том числе как 304 на условный запрос клиента
+    expect(get("/fresh"), 200, "/fresh#1", "MISS")
+    expect(get("/fresh"), 200, "/fresh#1", "HIT")
+    expect(get("/fresh", "If-None-Match", `"f1"`), 304, "", "HIT")
+    expect(get("/fresh", "Cache-Control", "no-cache"), 200, "/fresh#2", "MISS")
+
+    // Варианты по Vary хранятся отдельно
+    expect(get("/vary", "Accept-Language", "en"), 200, "en", "MISS")
+    expect(get("/vary", "Accept-Language", "de"), 200, "de", "MISS")
+    expect(get("/vary", "Accept-Language", "en"), 200, "en", "HIT")
+
+    // no-cache: запись проверяется у backend'а по ETag и отдаётся без повторной передачи тела
+    expect(get("/revalidate"), 200, "/revalidate#1", "MISS")
+    expect(get("/revalidate"), 200, "/revalidate#1", "REVALIDATED")
+    if notMod.Load() != 1 {
+        t.Fatalf("expected one conditional request to backend, got %d", notMod.Load())
+    }
+
+    // private не сохраняется
+    expect(get("/private"), 200, "/private#1", "MISS")
+    expect(get("/private"), 200, "/private#2", "MISS")
+
+    // Одновременные промахи объединяются в один запрос к backend'у
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if rec := get("/slow"); rec.Body.String() != "/slow#1" {
+                t.Errorf("expected coalesced response /slow#1, got %q", rec.Body.String())
+            }
+        }()
+    }
+    wg.Wait()
+    mu.Lock()
+    slowCalls := calls["/slow"]
+    mu.Unlock()
+    if slowCalls != 1 {
+        t.Fatalf("expected concurrent misses to reach backend once, got %d", slowCalls)
+    }
+
+    // stale-while-revalidate: устаревшая копия отдаётся сразу, обновление — в фоне
+    expect(get("/stale"), 200, "v0", "MISS")
+    version.Store(1)
+    time.Sleep(1100 * time.Millisecond)
+    expect(get("/stale"), 200, "v0", "STALE")
+    time.Sleep(100 * time.Millisecond)
+    expect(get("/stale"), 200, "v1", "HIT")
+
+    // stale-if-error: при ошибке backend'а отдаётся сохранённая копия
+    failing.Store(true)
+    time.Sleep(1100 * time.Millisecond)
+    rec := get("/stale", "Cache-Control", "no-cache")
+    expect(rec, 200, "v1", "STALE")
+
+    // Очистка через админский API
+    admin := lb.AdminHandler()
+    purge := httptest.NewRecorder()
+    admin.ServeHTTP(purge, httptest.NewRequest("POST", "/cache?prefix=site.example.com/fresh", nil))
+    if purge.Code != http.StatusOK || !strings.Contains(purge.Body.String(), `"purged":1`) {
+        t.Fatalf("purge failed: %d %s", purge.Code, purge.Body)
+    }
+    expect(get("/fresh"), 200, "/fresh#3", "MISS")
+    expect(get("/vary", "Accept-Language", "de"), 200, "de", "HIT")
+
+    // Регистр не важен только в имени хоста: путь в префиксе сравнивается как есть
+    expect(get("/Docs/Intro"), 200, "/Docs/Intro#1", "MISS")
+    purge = httptest.NewRecorder()
+    admin.ServeHTTP(purge, httptest.NewRequest("POST", "/cache?prefix=SITE.example.com/Docs", nil))
+    if !strings.Contains(purge.Body.String(), `"purged":1`) {
+        t.Fatalf("mixed-case path was not purged: %s", purge.Body)
+    }
+    expect(get("/Docs/Intro"), 200, "/Docs/Intro#2", "MISS")
+}
+
+func TestCache_SizeBoundedLRU(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Cache-Control", "max-age=60")
+        fmt.Fprint(w, strings.Repeat("x", 200))
+    }))
+    t.Cleanup(backend.Close)
+
+    cfg := &config.Config{
+        Backends: []string{backend.URL},
+        Routes:   []config.Route{{Name: "site", Pool: "default", Cache: config.RouteCache{Enabled: true, TTL: time.Minute}}},
+    }
+    cfg.Cache.MaxBytes = 4096
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    handler := lb.Handler()
+
+    get := func(path string) string {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://site.example.com"+path, nil))
+        return rec.Header().Get("X-Cache")
+    }
+    get("/hot")
+    for i := 0; i < 50; i++ {
+        get(fmt.Sprintf("/item/%d", i))
+        get("/hot") // Часто используемая запись не вытесняется
+    }
+    if got := get("/hot"); got != "HIT" {
+        t.Fatalf("expected recently used entry to stay cached, got %s", got)
+    }
+    if got := get("/item/0"); got != "MISS" {
+        t.Fatalf("expected least recently used entry to be evicted, got %s", got)
+    }
+
+    stats := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(stats, httptest.NewRequest("GET", "/cache", nil))
+    var bytes, entries int
+    fmt.Sscanf(stats.Body.String(), `{"bytes":%d,"entries":%d}`, &bytes, &entries)
+    if bytes == 0 || bytes > 4096 || entries >= 50 {
+        t.Fatalf("expected cache bounded by 4096 bytes, got %s", stats.Body)
+    }
+}
+
+func TestCache_BackgroundRevalidationTimeout(t *testing.T) {
+    var calls atomic.Int32
+    release := make(chan struct{})
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        n := calls.Add(1)
+        if n == 2 {
+            // Фоновое обновление зависает на backend'е
+            select {
+            case <-r.Context().Done():
+            case <-release:
+            }
+            return
+        }
+        w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=1")
+        fmt.Fprintf(w, "v%d", n)
+    })
+    a, b := httptest.NewServer(handler), httptest.NewServer(handler)
+    t.Cleanup(a.Close)
+    t.Cleanup(b.Close)
+    t.Cleanup(func() { close(release) }) // Выполняется раньше закрытия серверов
+
+    cfg := &config.Config{
+        Backends: []string{a.URL, b.URL},
+        Routes:   []config.Route{{Name: "site", Pool: "default", Cache: config.RouteCache{Enabled: true}}},
+    }
+    cfg.Cache.RevalidateTimeout = 500 * time.Millisecond
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    h := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    get := func() *httptest.ResponseRecorder {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, httptest.NewRequest("GET", "http://site.example.com/page", nil).WithContext(ctx))
+        return rec
+    }
+
+    if rec := get(); rec.Header().Get("X-Cache") != "MISS" {
+        t.Fatalf("expected MISS, got %s", rec.Header().Get("X-Cache"))
+    }
+    time.Sleep(1100 * time.Millisecond)
+    if rec := get(); rec.Header().Get("X-Cache") != "STALE" {
+        t.Fatalf("expected STALE with background revalidation, got %s", rec.Header().Get("X-Cache"))
+    }
+
+    // Запись вышла из stale-while-revalidate: промах ждёт фоновое обновление,
+    // но не дольше revalidate_timeout, а затем обращается к backend'у сам
+    time.Sleep(1000 * time.Millisecond)
+    start := time.Now()
+    rec := get()
+    if rec.Code != http.StatusOK || rec.Body.String() != "v3" {
+        t.Fatalf("expected fresh response v3 after revalidation timed out, got %d %q", rec.Code, rec.Body.String())
+    }
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Fatalf("miss waited %s for a hung revalidation", elapsed)
+    }
+}
+
+func TestCache_SplitPoolsCachedSeparately(t *testing.T) {
+    pool := func(name string) *httptest.Server {
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Cache-Control", "max-age=60")
+            fmt.Fprint(w, name)
+        }))
+        t.Cleanup(srv.Close)
+        return srv
+    }
+    stable, canary := pool("stable"), pool("canary")
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "stable": {Backends: []string{stable.URL}},
+            "canary": {Backends: []string{canary.URL}},
+        },
+        Routes: []config.Route{{
+            Name:          "site",
+            Pool:          "stable",
+            Split:         []config.Split{{Pool: "stable", Weight: 50}, {Pool: "canary", Weight: 50}},
+            SplitOverride: config.SplitOverride{Header: "X-Canary", Values: map[string]string{"1": "canary", "0": "stable"}},
+            Cache:         config.RouteCache{Enabled: true},
+        }},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    handler := lb.Handler()
+
+    get := func(canaryHeader string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", "http://site.example.com/page", nil)
+        req.Header.Set("X-Canary", canaryHeader)
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+
+    // Ответ канарейки не попадает к клиентам основного пула и наоборот
+    for _, step := range []struct{ header, body, xcache string }{
+        {"1", "canary", "MISS"},
+        {"0", "stable", "MISS"},
+        {"1", "canary", "HIT"},
+        {"0", "stable", "HIT"},
+    } {
+        rec := get(step.header)
+        if rec.Body.String() != step.body || rec.Header().Get("X-Cache") != step.xcache {
+            t.Fatalf("X-Canary %s: expected %s (%s), got %s (%s)",
+                step.header, step.body, step.xcache, rec.Body.String(), rec.Header().Get("X-Cache"))
+        }
+    }
+
+    // Очистка по хосту и пути удаляет копии всех пулов
+    purge := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(purge, httptest.NewRequest("POST", "/cache?prefix=site.example.com/page", nil))
+    if !strings.Contains(purge.Body.String(), `"purged":2`) {
+        t.Fatalf("expected both pool variants to be purged: %s", purge.Body)
+    }
+}
+
+func TestCache_LockTimeoutAndUncacheableKeys(t *testing.T) {
+    var (
+        hangCalls atomic.Int32
+        inflight  atomic.Int32
+        peak      atomic.Int32
+    )
+    release := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/hang":
+            if hangCalls.Add(1) == 1 {
+                // Первый запрос зависает на backend'е
+                select {
+                case <-r.Context().Done():
+                case <-release:
+                }
+                return
+            }
+            w.Header().Set("Cache-Control", "max-age=60")
+            fmt.Fprint(w, "ok")
+        case "/nostore":
+            n := inflight.Add(1)
+            defer inflight.Add(-1)
+            for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+            }
+            time.Sleep(200 * time.Millisecond)
+            w.Header().Set("Cache-Control", "no-store")
+            fmt.Fprint(w, "private")
+        }
+    }))
+    t.Cleanup(backend.Close)
+    t.Cleanup(func() { close(release) }) // Выполняется раньше закрытия сервера
+
+    cfg := &config.Config{
+        Backends: []string{backend.URL},
+        Routes:   []config.Route{{Name: "site", Pool: "default", Cache: config.RouteCache{Enabled: true}}},
+    }
+    cfg.Cache.LockTimeout = 200 * time.Millisecond
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    h := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    get := func(path string) *httptest.ResponseRecorder {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, httptest.NewRequest("GET", "http://site.example.com"+path, nil).WithContext(ctx))
+        return rec
+    }
+
+    // Первый запрос завис: промах ждёт его не дольше lock_timeout и идёт к backend'у сам
+    go get("/hang")
+    time.Sleep(50 * time.Millisecond)
+    start := time.Now()
+    if rec := get("/hang"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+        t.Fatalf("expected own response after lock timeout, got %d %q", rec.Code, rec.Body.String())
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("miss waited %s for a hung leader", elapsed)
+    }
+
+    // Ответ не сохраняется: одновременные промахи идут к backend'у параллельно, не дожидаясь первого
+    get("/nostore")
+    var wg sync.WaitGroup
+    for i := 0; i < 5; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if rec := get("/nostore"); rec.Body.String() != "private" {
+                t.Errorf("unexpected response %q", rec.Body.String())
+            }
+        }()
+    }
+    wg.Wait()
+    if p := peak.Load(); p != 5 {
+        t.Fatalf("expected uncacheable misses to reach backend concurrently, peak %d", p)
+    }
+}
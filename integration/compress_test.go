@@ -0,0 +1,302 @@
+package integration
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "github.com/andybalholm/brotli"
+    "github.com/klauspost/compress/zstd"
+    "go.uber.org/zap"
+)
+
+func TestCompression_NegotiationAndSkips(t *testing.T) {
+    page := strings.Repeat("<p>hello, load balancer</p>\n", 200)
+
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/page":
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            w.Header().Set("ETag", `"p1"`)
+            io.WriteString(w, page)
+        case "/small":
+            w.Header().Set("Content-Type", "application/json")
+            io.WriteString(w, `{"ok":true}`)
+        case "/chunked":
+            // Без Content-Length: размер решается по первым записанным байтам
+            w.Header().Set("Content-Type", "application/json")
+            for i := 0; i < 100; i++ {
+                io.WriteString(w, `{"item":"value"},`)
+                w.(http.Flusher).Flush()
+            }
+        case "/image":
+            w.Header().Set("Content-Type", "image/png")
+            w.Write(bytes.Repeat([]byte{0x89}, 4096))
+        case "/encoded":
+            w.Header().Set("Content-Type", "text/plain")
+            w.Header().Set("Content-Encoding", "gzip")
+            gz := gzip.NewWriter(w)
+            io.WriteString(gz, page)
+            gz.Close()
+        case "/events":
+            w.Header().Set("Content-Type", "text/event-stream")
+            io.WriteString(w, strings.Repeat("data: tick\n\n", 200))
+        }
+    }))
+    defer backend.Close()
+
+    cfg := &config.Config{
+        Pools:  map[string]config.Pool{"web": {Backends: []string{backend.URL}}},
+        Routes: []config.Route{{Name: "web", Pool: "web"}},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.Compression.Enabled = true
+    handler := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    get := func(path, accept string, header ...string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", path, nil)
+        if accept != "" {
+            req.Header.Set("Accept-Encoding", accept)
+        }
+        for i := 0; i+1 < len(header); i += 2 {
+            req.Header.Set(header[i], header[i+1])
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s: expected 200, got %d", path, rec.Code)
+        }
+        return rec
+    }
+
+    decode := func(encoding string, body []byte) string {
+        var r io.Reader
+        switch encoding {
+        case "gzip":
+            gz, err := gzip.NewReader(bytes.NewReader(body))
+            if err != nil {
+                t.Fatalf("gzip: %v", err)
+            }
+            r = gz
+        case "br":
+            r = brotli.NewReader(bytes.NewReader(body))
+        case "zstd":
+            zr, err := zstd.NewReader(bytes.NewReader(body))
+            if err != nil {
+                t.Fatalf("zstd: %v", err)
+            }
+            defer zr.Close()
+            r = zr
+        default:
+            return string(body)
+        }
+        out, err := io.ReadAll(r)
+        if err != nil {
+            t.Fatalf("%s: failed to decode body: %v", encoding, err)
+        }
+        return string(out)
+    }
+
+    // Кодировка выбирается по q-значениям, при равных — по предпочтению сервера
+    cases := []struct{ accept, want string }{
+        {"gzip", "gzip"},
+        {"gzip, deflate, br", "br"},
+        {"gzip;q=1.0, br;q=0.5", "gzip"},
+        {"zstd, gzip", "zstd"},
+        {"*", "br"},
+        {"br;q=0, gzip", "gzip"},
+    }
+    for _, tc := range cases {
+        rec := get("/page", tc.accept)
+        if got := rec.Header().Get("Content-Encoding"); got != tc.want {
+            t.Errorf("Accept-Encoding %q: expected %q, got %q", tc.accept, tc.want, got)
+            continue
+        }
+        if rec.Header().Get("Content-Length") != "" {
+            t.Errorf("Accept-Encoding %q: compressed response must not keep Content-Length", tc.accept)
+        }
+        if got := rec.Header().Get("Vary"); !strings.Contains(got, "Accept-Encoding") {
+            t.Errorf("Accept-Encoding %q: expected Vary: Accept-Encoding, got %q", tc.accept, got)
+        }
+        if got := rec.Header().Get("ETag"); got != `W/"p1"` {
+            t.Errorf("Accept-Encoding %q: expected weak ETag, got %q", tc.accept, got)
+        }
+        if rec.Body.Len() >= len(page) {
+            t.Errorf("Accept-Encoding %q: body was not compressed (%d bytes)", tc.accept, rec.Body.Len())
+        }
+        if got := decode(tc.want, rec.Body.Bytes()); got != page {
+            t.Errorf("Accept-Encoding %q: decoded body does not match", tc.accept)
+        }
+    }
+
+    // Ответ без Content-Length, который backend сбрасывает по частям, тоже сжимается
+    rec := get("/chunked", "gzip")
+    if rec.Header().Get("Content-Encoding") != "gzip" {
+        t.Fatalf("chunked response should be compressed, got headers %v", rec.Header())
+    }
+    if got := decode("gzip", rec.Body.Bytes()); got != strings.Repeat(`{"item":"value"},`, 100) {
+        t.Fatalf("chunked response decoded to %d bytes", len(got))
+    }
+
+    // Клиент без Accept-Encoding получает исходный ответ, но с Vary для кэшей
+    rec = get("/page", "")
+    if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != page {
+        t.Fatalf("client without Accept-Encoding got an encoded response")
+    }
+    if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+        t.Fatalf("expected Vary: Accept-Encoding on plain response, got %q", got)
+    }
+
+    // Ответы, которые не сжимаются: маленькие, не того типа, уже сжатые, потоковые, Range
+    skips := []struct {
+        path   string
+        header []string
+    }{
+        {"/small", nil},
+        {"/image", nil},
+        {"/encoded", nil},
+        {"/events", nil},
+        {"/page", []string{"Range", "bytes=0-99"}},
+    }
+    for _, tc := range skips {
+        rec := get(tc.path, "gzip, br", tc.header...)
+        got := rec.Header().Get("Content-Encoding")
+        if tc.path == "/encoded" {
+            if got != "gzip" || decode("gzip", rec.Body.Bytes()) != page {
+                t.Errorf("%s: backend encoding should pass through unchanged, got %q", tc.path, got)
+            }
+            continue
+        }
+        if got != "" {
+            t.Errorf("%s %v: expected no compression, got %q", tc.path, tc.header, got)
+        }
+    }
+    if rec := get("/small", "gzip"); rec.Header().Get("Content-Length") != "11" || rec.Body.String() != `{"ok":true}` {
+        t.Errorf("small response should keep Content-Length, got %q", rec.Header().Get("Content-Length"))
+    }
+}
+
+func TestCompression_ReusedEncoders(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain")
+        io.WriteString(w, strings.Repeat(r.URL.Path, 500))
+    }))
+    defer backend.Close()
+
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10000, 1000
+    cfg.Compression.Enabled = true
+    handler := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    // Кодировщики берутся из пула: ответы не должны смешиваться между запросами
+    var wg sync.WaitGroup
+    for i := 0; i < 60; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            encoding := []string{"gzip", "br", "zstd"}[i%3]
+            path := fmt.Sprintf("/item-%d", i)
+            req := httptest.NewRequest("GET", path, nil)
+            req.Header.Set("Accept-Encoding", encoding)
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            if got := rec.Header().Get("Content-Encoding"); got != encoding {
+                t.Errorf("%s: expected %s, got %q", path, encoding, got)
+                return
+            }
+
+            var r io.Reader
+            switch encoding {
+            case "gzip":
+                gz, err := gzip.NewReader(rec.Body)
+                if err != nil {
+                    t.Errorf("%s: gzip: %v", path, err)
+                    return
+                }
+                r = gz
+            case "br":
+                r = brotli.NewReader(rec.Body)
+            case "zstd":
+                zr, err := zstd.NewReader(rec.Body)
+                if err != nil {
+                    t.Errorf("%s: zstd: %v", path, err)
+                    return
+                }
+                defer zr.Close()
+                r = zr
+            }
+            body, err := io.ReadAll(r)
+            if err != nil || string(body) != strings.Repeat(path, 500) {
+                t.Errorf("%s: %s body was corrupted (%d bytes, %v)", path, encoding, len(body), err)
+            }
+        }(i)
+    }
+    wg.Wait()
+}
+
+func TestCompression_StreamingResponsesAreNotBuffered(t *testing.T) {
+    release := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/ndjson":
+            w.Header().Set("Content-Type", "application/x-ndjson")
+        case "/tail":
+            // Тип из списка сжимаемых, но backend просит не буферизовать ответ
+            w.Header().Set("Content-Type", "text/plain")
+            w.Header().Set("X-Accel-Buffering", "no")
+        }
+        io.WriteString(w, "{\"line\":1}\n")
+        w.(http.Flusher).Flush()
+        select {
+        case <-release:
+        case <-r.Context().Done():
+        }
+        io.WriteString(w, "{\"line\":2}\n")
+    }))
+    t.Cleanup(backend.Close)
+    t.Cleanup(func() { close(release) }) // Выполняется раньше backend.Close
+
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.Compression.Enabled = true
+    lb := httptest.NewServer(proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler())
+    t.Cleanup(lb.Close)
+
+    for _, path := range []string{"/ndjson", "/tail"} {
+        req, _ := http.NewRequest("GET", lb.URL+path, nil)
+        req.Header.Set("Accept-Encoding", "gzip")
+        resp, err := http.DefaultTransport.RoundTrip(req)
+        if err != nil {
+            t.Fatalf("%s: %v", path, err)
+        }
+        if got := resp.Header.Get("Content-Encoding"); got != "" {
+            t.Errorf("%s: streaming response should not be compressed, got %q", path, got)
+        }
+
+        // Первая строка приходит, пока backend ещё не закончил ответ
+        line := make(chan string, 1)
+        go func() {
+            s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+            line <- s
+        }()
+        select {
+        case got := <-line:
+            if got != "{\"line\":1}\n" {
+                t.Errorf("%s: unexpected first line %q", path, got)
+            }
+        case <-time.After(2 * time.Second):
+            t.Errorf("%s: first line was held back by the compressor", path)
+        }
+        resp.Body.Close()
+    }
+}
@@ -0,0 +1,88 @@
+package integration
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/ratelimiter"
+    "go.uber.org/zap"
+)
+
+func TestConcurrencyLimiter_PerClientAndGlobal(t *testing.T) {
+    cl := ratelimiter.NewConcurrencyLimiter(2, 3)
+
+    for i := 0; i < 2; i++ {
+        if err := cl.Acquire("client1"); err != nil {
+            t.Fatalf("client1 request %d should be admitted: %v", i+1, err)
+        }
+    }
+    if err := cl.Acquire("client1"); !errors.Is(err, ratelimiter.ErrClientConcurrency) {
+        t.Fatalf("expected ErrClientConcurrency, got %v", err)
+    }
+
+    if err := cl.Acquire("client2"); err != nil {
+        t.Fatalf("client2 should be admitted: %v", err)
+    }
+    if err := cl.Acquire("client3"); !errors.Is(err, ratelimiter.ErrGlobalConcurrency) {
+        t.Fatalf("expected ErrGlobalConcurrency, got %v", err)
+    }
+
+    cl.Release("client1")
+    if err := cl.Acquire("client3"); err != nil {
+        t.Fatalf("client3 should be admitted after release: %v", err)
+    }
+}
+
+func TestBalancer_PerBackendLimitSpillsOver(t *testing.T) {
+    rr := balancer.NewRoundRobin([]string{"http://localhost:9001", "http://localhost:9002"}, zap.NewNop().Sugar())
+    rr.SetMaxConnsPerBackend(1)
+
+    first, err := rr.NextBackend("")
+    if err != nil {
+        t.Fatalf("first pick failed: %v", err)
+    }
+    second, err := rr.NextBackend("")
+    if err != nil {
+        t.Fatalf("second pick failed: %v", err)
+    }
+    if first == second {
+        t.Fatal("second request should spill over to another backend")
+    }
+
+    if _, err := rr.NextBackend(""); !errors.Is(err, balancer.ErrBackendsAtCapacity) {
+        t.Fatalf("expected ErrBackendsAtCapacity, got %v", err)
+    }
+
+    first.Release()
+    if b, err := rr.NextBackend(""); err != nil || b != first {
+        t.Fatalf("expected freed backend to be picked, got %v, %v", b, err)
+    }
+}
+
+func TestAdaptiveLimit_ShrinksOnSlowdownAndRecovers(t *testing.T) {
+    al := balancer.NewAdaptiveLimit(balancer.AdaptiveOptions{InitialLimit: 10, MinLimit: 2, MaxLimit: 20})
+
+    // Задаём базовую задержку
+    for i := 0; i < 10; i++ {
+        al.Observe(10*time.Millisecond, false)
+    }
+    base := al.Limit()
+
+    // Backend замедлился в 5 раз — лимит должен упасть до минимума
+    for i := 0; i < 50; i++ {
+        al.Observe(50*time.Millisecond, false)
+    }
+    if got := al.Limit(); got != 2 {
+        t.Fatalf("expected limit to shrink to min 2, got %d (was %d)", got, base)
+    }
+
+    // Задержка вернулась в норму — лимит снова растёт
+    for i := 0; i < 100; i++ {
+        al.Observe(10*time.Millisecond, false)
+    }
+    if got := al.Limit(); got <= 2 {
+        t.Fatalf("expected limit to grow after recovery, got %d", got)
+    }
+}
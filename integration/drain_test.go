@@ -0,0 +1,278 @@
+package integration
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+)
+
+func TestDrain_InFlightCompletesAndStickyClientsMove(t *testing.T) {
+    release := make(chan struct{})
+    started := make(chan struct{}, 1)
+    slow := func(name string) *httptest.Server {
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if r.URL.Path == "/slow" {
+                started <- struct{}{}
+                <-release
+            }
+            fmt.Fprint(w, name)
+        }))
+        t.Cleanup(srv.Close)
+        return srv
+    }
+    a, b := slow("a"), slow("b")
+
+    cfg := &config.Config{
+        Pools:  map[string]config.Pool{"app": {Backends: []string{a.URL, b.URL}, Strategy: "ip_hash"}},
+        Routes: []config.Route{{Name: "app", Pool: "app"}},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    handler, admin := lb.Handler(), lb.AdminHandler()
+
+    get := func(client, path string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", path, nil)
+        req.Header.Set("X-Real-IP", client)
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+    backends := func(method, query string) []map[string]any {
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, httptest.NewRequest(method, "/backends?"+query, nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s /backends?%s: %d %s", method, query, rec.Code, rec.Body)
+        }
+        var infos []map[string]any
+        if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+            t.Fatalf("invalid /backends response: %v", err)
+        }
+        return infos
+    }
+
+    // Клиент, закреплённый за backend'ом b
+    client := ""
+    for i := 0; i < 100 && client == ""; i++ {
+        ip := fmt.Sprintf("10.0.0.%d", i)
+        if get(ip, "/").Body.String() == "b" {
+            client = ip
+        }
+    }
+    if client == "" {
+        t.Fatal("no client is pinned to backend b")
+    }
+
+    // Запрос в обработке на b
+    done := make(chan *httptest.ResponseRecorder)
+    go func() { done <- get(client, "/slow") }()
+    select {
+    case <-started:
+    case <-time.After(2 * time.Second):
+        t.Fatal("slow request did not reach the backend")
+    }
+
+    addr := url.QueryEscape(b.Listener.Addr().String())
+    infos := backends("POST", "backend="+addr+"&drain=true")
+    if len(infos) != 1 || infos[0]["draining"] != true || infos[0]["active_requests"] != 1.0 || infos[0]["drained"] != false {
+        t.Fatalf("unexpected state after drain: %v", infos)
+    }
+
+    // Новые запросы закреплённого клиента уходят на другой backend
+    for i := 0; i < 5; i++ {
+        if got := get(client, "/").Body.String(); got != "a" {
+            t.Fatalf("draining backend received a new request: %q", got)
+        }
+    }
+
+    // Пока запрос не завершён, ожидание заканчивается по таймауту
+    if infos := backends("GET", "backend="+addr+"&wait=100ms"); infos[0]["drained"] != false {
+        t.Fatalf("backend reported drained with a request in flight: %v", infos)
+    }
+
+    // Запрос в обработке завершается на b как обычно
+    go func() {
+        time.Sleep(100 * time.Millisecond)
+        close(release)
+    }()
+    start := time.Now()
+    infos = backends("GET", "backend="+addr+"&wait=5s")
+    if infos[0]["drained"] != true || infos[0]["active_requests"] != 0.0 {
+        t.Fatalf("expected backend to be drained, got %v", infos)
+    }
+    if time.Since(start) > 2*time.Second {
+        t.Fatalf("wait did not return once the backend drained")
+    }
+    if rec := <-done; rec.Code != http.StatusOK || rec.Body.String() != "b" {
+        t.Fatalf("in-flight request failed: %d %q", rec.Code, rec.Body)
+    }
+
+    // Когда выведены все backend'ы, новым запросам некуда идти
+    backends("POST", "backend="+url.QueryEscape(a.URL))
+    if rec := get(client, "/"); rec.Code != http.StatusServiceUnavailable {
+        t.Fatalf("expected 503 with every backend draining, got %d", rec.Code)
+    }
+
+    // После возврата в ротацию клиент снова попадает на свой backend
+    backends("POST", "backend="+url.QueryEscape(a.URL)+"&drain=false")
+    backends("PUT", "pool=app&backend="+addr+"&drain=false")
+    if got := get(client, "/").Body.String(); got != "b" {
+        t.Fatalf("client did not return to backend b: %q", got)
+    }
+
+    // Неизвестный backend
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, httptest.NewRequest("POST", "/backends?backend=10.9.9.9:80", nil))
+    if rec.Code != http.StatusNotFound {
+        t.Fatalf("expected 404 for unknown backend, got %d", rec.Code)
+    }
+}
+
+func TestDrain_TCPAndUDPSessionsCloseAfterGrace(t *testing.T) {
+    tcpA, tcpB := newTCPBackend(t, "a"), newTCPBackend(t, "b")
+    udpA, udpB := newUDPBackend(t, "a"), newUDPBackend(t, "b")
+
+    tc := config.TCPListener{Name: "pg", Port: freePort(t), Backends: []string{tcpA.Addr().String(), tcpB.Addr().String()}}
+    uc := config.UDPListener{Name: "syslog", Port: freePort(t), Backends: []string{udpA.LocalAddr().String(), udpB.LocalAddr().String()}}
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}, TCP: []config.TCPListener{tc}, UDP: []config.UDPListener{uc}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr)
+    admin := lb.AdminHandler()
+
+    backends := func(method, query string) []map[string]any {
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, httptest.NewRequest(method, "/backends?"+query, nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s /backends?%s: %d %s", method, query, rec.Code, rec.Body)
+        }
+        var infos []map[string]any
+        if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+            t.Fatalf("invalid /backends response: %v", err)
+        }
+        return infos
+    }
+
+    // UDP-клиент шлёт датаграммы без перерыва, TCP-соединение просто открыто
+    udpClient, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: uc.Port})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { udpClient.Close() })
+    first := udpExchange(t, udpClient, "log")
+    udpBackend := map[string]*net.UDPConn{"a:log": udpA, "b:log": udpB}[first]
+    if udpBackend == nil {
+        t.Fatalf("no reply through udp listener: %q", first)
+    }
+
+    held := dialTCP(t, fmt.Sprintf("127.0.0.1:%d", tc.Port))
+    time.Sleep(50 * time.Millisecond)
+    tcpBackend := tcpA // round_robin: первое соединение — на a
+    if infos := backends("GET", "pool=pg&backend="+url.QueryEscape(tcpA.Addr().String())); infos[0]["active_requests"] != 1.0 {
+        tcpBackend = tcpB
+    }
+
+    for _, q := range []string{
+        "pool=pg&backend=" + url.QueryEscape(tcpBackend.Addr().String()),
+        "pool=syslog&backend=" + url.QueryEscape(udpBackend.LocalAddr().String()),
+    } {
+        if infos := backends("POST", q+"&grace=200ms"); infos[0]["drained"] != false {
+            t.Fatalf("backend with an open session reported drained: %v", infos)
+        }
+    }
+
+    // Пока идёт grace, сессия остаётся на прежнем backend'е
+    if reply := udpExchange(t, udpClient, "log"); reply != first {
+        t.Fatalf("udp session moved before grace expired: %q", reply)
+    }
+
+    // После grace обе сессии закрываются, и wait дожидается drained
+    for _, q := range []string{
+        "pool=pg&backend=" + url.QueryEscape(tcpBackend.Addr().String()),
+        "pool=syslog&backend=" + url.QueryEscape(udpBackend.LocalAddr().String()),
+    } {
+        if infos := backends("GET", q+"&wait=2s"); infos[0]["drained"] != true {
+            t.Fatalf("backend was not drained after grace: %v", infos)
+        }
+    }
+    held.SetReadDeadline(time.Now().Add(time.Second))
+    if _, err := held.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+        t.Fatalf("expected tcp connection to draining backend to be closed, got %v", err)
+    }
+
+    // Следующая датаграмма клиента открывает сессию на другом backend'е
+    if reply := udpExchange(t, udpClient, "log"); reply == first || reply == "" {
+        t.Fatalf("expected udp client to move off the draining backend, got %q", reply)
+    }
+}
+
+func TestDrain_UpgradedConnectionsCloseAfterGrace(t *testing.T) {
+    a := newEchoUpgradeBackend(t, "a")
+    b := newEchoUpgradeBackend(t, "b")
+
+    cfg := &config.Config{
+        Pools:  map[string]config.Pool{"ws": {Backends: []string{a.URL, b.URL}}},
+        Routes: []config.Route{{Name: "ws", Pool: "ws"}},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    srv := httptest.NewServer(lb.Handler())
+    t.Cleanup(srv.Close)
+    admin := lb.AdminHandler()
+
+    conn, br, name := dialUpgrade(t, srv.Listener.Addr().String())
+    defer conn.Close()
+    target := map[string]string{"a": a.URL, "b": b.URL}[name]
+
+    drain := func(method, query string) []map[string]any {
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, httptest.NewRequest(method, "/backends?pool=ws&backend="+url.QueryEscape(target)+query, nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s /backends%s: %d %s", method, query, rec.Code, rec.Body)
+        }
+        var infos []map[string]any
+        if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+            t.Fatalf("invalid /backends response: %v", err)
+        }
+        return infos
+    }
+    echo := func(conn net.Conn, br *bufio.Reader, msg string) error {
+        conn.SetDeadline(time.Now().Add(time.Second))
+        fmt.Fprintf(conn, "%s\n", msg)
+        line, err := br.ReadString('\n')
+        if err == nil && line != msg+"\n" {
+            t.Fatalf("expected echo %q, got %q", msg, line)
+        }
+        return err
+    }
+
+    // Backend вернули в ротацию до истечения grace — соединение остаётся открытым
+    drain("POST", "&drain=true&grace=100ms")
+    drain("POST", "&drain=false")
+    time.Sleep(250 * time.Millisecond)
+    if err := echo(conn, br, "still-open"); err != nil {
+        t.Fatalf("connection closed although backend is back in rotation: %v", err)
+    }
+
+    // Backend остался в drain — после grace соединение закрывается, и wait дожидается drained
+    drain("POST", "&drain=true&grace=100ms")
+    if infos := drain("GET", "&wait=2s"); infos[0]["drained"] != true {
+        t.Fatalf("backend with an upgraded connection was not drained: %v", infos)
+    }
+    if err := echo(conn, br, "closed"); err == nil {
+        t.Fatal("expected upgraded connection to draining backend to be closed")
+    }
+}
@@ -0,0 +1,333 @@
+package integration
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/health"
+    healthpb "google.golang.org/grpc/health/grpc_health_v1"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// grpcBackend — gRPC-сервер в процессе теста. Любой метод принимает и
+// возвращает StringValue, поэтому кодогенерация не нужна.
+type grpcBackend struct {
+    name    string
+    url     string
+    health  *health.Server
+    failing atomic.Bool  // Отвечать UNAVAILABLE на все вызовы
+    calls   atomic.Int32 // Вызовов /test.Echo/*
+}
+
+func newGRPCBackend(t *testing.T, name string) *grpcBackend {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("listen: %v", err)
+    }
+    b := &grpcBackend{name: name, url: "http://" + ln.Addr().String(), health: health.NewServer()}
+
+    srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+        b.calls.Add(1)
+        in := new(wrapperspb.StringValue)
+        if err := stream.RecvMsg(in); err != nil {
+            return err
+        }
+        stream.SetTrailer(metadata.Pairs("backend", b.name))
+        if b.failing.Load() {
+            return status.Error(codes.Unavailable, "backend is failing")
+        }
+        method, _ := grpc.MethodFromServerStream(stream)
+        if method == "/test.Echo/Reject" {
+            return status.Errorf(codes.FailedPrecondition, "rejected: %s", in.GetValue())
+        }
+        return stream.SendMsg(wrapperspb.String(b.name))
+    }))
+    healthpb.RegisterHealthServer(srv, b.health)
+    go srv.Serve(ln)
+    t.Cleanup(srv.Stop)
+    return b
+}
+
+func TestGRPC_BalancingErrorsRetriesAndHealth(t *testing.T) {
+    a := newGRPCBackend(t, "a")
+    b := newGRPCBackend(t, "b")
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "grpc": {
+                Backends:    []string{a.url, b.url},
+                Protocol:    "h2c",
+                HealthCheck: config.HealthCheck{Protocol: "grpc", Interval: 50 * time.Millisecond},
+            },
+        },
+        Routes: []config.Route{{
+            Name:       "echo",
+            PathPrefix: "/test.Echo/",
+            Pool:       "grpc",
+            Retry:      config.Retry{Attempts: 1, GRPCCodes: []string{"UNAVAILABLE"}},
+        }},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.HTTP2.H2C = true
+    addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(addr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, addr)
+
+    conn, err := grpc.NewClient("passthrough:///"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatalf("grpc client: %v", err)
+    }
+    t.Cleanup(func() { conn.Close() })
+
+    call := func(method string) (string, metadata.MD, error) {
+        ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+        defer cancel()
+        var trailer metadata.MD
+        out := new(wrapperspb.StringValue)
+        err := conn.Invoke(ctx, method, wrapperspb.String("ping"), out, grpc.Trailer(&trailer))
+        return out.GetValue(), trailer, err
+    }
+
+    // Одно HTTP/2-соединение клиента, но каждый вызов балансируется отдельно
+    const calls = 10
+    var (
+        mu   sync.Mutex
+        hits = map[string]int{}
+        wg   sync.WaitGroup
+    )
+    for i := 0; i < calls; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            name, trailer, err := call("/test.Echo/Name")
+            if err != nil {
+                t.Errorf("call failed: %v", err)
+                return
+            }
+            if got := trailer.Get("backend"); len(got) != 1 || got[0] != name {
+                t.Errorf("expected trailer backend=%s, got %v", name, got)
+            }
+            mu.Lock()
+            hits[name]++
+            mu.Unlock()
+        }()
+    }
+    wg.Wait()
+    if hits["a"] != calls/2 || hits["b"] != calls/2 {
+        t.Fatalf("expected calls split evenly between backends, got %v", hits)
+    }
+
+    // Статус и трейлеры backend'а доходят до клиента без изменений
+    _, trailer, err := call("/test.Echo/Reject")
+    if st := status.Convert(err); st.Code() != codes.FailedPrecondition || st.Message() != "rejected: ping" {
+        t.Fatalf("expected FailedPrecondition from backend, got %v", err)
+    }
+    if len(trailer.Get("backend")) != 1 {
+        t.Fatalf("expected backend trailer on error, got %v", trailer)
+    }
+
+    // Ошибки балансировщика — статусы gRPC, а не JSON
+    _, _, err = call("/other.Service/Method")
+    if st := status.Convert(err); st.Code() != codes.Unimplemented || st.Message() != "no route for request" {
+        t.Fatalf("expected Unimplemented for unrouted method, got %v", err)
+    }
+
+    // UNAVAILABLE от backend'а повторяется на другом backend'е пула
+    a.failing.Store(true)
+    for i := 0; i < calls; i++ {
+        name, _, err := call("/test.Echo/Name")
+        if err != nil {
+            t.Fatalf("expected call to be retried, got %v", err)
+        }
+        if name != "b" {
+            t.Fatalf("expected retry to succeed on b, got %s", name)
+        }
+    }
+    a.failing.Store(false)
+
+    // NOT_SERVING по grpc.health.v1 выводит backend из ротации
+    a.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+    time.Sleep(200 * time.Millisecond)
+    before := a.calls.Load()
+    for i := 0; i < calls; i++ {
+        if name, _, err := call("/test.Echo/Name"); err != nil || name != "b" {
+            t.Fatalf("expected only b while a is not serving, got %q, %v", name, err)
+        }
+    }
+    if n := a.calls.Load(); n != before {
+        t.Fatalf("expected no calls to not serving backend, got %d", n-before)
+    }
+
+    a.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+    time.Sleep(200 * time.Millisecond)
+    hits = map[string]int{}
+    for i := 0; i < calls; i++ {
+        name, _, err := call("/test.Echo/Name")
+        if err != nil {
+            t.Fatalf("call failed: %v", err)
+        }
+        hits[name]++
+    }
+    if hits["a"] == 0 {
+        t.Fatalf("expected a back in rotation after SERVING, got %v", hits)
+    }
+}
+
+func TestGRPC_RetrySkipsTriedBackends(t *testing.T) {
+    backends := map[string]*grpcBackend{"a": newGRPCBackend(t, "a"), "b": newGRPCBackend(t, "b")}
+
+    // ip_hash вернул бы повтор на тот же backend, за которым закреплён клиент
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "grpc": {Backends: []string{backends["a"].url, backends["b"].url}, Protocol: "h2c", Strategy: "ip_hash"},
+        },
+        Routes: []config.Route{{
+            Name:       "echo",
+            PathPrefix: "/test.Echo/",
+            Pool:       "grpc",
+            Retry:      config.Retry{Attempts: 2},
+        }},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.HTTP2.H2C = true
+    addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(addr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, addr)
+
+    conn, err := grpc.NewClient("passthrough:///"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatalf("grpc client: %v", err)
+    }
+    t.Cleanup(func() { conn.Close() })
+
+    call := func() (string, error) {
+        ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+        defer cancel()
+        out := new(wrapperspb.StringValue)
+        err := conn.Invoke(ctx, "/test.Echo/Name", wrapperspb.String("ping"), out)
+        return out.GetValue(), err
+    }
+
+    pinned, err := call()
+    if err != nil {
+        t.Fatalf("call failed: %v", err)
+    }
+    other := "a"
+    if pinned == "a" {
+        other = "b"
+    }
+
+    backends[pinned].failing.Store(true)
+    before := backends[pinned].calls.Load()
+    name, err := call()
+    if err != nil || name != other {
+        t.Fatalf("expected retry on %s, got %q, %v", other, name, err)
+    }
+    if n := backends[pinned].calls.Load() - before; n != 1 {
+        t.Fatalf("expected failing backend to be tried once, got %d calls", n)
+    }
+
+    // Все backend'ы уже опробованы: оставшаяся попытка не тратится на них повторно
+    backends[other].failing.Store(true)
+    before = backends[pinned].calls.Load() + backends[other].calls.Load()
+    if _, err := call(); status.Code(err) != codes.Unavailable {
+        t.Fatalf("expected Unavailable when every backend fails, got %v", err)
+    }
+    if n := backends[pinned].calls.Load() + backends[other].calls.Load() - before; n != 2 {
+        t.Fatalf("expected each backend to be tried once, got %d calls", n)
+    }
+}
+
+func TestGRPC_HealthCheckRequiresHTTP2Pool(t *testing.T) {
+    for _, protocol := range []string{"", "http1"} {
+        cfg := &config.Config{
+            Pools: map[string]config.Pool{
+                "grpc": {Backends: []string{"http://127.0.0.1:50051"}, Protocol: protocol, HealthCheck: config.HealthCheck{Protocol: "grpc"}},
+            },
+            Routes: []config.Route{{Name: "grpc", Pool: "grpc"}},
+        }
+        err := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).ConfigError()
+        if err == nil || !strings.Contains(err.Error(), "requires pool protocol") {
+            t.Errorf("pool protocol %q: expected grpc health check to be rejected, got %v", protocol, err)
+        }
+    }
+}
+
+func TestGRPC_RetryFailureMarksOnlyRetryTarget(t *testing.T) {
+    a := newGRPCBackend(t, "a")
+    a.failing.Store(true)
+    down := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+
+    // round_robin начинает со второго backend'а: первая попытка — на a, повтор — на down
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "grpc": {Backends: []string{down, a.url}, Protocol: "h2c"},
+        },
+        Routes: []config.Route{{
+            Name:       "echo",
+            PathPrefix: "/test.Echo/",
+            Pool:       "grpc",
+            Retry:      config.Retry{Attempts: 1},
+        }},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.HTTP2.H2C = true
+    addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(addr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, addr)
+
+    conn, err := grpc.NewClient("passthrough:///"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatalf("grpc client: %v", err)
+    }
+    t.Cleanup(func() { conn.Close() })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    if err := conn.Invoke(ctx, "/test.Echo/Name", wrapperspb.String("ping"), new(wrapperspb.StringValue)); status.Code(err) != codes.Unavailable {
+        t.Fatalf("expected Unavailable when the retry target is down, got %v", err)
+    }
+    if n := a.calls.Load(); n != 1 {
+        t.Fatalf("expected one call to a before the retry, got %d", n)
+    }
+
+    // Ошибку соединения получил только backend повтора; a ответил и остаётся в ротации
+    rec := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/backends?pool=grpc", nil))
+    var infos []map[string]any
+    if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+        t.Fatalf("invalid /backends response: %v", err)
+    }
+    alive := map[string]any{}
+    for _, info := range infos {
+        alive[info["backend"].(string)] = info["alive"]
+    }
+    if alive[a.url] != true || alive[down] != false {
+        t.Fatalf("expected only the retry target to be marked dead, got %v", alive)
+    }
+}
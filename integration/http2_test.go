@@ -0,0 +1,121 @@
+package integration
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
+)
+
+// newH2CBackend поднимает backend, принимающий HTTP/2 без TLS. Отвечает своим
+// именем и версией протокола, по которой пришёл запрос.
+func newH2CBackend(t *testing.T, name string) *httptest.Server {
+    srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(20 * time.Millisecond) // Запросы должны пересекаться во времени
+        fmt.Fprintf(w, "%s %s", name, r.Proto)
+    }), &http2.Server{}))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func TestHTTP2_MultiplexedRequestsAreBalanced(t *testing.T) {
+    a := newH2CBackend(t, "a")
+    b := newH2CBackend(t, "b")
+
+    dir := t.TempDir()
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "h2": {Backends: []string{a.URL, b.URL}, Protocol: "h2c"},
+        },
+        Routes: []config.Route{{Name: "h2", Pool: "h2"}},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.HTTP2.H2C = true
+    cfg.TLS = config.TLS{Port: freePort(t), Certificates: []config.Certificate{writeCert(t, dir, "lb", 1, "lb.example.com")}}
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+    tlsAddr := fmt.Sprintf("127.0.0.1:%d", cfg.TLS.Port)
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr)
+    waitForListener(t, tlsAddr)
+
+    // h2c с prior knowledge: все запросы идут по одному TCP-соединению
+    var dials atomic.Int32
+    client := &http.Client{Transport: &http2.Transport{
+        AllowHTTP: true,
+        DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+            dials.Add(1)
+            var d net.Dialer
+            return d.DialContext(ctx, network, addr)
+        },
+    }}
+
+    const requests = 20
+    var (
+        mu   sync.Mutex
+        hits = map[string]int{}
+        wg   sync.WaitGroup
+    )
+    for i := 0; i < requests; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            resp, err := client.Get("http://" + plainAddr + "/")
+            if err != nil {
+                t.Errorf("h2c request failed: %v", err)
+                return
+            }
+            defer resp.Body.Close()
+            body, _ := io.ReadAll(resp.Body)
+            if resp.ProtoMajor != 2 {
+                t.Errorf("expected HTTP/2 response from balancer, got %s", resp.Proto)
+            }
+            name, proto, _ := strings.Cut(string(body), " ")
+            if proto != "HTTP/2.0" {
+                t.Errorf("backend %s received %s instead of HTTP/2", name, proto)
+            }
+            mu.Lock()
+            hits[name]++
+            mu.Unlock()
+        }()
+    }
+    wg.Wait()
+
+    if n := dials.Load(); n != 1 {
+        t.Fatalf("expected all requests multiplexed over one connection, got %d connections", n)
+    }
+    // Балансировка по запросам, а не по соединениям: round robin делит поровну
+    if hits["a"] != requests/2 || hits["b"] != requests/2 {
+        t.Fatalf("expected requests split evenly between backends, got %v", hits)
+    }
+
+    // HTTP/2 поверх TLS согласуется по ALPN
+    tlsClient := &http.Client{Transport: &http.Transport{
+        TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+        ForceAttemptHTTP2: true,
+    }}
+    resp, err := tlsClient.Get("https://" + tlsAddr + "/")
+    if err != nil {
+        t.Fatalf("HTTPS request failed: %v", err)
+    }
+    resp.Body.Close()
+    if resp.ProtoMajor != 2 {
+        t.Fatalf("expected HTTP/2 over TLS, got %s", resp.Proto)
+    }
+}
@@ -0,0 +1,121 @@
+package integration
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "github.com/Manzo48/loadBalancer/pkg/proxyproto"
+    "go.uber.org/zap"
+)
+
+// rawHTTPGet отправляет GET с произвольным префиксом (заголовком PROXY protocol)
+func rawHTTPGet(t *testing.T, addr string, prefix []byte) (int, string) {
+    t.Helper()
+    c, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    c.SetDeadline(time.Now().Add(2 * time.Second))
+    c.Write(prefix)
+    io.WriteString(c, "GET / HTTP/1.1\r\nHost: lb\r\nConnection: close\r\n\r\n")
+
+    resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+    if err != nil {
+        t.Fatalf("read response: %v", err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(resp.Body)
+    return resp.StatusCode, string(body)
+}
+
+// startProxyProtoBalancer запускает балансировщик с backend'ом, отвечающим IP клиента
+func startProxyProtoBalancer(t *testing.T, trusted string) string {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, r.Header.Get("X-Forwarded-For"))
+    }))
+    t.Cleanup(backend.Close)
+
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 100, 10
+    cfg.ProxyProtocol = config.ProxyProtocol{Enabled: true, TrustedCIDRs: []string{trusted}}
+    addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(addr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, addr)
+    return addr
+}
+
+func TestProxyProtocol_ClientAddressFromTrustedSources(t *testing.T) {
+    addr := startProxyProtoBalancer(t, "127.0.0.0/8")
+
+    // v1 и v2 от доверенного адреса восстанавливают IP клиента
+    v1 := []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n")
+    if code, body := rawHTTPGet(t, addr, v1); code != http.StatusOK || body != "203.0.113.7" {
+        t.Fatalf("expected client IP from v1 header, got %d %q", code, body)
+    }
+    v2, err := proxyproto.Format(2,
+        &net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 40000},
+        &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if code, body := rawHTTPGet(t, addr, v2); code != http.StatusOK || body != "2001:db8::5" {
+        t.Fatalf("expected client IP from v2 header, got %d %q", code, body)
+    }
+
+    // Без заголовка (required: false) соединение обслуживается как обычно
+    if code, body := rawHTTPGet(t, addr, nil); code != http.StatusOK || body != "127.0.0.1" {
+        t.Fatalf("expected connection without header to pass, got %d %q", code, body)
+    }
+
+    // От недоверенного адреса заголовок не разбирается: подменить IP нельзя
+    untrusted := startProxyProtoBalancer(t, "10.0.0.0/8")
+    if code, body := rawHTTPGet(t, untrusted, v1); code != http.StatusBadRequest || strings.Contains(body, "203.0.113.7") {
+        t.Fatalf("expected header from untrusted source to be rejected, got %d %q", code, body)
+    }
+    if code, body := rawHTTPGet(t, untrusted, nil); code != http.StatusOK || body != "127.0.0.1" {
+        t.Fatalf("expected plain request from untrusted source to pass, got %d %q", code, body)
+    }
+}
+
+func TestProxyProtocol_TCPListenerForwardsClientAddress(t *testing.T) {
+    backend := newTCPBackend(t, "pg")
+
+    tc := config.TCPListener{
+        Name:              "pg",
+        Port:              freePort(t),
+        Backends:          []string{backend.Addr().String()},
+        SendProxyProtocol: "v1",
+    }
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}, TCP: []config.TCPListener{tc}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    cfg.ProxyProtocol = config.ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"127.0.0.1"}}
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr)
+
+    // Заголовок v2 от облачного балансировщика превращается в v1 для backend'а
+    header, _ := proxyproto.Format(2,
+        &net.TCPAddr{IP: net.ParseIP("192.0.2.5"), Port: 4000},
+        &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5432})
+    c := dialTCP(t, fmt.Sprintf("127.0.0.1:%d", tc.Port))
+    reply := tcpExchange(t, c, string(header)+"hello")
+    if want := "pg:PROXY TCP4 192.0.2.5 192.0.2.1 4000 5432\r\nhello"; reply != want {
+        t.Fatalf("expected backend to receive client address, got %q", reply)
+    }
+}
@@ -0,0 +1,188 @@
+package integration
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "github.com/Manzo48/loadBalancer/pkg/quota"
+    "go.uber.org/zap"
+)
+
+func TestQuota_SurvivesRestart(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    path := filepath.Join(t.TempDir(), "quotas.log")
+    limits := []quota.Limit{{Period: quota.Daily, Max: 3}, {Period: quota.Monthly, Max: 100}}
+
+    m, err := quota.NewManager(path, limits, nil, logger)
+    if err != nil {
+        t.Fatalf("failed to create manager: %v", err)
+    }
+    for i := 0; i < 3; i++ {
+        if _, err := m.Allow("client1"); err != nil {
+            t.Fatalf("request %d should be within quota: %v", i+1, err)
+        }
+    }
+    if limit, err := m.Allow("client1"); !errors.Is(err, quota.ErrQuotaExceeded) || limit.Period != quota.Daily {
+        t.Fatalf("expected daily quota to be exhausted, got %v (%v)", err, limit.Period)
+    }
+    if err := m.Close(); err != nil {
+        t.Fatalf("failed to close manager: %v", err)
+    }
+
+    // После "перезапуска" счётчики восстанавливаются из журнала
+    m, err = quota.NewManager(path, limits, nil, logger)
+    if err != nil {
+        t.Fatalf("failed to reload manager: %v", err)
+    }
+    defer m.Close()
+
+    if _, err := m.Allow("client1"); !errors.Is(err, quota.ErrQuotaExceeded) {
+        t.Fatalf("quota should stay exhausted after restart, got %v", err)
+    }
+    for _, u := range m.Usage("client1") {
+        if u.Used != 3 {
+            t.Errorf("%s usage: expected 3, got %d", u.Period, u.Used)
+        }
+    }
+}
+
+func TestQuota_LogIsCompacted(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "quotas.log")
+    limits := []quota.Limit{{Period: quota.Daily, Max: 100000}, {Period: quota.Monthly, Max: 100000}}
+
+    m, err := quota.NewManager(path, limits, nil, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatalf("failed to create manager: %v", err)
+    }
+    for i := 0; i < 1000; i++ {
+        m.Allow("client1")
+        if err := m.Flush(); err != nil {
+            t.Fatalf("flush failed: %v", err)
+        }
+    }
+    if err := m.Close(); err != nil {
+        t.Fatalf("failed to close manager: %v", err)
+    }
+
+    // 2000 дозаписей не должны оставаться в журнале: он периодически переписывается
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if lines := bytes.Count(data, []byte("\n")); lines > 300 {
+        t.Fatalf("quota log grew to %d records", lines)
+    }
+
+    m, err = quota.NewManager(path, limits, nil, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatalf("failed to reload manager: %v", err)
+    }
+    defer m.Close()
+    for _, u := range m.Usage("client1") {
+        if u.Used != 1000 {
+            t.Errorf("%s usage after compaction: expected 1000, got %d", u.Period, u.Used)
+        }
+    }
+}
+
+// Счётчики, ещё не сброшенные на диск, сохраняются при штатной остановке балансировщика
+func TestQuota_PersistedOnShutdown(t *testing.T) {
+    backend := newNamedBackend(t, "app")
+    path := filepath.Join(t.TempDir(), "quotas.log")
+
+    cfg := &config.Config{Port: freePort(t), Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 100, 10
+    cfg.Quotas = config.Quotas{
+        File:          path,
+        FlushInterval: time.Hour, // Периодический сброс не успеет сработать
+        Limits:        []config.QuotaLimit{{Period: "daily", Limit: 100}},
+    }
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    addr := fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+    served := make(chan error, 1)
+    go func() { served <- lb.ListenAndServe(addr) }()
+    waitForListener(t, addr)
+
+    for i := 0; i < 3; i++ {
+        resp, err := http.Get("http://" + addr + "/")
+        if err != nil {
+            t.Fatalf("request failed: %v", err)
+        }
+        resp.Body.Close()
+    }
+    lb.Shutdown()
+    if err := <-served; err != nil {
+        t.Fatalf("ListenAndServe returned %v", err)
+    }
+
+    m, err := quota.NewManager(path, []quota.Limit{{Period: quota.Daily, Max: 100}}, nil, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatalf("failed to reload quotas: %v", err)
+    }
+    defer m.Close()
+    if u := m.Usage("127.0.0.1"); len(u) != 1 || u[0].Used != 3 {
+        t.Fatalf("expected 3 requests to survive restart, got %+v", u)
+    }
+}
+
+func TestQuota_ConcurrencyRejectionsAreNotCounted(t *testing.T) {
+    release := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            <-release
+        }
+    }))
+    t.Cleanup(backend.Close)
+
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 100, 10
+    cfg.Concurrency.MaxGlobal = 1
+    cfg.Quotas = config.Quotas{Limits: []config.QuotaLimit{{Period: "daily", Limit: 100}}}
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    srv := httptest.NewServer(lb.Handler())
+    t.Cleanup(srv.Close)
+
+    // Единственный слот занят: остальные запросы отклоняются с 503 и квоту не расходуют
+    slow := make(chan struct{})
+    go func() {
+        defer close(slow)
+        if resp, err := http.Get(srv.URL + "/slow"); err == nil {
+            resp.Body.Close()
+        }
+    }()
+    time.Sleep(100 * time.Millisecond)
+    for i := 0; i < 3; i++ {
+        resp, err := http.Get(srv.URL + "/")
+        if err != nil {
+            t.Fatalf("request failed: %v", err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusServiceUnavailable {
+            t.Fatalf("expected 503 over the concurrency limit, got %d", resp.StatusCode)
+        }
+    }
+    close(release)
+    <-slow
+
+    rec := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/quotas?client=127.0.0.1", nil))
+    var usage map[string][]quota.Usage
+    if err := json.NewDecoder(rec.Body).Decode(&usage); err != nil {
+        t.Fatalf("invalid /quotas response: %v", err)
+    }
+    if u := usage["127.0.0.1"]; len(u) != 1 || u[0].Used != 1 {
+        t.Fatalf("expected only the admitted request to count, got %+v", u)
+    }
+}
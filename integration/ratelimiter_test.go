@@ -1,9 +1,20 @@
 package integration
 
 import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync/atomic"
     "testing"
     "time"
 
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
     "github.com/Manzo48/loadBalancer/pkg/ratelimiter"
     "go.uber.org/zap"
 )
@@ -23,17 +34,68 @@ func BenchmarkRateLimiter(b *testing.B) {
 
 func BenchmarkRateLimiterWithMultipleClients(b *testing.B) {
     logger := zap.NewNop().Sugar()
+
+    // От десятка клиентов до миллионов ключей (например, флуд с подменой IP)
+    for _, clients := range []int{10, 100_000, 1_000_000, 5_000_000} {
+        b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+            rl := ratelimiter.NewRateLimiter(100, 10, logger)
+            var seq atomic.Int64
+
+            b.ReportAllocs()
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    clientID := strconv.Itoa(int(seq.Add(1) % int64(clients)))
+                    rl.Allow(clientID)
+                }
+            })
+            b.ReportMetric(float64(rl.Len()), "buckets")
+        })
+    }
+}
+
+// BenchmarkRateLimiterBoundedFlood — каждый запрос от нового клиента при ограниченном
+// количестве бакетов: память не растёт, старые бакеты вытесняются
+func BenchmarkRateLimiterBoundedFlood(b *testing.B) {
+    logger := zap.NewNop().Sugar()
     rl := ratelimiter.NewRateLimiter(100, 10, logger)
-    
+    rl.SetMaxBuckets(100_000)
+    var seq atomic.Int64
+
+    b.ReportAllocs()
     b.RunParallel(func(pb *testing.PB) {
-        i := 0
         for pb.Next() {
-            clientID := string(rune(i%10 + 65)) // Клиенты A-J
-            rl.Allow(clientID)
-            i++
+            rl.Allow(strconv.FormatInt(seq.Add(1), 10))
         }
     })
+    b.ReportMetric(float64(rl.Len()), "buckets")
+}
+
+func TestRateLimiter_MaxBuckets(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(5, 1, logger)
+    rl.SetMaxBuckets(1000)
+
+    for i := 0; i < 100_000; i++ {
+        rl.Allow(strconv.Itoa(i))
+    }
+    if n := rl.Len(); n > 1000 {
+        t.Fatalf("expected at most 1000 buckets, got %d", n)
+    }
+
+    // Активный клиент не вытесняется, пока к нему обращаются
+    for i := 0; i < 5; i++ {
+        rl.Allow("active")
+    }
+    if rl.Allow("active") {
+        t.Fatal("active client should still be limited")
+    }
+
+    rl.Cleanup(0)
+    if n := rl.Len(); n != 0 {
+        t.Fatalf("expected cleanup to remove all idle buckets, got %d", n)
+    }
 }
+
 func TestRateLimiter_BasicLimit(t *testing.T) {
     logger := zap.NewNop().Sugar()
     rl := ratelimiter.NewRateLimiter(5, 1, logger)
@@ -75,3 +137,162 @@ func TestRateLimiter_MultipleClients(t *testing.T) {
     }
 }
 
+
+func TestRateLimiter_WeightedCost(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(10, 1, logger)
+    rl.AddRule(ratelimiter.Rule{Name: "search", PathPrefix: "/search", Cost: 4, CostHeader: "X-Cost"})
+
+    backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("X-Cost", "6")
+        w.WriteHeader(http.StatusOK)
+    })
+    handler := ratelimiter.RateLimitMiddleware(rl, logger)(backend)
+
+    do := func(path string) int {
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        req.RemoteAddr = "10.0.0.1:1234"
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    // Стоит 4 токена, но upstream сообщает фактическую стоимость 6: остаётся 4
+    if code := do("/search"); code != http.StatusOK {
+        t.Fatalf("first search: expected 200, got %d", code)
+    }
+    // Второй поиск стоит 4 токена из 4 оставшихся
+    if code := do("/search"); code != http.StatusOK {
+        t.Fatalf("second search: expected 200, got %d", code)
+    }
+    // Бакет ушёл в долг на 2 токена, даже дешёвый запрос отклоняется
+    if code := do("/"); code != http.StatusTooManyRequests {
+        t.Fatalf("expected 429 after heavy queries, got %d", code)
+    }
+}
+
+func TestRateLimiter_WaitForToken(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(1, 10, logger)
+
+    if !rl.Allow("batch") {
+        t.Fatal("first request should be allowed")
+    }
+
+    // Токен пополнится примерно через 100мс — дожидаемся его вместо отказа
+    start := time.Now()
+    if err := rl.WaitN(context.Background(), "batch", 1, time.Second, 0); err != nil {
+        t.Fatalf("expected to get a token after waiting, got %v", err)
+    }
+    if waited := time.Since(start); waited < 50*time.Millisecond {
+        t.Errorf("expected to wait for refill, waited %v", waited)
+    }
+
+    // Слишком короткое ожидание — отказ без долгой блокировки
+    if err := rl.WaitN(context.Background(), "batch", 1, 10*time.Millisecond, 0); !errors.Is(err, ratelimiter.ErrWaitTimeout) {
+        t.Errorf("expected ErrWaitTimeout, got %v", err)
+    }
+
+    // Отмена контекста прерывает ожидание
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := rl.WaitN(ctx, "batch", 1, time.Second, 0); !errors.Is(err, context.Canceled) {
+        t.Errorf("expected context.Canceled, got %v", err)
+    }
+}
+
+func TestRateLimiter_ShadowRule(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(100, 10, logger)
+    rl.AddRule(ratelimiter.Rule{Name: "tight", PathPrefix: "/api", Capacity: 1, RefillRate: 1, Mode: ratelimiter.ModeShadow})
+
+    handler := ratelimiter.RateLimitMiddleware(rl, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    for i := 0; i < 2; i++ {
+        req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+        req.RemoteAddr = "10.0.0.2:1234"
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusOK {
+            t.Fatalf("request %d: shadow rule must not reject, got %d", i+1, rec.Code)
+        }
+        shadowed := rec.Header().Get(ratelimiter.ShadowHeader)
+        if i == 0 && shadowed != "" {
+            t.Errorf("first request is within shadow limit, got header %q", shadowed)
+        }
+        if i == 1 && shadowed != "tight" {
+            t.Errorf("second request should be marked by shadow rule, got %q", shadowed)
+        }
+    }
+}
+
+func TestRateLimiter_RuleModeValidation(t *testing.T) {
+    rl := ratelimiter.NewRateLimiter(100, 10, zap.NewNop().Sugar())
+    for _, mode := range []ratelimiter.Mode{"", ratelimiter.ModeEnforce, ratelimiter.ModeShadow} {
+        if err := rl.AddRule(ratelimiter.Rule{Name: "ok", PathPrefix: "/api", Mode: mode}); err != nil {
+            t.Errorf("mode %q: unexpected error %v", mode, err)
+        }
+    }
+
+    // Опечатка в режиме — ошибка конфигурации, а не тихий enforce
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 100, 10
+    cfg.RateLimit.Rules = []config.RateLimitRule{{Name: "search", PathPrefix: "/search", Mode: "shaddow"}}
+    err := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).ConfigError()
+    if err == nil || !strings.Contains(err.Error(), `unknown mode "shaddow"`) {
+        t.Fatalf("expected unknown rule mode to be rejected, got %v", err)
+    }
+}
+
+func TestRateLimiter_RuleCostValidation(t *testing.T) {
+    rl := ratelimiter.NewRateLimiter(10, 1, zap.NewNop().Sugar())
+    if err := rl.AddRule(ratelimiter.Rule{Name: "fits", PathPrefix: "/api", Cost: 10}); err != nil {
+        t.Errorf("cost equal to capacity: unexpected error %v", err)
+    }
+    if err := rl.AddRule(ratelimiter.Rule{Name: "own", PathPrefix: "/own", Cost: 20, Capacity: 20, RefillRate: 2}); err != nil {
+        t.Errorf("cost within rule capacity: unexpected error %v", err)
+    }
+
+    // Стоимость больше ёмкости бакета означала бы вечный 429
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    cfg.RateLimit.Rules = []config.RateLimitRule{
+        {Name: "search", PathPrefix: "/search", Cost: 11},
+        {Name: "tight", PathPrefix: "/tight", Cost: 5, Capacity: 4, RefillRate: 1},
+    }
+    err := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).ConfigError()
+    if err == nil || !strings.Contains(err.Error(), "rule search: cost 11 exceeds bucket capacity 10") ||
+        !strings.Contains(err.Error(), "rule tight: cost 5 exceeds bucket capacity 4") {
+        t.Fatalf("expected rules costing more than capacity to be rejected, got %v", err)
+    }
+}
+
+func TestRateLimiter_SnapshotRestore(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    path := filepath.Join(t.TempDir(), "buckets.json")
+
+    rl := ratelimiter.NewRateLimiter(3, 1, logger)
+    for i := 0; i < 3; i++ {
+        rl.Allow("abuser")
+    }
+    if err := rl.SaveSnapshot(path); err != nil {
+        t.Fatalf("failed to save snapshot: %v", err)
+    }
+
+    // Новый процесс: без снимка клиент получил бы полный бакет
+    restored := ratelimiter.NewRateLimiter(3, 1, logger)
+    n, err := restored.LoadSnapshot(path, time.Minute)
+    if err != nil || n != 1 {
+        t.Fatalf("expected 1 restored bucket, got %d (%v)", n, err)
+    }
+    if restored.Allow("abuser") {
+        t.Error("restored client should still be limited")
+    }
+
+    // Устаревшие записи отбрасываются
+    stale := ratelimiter.NewRateLimiter(3, 1, logger)
+    if n, _ := stale.LoadSnapshot(path, 0); n != 0 {
+        t.Errorf("expected stale buckets to be discarded, restored %d", n)
+    }
+}
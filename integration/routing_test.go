@@ -0,0 +1,357 @@
+package integration
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+)
+
+// newNamedBackend поднимает тестовый backend, который отвечает своим именем
+func newNamedBackend(t *testing.T, name string) *httptest.Server {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, name)
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func TestRouting_HostPathAndHeaderRules(t *testing.T) {
+    api := newNamedBackend(t, "api")
+    web := newNamedBackend(t, "web")
+    beta := newNamedBackend(t, "beta")
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "api":  {Backends: []string{api.URL}, Strategy: "least_connections"},
+            "web":  {Backends: []string{web.URL}},
+            "beta": {Backends: []string{beta.URL}, Strategy: "ip_hash"},
+        },
+        Routes: []config.Route{
+            {Name: "beta", Headers: map[string]string{"X-Beta": "1"}, Pool: "beta"},
+            {Name: "api", Host: "*.api.example.com", PathPrefix: "/v1/", Pool: "api"},
+            {Name: "reports", PathRegex: `^/reports/\d+$`, Methods: []string{"GET"}, Pool: "api"},
+            {Name: "web", Host: "www.example.com", Pool: "web"},
+        },
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 100, 10
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    handler := lb.Handler()
+
+    cases := []struct {
+        method, host, path string
+        header             string
+        wantCode           int
+        wantBody           string
+    }{
+        {"GET", "eu.api.example.com", "/v1/users", "", http.StatusOK, "api"},
+        {"GET", "api.example.com", "/v1/users", "", http.StatusNotFound, ""},
+        {"GET", "www.example.com:8080", "/", "", http.StatusOK, "web"},
+        {"GET", "other.example.com", "/reports/42", "", http.StatusOK, "api"},
+        {"POST", "other.example.com", "/reports/42", "", http.StatusNotFound, ""},
+        {"GET", "www.example.com", "/", "1", http.StatusOK, "beta"},
+    }
+    for _, tc := range cases {
+        req := httptest.NewRequest(tc.method, "http://"+tc.host+tc.path, nil)
+        if tc.header != "" {
+            req.Header.Set("X-Beta", tc.header)
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+
+        body, _ := io.ReadAll(rec.Body)
+        if rec.Code != tc.wantCode {
+            t.Errorf("%s %s%s: expected %d, got %d", tc.method, tc.host, tc.path, tc.wantCode, rec.Code)
+            continue
+        }
+        if tc.wantBody != "" && string(body) != tc.wantBody {
+            t.Errorf("%s %s%s: expected pool %q, got %q", tc.method, tc.host, tc.path, tc.wantBody, body)
+        }
+    }
+}
+
+func TestRouting_RewriteRules(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Server", "internal/1.0")
+        w.Header().Set("X-Internal", "secret")
+        w.Header().Set("Via", "1.1 internal")
+        fmt.Fprintf(w, "%s?%s host=%s tenant=%s debug=%q", r.URL.Path, r.URL.RawQuery, r.Host, r.Header.Get("X-Tenant"), r.Header.Get("X-Debug"))
+    }))
+    defer backend.Close()
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{"svc": {Backends: []string{backend.URL}}},
+        Routes: []config.Route{{
+            Name:       "svc",
+            PathPrefix: "/public/",
+            Pool:       "svc",
+            Rewrite: config.Rewrite{
+                StripPrefix:     "/public",
+                PathRegex:       `^/users/(\d+)$`,
+                PathReplacement: "/v2/accounts/$1",
+                AddPrefix:       "/api",
+                RequestHeaders:  config.HeaderRewrite{Set: map[string]string{"X-Tenant": "acme"}, Remove: []string{"X-Debug"}},
+                Query:           config.HeaderRewrite{Set: map[string]string{"source": "lb"}, Remove: []string{"token"}},
+                ResponseHeaders: config.HeaderRewrite{
+                    Set:    map[string]string{"Server": "lb"},
+                    Add:    map[string]string{"Via": "1.1 lb"},
+                    Remove: []string{"X-Internal"},
+                },
+                PreserveHost:    true,
+            },
+        }},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 100, 10
+
+    handler := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    req := httptest.NewRequest("GET", "http://shop.example.com/public/users/7?token=abc", nil)
+    req.Header.Set("X-Debug", "1")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+
+    want := `/api/v2/accounts/7?source=lb host=shop.example.com tenant=acme debug=""`
+    if got := rec.Body.String(); got != want {
+        t.Errorf("unexpected upstream request:\n got  %s\n want %s", got, want)
+    }
+    if got := rec.Header().Get("Server"); got != "lb" {
+        t.Errorf("expected Server header to be rewritten, got %q", got)
+    }
+    if got := rec.Header().Get("X-Internal"); got != "" {
+        t.Errorf("expected X-Internal to be removed, got %q", got)
+    }
+    if got := rec.Header().Values("Via"); len(got) != 2 || got[0] != "1.1 internal" || got[1] != "1.1 lb" {
+        t.Errorf("expected Via to be appended to the backend value, got %q", got)
+    }
+}
+
+func TestRouting_CanarySplit(t *testing.T) {
+    v1 := newNamedBackend(t, "v1")
+    v2 := newNamedBackend(t, "v2")
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "v1": {Backends: []string{v1.URL}},
+            "v2": {Backends: []string{v2.URL}},
+        },
+        Routes: []config.Route{{
+            Name:          "app",
+            Pool:          "v1",
+            Split:         []config.Split{{Pool: "v1", Weight: 50}, {Pool: "v2", Weight: 50}},
+            SplitOverride: config.SplitOverride{Header: "X-Canary", Values: map[string]string{"always": "v2", "never": "v1"}},
+        }},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    handler := lb.Handler()
+
+    get := func(client, canary string) string {
+        req := httptest.NewRequest("GET", "/", nil)
+        req.Header.Set("X-Real-IP", client)
+        if canary != "" {
+            req.Header.Set("X-Canary", canary)
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Body.String()
+    }
+
+    // Клиент стабильно попадает в одну версию, а трафик делится между обеими
+    seen := map[string]int{}
+    for i := 0; i < 50; i++ {
+        client := fmt.Sprintf("10.0.0.%d", i)
+        first := get(client, "")
+        for j := 0; j < 3; j++ {
+            if got := get(client, ""); got != first {
+                t.Fatalf("client %s flipped between %s and %s", client, first, got)
+            }
+        }
+        seen[first]++
+    }
+    if seen["v1"] == 0 || seen["v2"] == 0 {
+        t.Fatalf("expected traffic in both pools, got %v", seen)
+    }
+
+    // Принудительный выбор канарейки заголовком
+    for i := 0; i < 10; i++ {
+        if got := get(fmt.Sprintf("10.0.1.%d", i), "always"); got != "v2" {
+            t.Fatalf("X-Canary: always should route to v2, got %s", got)
+        }
+    }
+
+    // Явный выбор основного пула тоже окончателен: разделение по весам не применяется
+    for i := 0; i < 50; i++ {
+        if got := get(fmt.Sprintf("10.0.0.%d", i), "never"); got != "v1" {
+            t.Fatalf("X-Canary: never should pin the client to v1, got %s", got)
+        }
+    }
+
+    // Смена весов на лету через админский API
+    admin := lb.AdminHandler()
+    req := httptest.NewRequest("PUT", "/routes?route=app", strings.NewReader(`[{"pool":"v1","weight":0},{"pool":"v2","weight":100}]`))
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("failed to update split: %d %s", rec.Code, rec.Body)
+    }
+    for i := 0; i < 20; i++ {
+        if got := get(fmt.Sprintf("10.0.0.%d", i), ""); got != "v2" {
+            t.Fatalf("after full rollout expected v2, got %s", got)
+        }
+    }
+}
+
+func TestRouting_MirrorTraffic(t *testing.T) {
+    primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        fmt.Fprintf(w, "primary:%s", body)
+    }))
+    t.Cleanup(primary.Close)
+
+    type mirrored struct{ path, body string }
+    copies := make(chan mirrored, 10)
+    shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        copies <- mirrored{r.URL.Path, string(body)}
+        w.WriteHeader(http.StatusInternalServerError) // Ответ зеркала клиенту не виден
+    }))
+    t.Cleanup(shadow.Close)
+
+    all, none := 100.0, 0.0
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "v1": {Backends: []string{primary.URL}},
+            "v2": {Backends: []string{shadow.URL}},
+        },
+        Routes: []config.Route{
+            {
+                Name:       "paused",
+                PathPrefix: "/paused",
+                Pool:       "v1",
+                Mirror:     config.Mirror{Pool: "v2", Percent: &none},
+            },
+            {
+                Name:   "orders",
+                Pool:   "v1",
+                Mirror: config.Mirror{Pool: "v2", Percent: &all, MaxBodyBytes: 16},
+            },
+        },
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    handler := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    post := func(path, body string) string {
+        req := httptest.NewRequest("POST", path, strings.NewReader(body))
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        if rec.Code != http.StatusOK {
+            t.Fatalf("expected primary response 200, got %d", rec.Code)
+        }
+        return rec.Body.String()
+    }
+
+    // Клиент получает ответ основного пула, а зеркало — копию запроса с телом
+    if got := post("/orders", "small"); got != "primary:small" {
+        t.Fatalf("unexpected primary response %q", got)
+    }
+    select {
+    case c := <-copies:
+        if c.path != "/orders" || c.body != "small" {
+            t.Fatalf("unexpected mirrored request %+v", c)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("request was not mirrored")
+    }
+
+    // Тело больше лимита не зеркалируется, но основной backend получает его целиком
+    large := strings.Repeat("x", 100)
+    if got := post("/orders", large); got != "primary:"+large {
+        t.Fatalf("primary received truncated body: %d bytes", len(got))
+    }
+    select {
+    case c := <-copies:
+        t.Fatalf("oversized request should not be mirrored, got %d bytes", len(c.body))
+    case <-time.After(200 * time.Millisecond):
+    }
+
+    // percent: 0 выключает зеркалирование, а не означает значение по умолчанию
+    for i := 0; i < 5; i++ {
+        post("/paused", "small")
+    }
+    select {
+    case c := <-copies:
+        t.Fatalf("route with percent 0 should not be mirrored, got %+v", c)
+    case <-time.After(200 * time.Millisecond):
+    }
+}
+
+func TestRouting_InvalidConfigFailsStartup(t *testing.T) {
+    backend := newNamedBackend(t, "app")
+
+    cases := []struct {
+        name string
+        cfg  *config.Config
+        want string
+    }{
+        {
+            // Без routes и верхнеуровневых backends маршрут "default" остался бы без пула
+            name: "pools without routes",
+            cfg:  &config.Config{Pools: map[string]config.Pool{"app": {Backends: []string{backend.URL}}}},
+            want: "routes are required",
+        },
+        {
+            name: "route to unknown pool",
+            cfg: &config.Config{
+                Pools:  map[string]config.Pool{"app": {Backends: []string{backend.URL}}},
+                Routes: []config.Route{{Name: "api", Pool: "missing"}, {Name: "app", Pool: "app"}},
+            },
+            want: `unknown pool "missing"`,
+        },
+        {
+            name: "invalid pool",
+            cfg: &config.Config{
+                Pools:  map[string]config.Pool{"app": {Backends: []string{backend.URL}, Strategy: "fastest"}},
+                Routes: []config.Route{{Name: "app", Pool: "app"}},
+            },
+            want: "failed to create pool app",
+        },
+        {
+            // Пул "default" подменил бы собранный из верхнеуровневых backends
+            name: "pool named default",
+            cfg: &config.Config{
+                Backends: []string{backend.URL},
+                Pools:    map[string]config.Pool{"default": {Backends: []string{backend.URL}}},
+                Routes:   []config.Route{{Name: "app", Pool: "default"}},
+            },
+            want: `pool name "default" is reserved`,
+        },
+    }
+    for _, tc := range cases {
+        tc.cfg.Port = freePort(t)
+        lb := proxy.NewLoadBalancer(tc.cfg, zap.NewNop().Sugar())
+        if err := lb.ConfigError(); err == nil || !strings.Contains(err.Error(), tc.want) {
+            t.Errorf("%s: expected config error containing %q, got %v", tc.name, tc.want, err)
+        }
+
+        served := make(chan error, 1)
+        go func() { served <- lb.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", tc.cfg.Port)) }()
+        select {
+        case err := <-served:
+            if err == nil || !strings.Contains(err.Error(), "invalid configuration") {
+                t.Errorf("%s: expected ListenAndServe to refuse to start, got %v", tc.name, err)
+            }
+        case <-time.After(2 * time.Second):
+            lb.Shutdown()
+            t.Errorf("%s: ListenAndServe started with an invalid configuration", tc.name)
+        }
+    }
+}
@@ -0,0 +1,92 @@
+package integration
+
+import (
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/Manzo48/loadBalancer/pkg/shedding"
+    "go.uber.org/zap"
+)
+
+func TestShedder_DropsLowPriorityFirst(t *testing.T) {
+    _, proxies, _ := net.ParseCIDR("192.0.2.0/24") // httptest.NewRequest приходит с 192.0.2.1
+    s := shedding.NewShedder(shedding.Options{
+        Header:      "X-Priority",
+        TrustedNets: []*net.IPNet{proxies},
+        MaxInflight: 2,
+        Thresholds:  map[shedding.Priority]float64{shedding.Low: 0.5},
+    })
+
+    release := make(chan struct{})
+    entered := make(chan struct{})
+    handler := s.Middleware(zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            entered <- struct{}{}
+            <-release
+        }
+    }))
+
+    do := func(path, priority string) int {
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        req.Header.Set("X-Priority", priority)
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    // Один долгий запрос — нагрузка 50%
+    go do("/slow", "high")
+    <-entered
+    defer close(release)
+
+    if code := do("/", "low"); code != http.StatusServiceUnavailable {
+        t.Errorf("low priority should be shed at 50%% load, got %d", code)
+    }
+    if code := do("/", "normal"); code != http.StatusOK {
+        t.Errorf("normal priority should pass at 50%% load, got %d", code)
+    }
+    if code := do("/health", "low"); code != http.StatusOK {
+        t.Errorf("health checks must never be shed, got %d", code)
+    }
+}
+
+func TestShedder_PriorityHeaderOnlyFromTrustedProxies(t *testing.T) {
+    _, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+    s := shedding.NewShedder(shedding.Options{
+        Header:      "X-Priority",
+        TrustedNets: []*net.IPNet{proxies},
+        Default:     shedding.Low,
+    })
+
+    var forwarded string
+    handler := s.Middleware(zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        forwarded = r.Header.Get("X-Priority")
+    }))
+
+    cases := []struct {
+        remote string
+        want   shedding.Priority
+    }{
+        {"10.1.2.3:5000", shedding.Critical}, // Доверенный прокси
+        {"203.0.113.7:5000", shedding.Low},   // Клиент напрямую: заголовок игнорируется
+    }
+    for _, tc := range cases {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = tc.remote
+        req.Header.Set("X-Priority", "critical")
+        if got := s.Classify(req); got != tc.want {
+            t.Errorf("%s: expected %s, got %s", tc.remote, tc.want, got)
+        }
+
+        handler.ServeHTTP(httptest.NewRecorder(), req)
+        trusted := tc.want == shedding.Critical
+        if trusted && forwarded != "critical" {
+            t.Errorf("%s: header from trusted proxy should reach the backend, got %q", tc.remote, forwarded)
+        }
+        if !trusted && forwarded != "" {
+            t.Errorf("%s: untrusted priority header should be stripped, got %q", tc.remote, forwarded)
+        }
+    }
+}
@@ -0,0 +1,218 @@
+package integration
+
+import (
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "github.com/Manzo48/loadBalancer/pkg/tcpproxy"
+    "go.uber.org/zap"
+)
+
+// newTCPBackend поднимает TCP-сервер, который читает данные до конца потока
+// и отвечает "<name>:<данные>"
+func newTCPBackend(t *testing.T, name string) net.Listener {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { ln.Close() })
+    go func() {
+        for {
+            c, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer c.Close()
+                data, _ := io.ReadAll(c)
+                fmt.Fprintf(c, "%s:%s", name, data)
+            }()
+        }
+    }()
+    return ln
+}
+
+// tcpExchange отправляет msg, закрывает запись и возвращает весь ответ
+func tcpExchange(t *testing.T, c net.Conn, msg string) string {
+    t.Helper()
+    c.SetDeadline(time.Now().Add(2 * time.Second))
+    if _, err := io.WriteString(c, msg); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+    c.(*net.TCPConn).CloseWrite()
+    reply, _ := io.ReadAll(c)
+    c.Close()
+    return string(reply)
+}
+
+func dialTCP(t *testing.T, addr string) net.Conn {
+    t.Helper()
+    c, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatalf("dial %s: %v", addr, err)
+    }
+    return c
+}
+
+// startTCPBalancer запускает балансировщик с одним TCP-листенером
+func startTCPBalancer(t *testing.T, tc config.TCPListener) (*proxy.LoadBalancer, string) {
+    tc.Port = freePort(t)
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}, TCP: []config.TCPListener{tc}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    waitForListener(t, plainAddr) // TCP-листенеры открываются раньше HTTP
+    return lb, fmt.Sprintf("127.0.0.1:%d", tc.Port)
+}
+
+func TestTCP_BalancingLimitsAndHealth(t *testing.T) {
+    a := newTCPBackend(t, "a")
+    b := newTCPBackend(t, "b")
+
+    lb, addr := startTCPBalancer(t, config.TCPListener{
+        Name:          "redis",
+        Backends:      []string{a.Addr().String(), b.Addr().String()},
+        MaxPerBackend: 1,
+        HealthCheck:   config.HealthCheck{Interval: 50 * time.Millisecond, Timeout: 100 * time.Millisecond},
+    })
+    t.Cleanup(lb.Shutdown)
+
+    // Backend выбирается для каждого соединения, данные идут в обе стороны
+    hits := map[string]int{}
+    for i := 0; i < 4; i++ {
+        reply := tcpExchange(t, dialTCP(t, addr), "ping")
+        hits[reply]++
+    }
+    if hits["a:ping"] != 2 || hits["b:ping"] != 2 {
+        t.Fatalf("expected connections split evenly, got %v", hits)
+    }
+
+    // Лимит соединений на backend: два соединения заняли оба backend'а
+    held1, held2 := dialTCP(t, addr), dialTCP(t, addr)
+    time.Sleep(100 * time.Millisecond)
+    if reply := tcpExchange(t, dialTCP(t, addr), "extra"); reply != "" {
+        t.Fatalf("expected connection over the limit to be closed, got %q", reply)
+    }
+    tcpExchange(t, held1, "")
+    tcpExchange(t, held2, "")
+    time.Sleep(50 * time.Millisecond)
+
+    // Упавший backend исключается по TCP health-check
+    b.Close()
+    time.Sleep(200 * time.Millisecond)
+    for i := 0; i < 4; i++ {
+        if reply := tcpExchange(t, dialTCP(t, addr), "ping"); reply != "a:ping" {
+            t.Fatalf("expected only a after b went down, got %q", reply)
+        }
+    }
+}
+
+func TestTCP_IdleTimeoutAndDrain(t *testing.T) {
+    a := newTCPBackend(t, "a")
+    lb, addr := startTCPBalancer(t, config.TCPListener{
+        Name:          "pg",
+        Backends:      []string{a.Addr().String()},
+        IdleTimeout:   200 * time.Millisecond,
+        ShutdownGrace: 2 * time.Second,
+    })
+
+    // Соединение без трафика закрывается по idle timeout
+    idle := dialTCP(t, addr)
+    idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+    start := time.Now()
+    if _, err := idle.Read(make([]byte, 1)); err == nil {
+        t.Fatal("expected idle connection to be closed")
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("idle connection closed after %v, expected ~200ms", elapsed)
+    }
+    idle.Close()
+
+    // При остановке новые соединения не принимаются, а текущее завершается само
+    active := dialTCP(t, addr)
+    io.WriteString(active, "in-flight")
+    time.Sleep(50 * time.Millisecond)
+
+    stopped := make(chan struct{})
+    go func() {
+        lb.Shutdown()
+        close(stopped)
+    }()
+    time.Sleep(100 * time.Millisecond)
+
+    if c, err := net.DialTimeout("tcp", addr, 200*time.Millisecond); err == nil {
+        c.SetReadDeadline(time.Now().Add(time.Second))
+        n, _ := c.Read(make([]byte, 1))
+        c.Close()
+        if n > 0 {
+            t.Fatal("expected new connections to be refused during shutdown")
+        }
+    }
+    select {
+    case <-stopped:
+        t.Fatal("shutdown returned before active connection finished")
+    default:
+    }
+
+    if reply := tcpExchange(t, active, ""); reply != "a:in-flight" {
+        t.Fatalf("expected active connection to complete during drain, got %q", reply)
+    }
+    select {
+    case <-stopped:
+    case <-time.After(time.Second):
+        t.Fatal("shutdown did not finish after connection closed")
+    }
+}
+
+// flakyListener возвращает ошибку EMFILE на первые n вызовов Accept
+type flakyListener struct {
+    net.Listener
+    failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+    if l.failures > 0 {
+        l.failures--
+        return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+    }
+    return l.Listener.Accept()
+}
+
+func TestTCP_AcceptErrorsDoNotStopListener(t *testing.T) {
+    backend := newTCPBackend(t, "a")
+    p, err := tcpproxy.New(tcpproxy.Options{Name: "flaky", Backends: []string{backend.Addr().String()}}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    served := make(chan error, 1)
+    go func() { served <- p.Serve(&flakyListener{Listener: ln, failures: 3}) }()
+
+    // Нехватка дескрипторов не временная в смысле Timeout(), но листенер должен продолжить работу
+    if got := tcpExchange(t, dialTCP(t, ln.Addr().String()), "ping"); got != "a:ping" {
+        t.Fatalf("expected listener to recover after accept errors, got %q", got)
+    }
+
+    p.Shutdown(time.Second)
+    select {
+    case err := <-served:
+        if err != nil {
+            t.Fatalf("Serve should return nil after Shutdown, got %v", err)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("Serve did not return after Shutdown")
+    }
+}
@@ -0,0 +1,317 @@
+package integration
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "fmt"
+    "io"
+    "math/big"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+)
+
+// freePort возвращает свободный TCP-порт для листенеров балансировщика
+func freePort(t *testing.T) int {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    return ln.Addr().(*net.TCPAddr).Port
+}
+
+// writeCert создаёт самоподписанный сертификат для указанных имён и сохраняет его в dir
+func writeCert(t *testing.T, dir, name string, serial int64, dnsNames ...string) config.Certificate {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject:      pkix.Name{CommonName: dnsNames[0]},
+        DNSNames:     dnsNames,
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cert := config.Certificate{
+        CertFile: filepath.Join(dir, name+".crt"),
+        KeyFile:  filepath.Join(dir, name+".key"),
+    }
+    if err := os.WriteFile(cert.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(cert.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return cert
+}
+
+// waitForListener ждёт, пока балансировщик начнёт принимать соединения
+func waitForListener(t *testing.T, addr string) {
+    for i := 0; i < 100; i++ {
+        if c, err := net.Dial("tcp", addr); err == nil {
+            c.Close()
+            return
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+    t.Fatalf("listener %s did not start", addr)
+}
+
+func TestTLS_SNIReloadAndRedirect(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, r.Header.Get("X-Forwarded-Proto"))
+    }))
+    t.Cleanup(backend.Close)
+
+    dir := t.TempDir()
+    certA := writeCert(t, dir, "a", 1, "a.example.com")
+    certB := writeCert(t, dir, "b", 2, "*.b.example.com")
+
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.TLS = config.TLS{
+        Port:           freePort(t),
+        Certificates:   []config.Certificate{certA, certB},
+        MinVersion:     "1.3",
+        ReloadInterval: 50 * time.Millisecond,
+        RedirectHTTP:   true,
+    }
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+    tlsAddr := fmt.Sprintf("127.0.0.1:%d", cfg.TLS.Port)
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr)
+    waitForListener(t, tlsAddr)
+
+    serialFor := func(serverName string) int64 {
+        conn, err := tls.Dial("tcp", tlsAddr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+        if err != nil {
+            t.Fatalf("TLS handshake for %q failed: %v", serverName, err)
+        }
+        defer conn.Close()
+        return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+    }
+
+    // Выбор сертификата по SNI: точное имя, шаблон, сертификат по умолчанию
+    if got := serialFor("a.example.com"); got != 1 {
+        t.Fatalf("expected certificate 1 for a.example.com, got %d", got)
+    }
+    if got := serialFor("api.b.example.com"); got != 2 {
+        t.Fatalf("expected wildcard certificate 2 for api.b.example.com, got %d", got)
+    }
+    if got := serialFor("unknown.example.org"); got != 1 {
+        t.Fatalf("expected default certificate 1 for unknown name, got %d", got)
+    }
+
+    // Минимальная версия TLS
+    if _, err := tls.Dial("tcp", tlsAddr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}); err == nil {
+        t.Fatal("TLS 1.2 handshake should be rejected with min_version 1.3")
+    }
+
+    // Запрос по HTTPS доходит до backend'а с X-Forwarded-Proto
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+    resp, err := client.Get("https://" + tlsAddr + "/")
+    if err != nil {
+        t.Fatalf("HTTPS request failed: %v", err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "https" {
+        t.Fatalf("expected X-Forwarded-Proto https at backend, got %q", body)
+    }
+
+    // HTTP-листенер перенаправляет на HTTPS
+    noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+    resp, err = noRedirect.Get("http://" + plainAddr + "/path?x=1")
+    if err != nil {
+        t.Fatalf("HTTP request failed: %v", err)
+    }
+    resp.Body.Close()
+    if want := "https://" + tlsAddr + "/path?x=1"; resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != want {
+        t.Fatalf("expected 308 to %s, got %d %s", want, resp.StatusCode, resp.Header.Get("Location"))
+    }
+
+    // Обновлённый на диске сертификат подхватывается без перезапуска
+    writeCert(t, dir, "a", 3, "a.example.com")
+    future := time.Now().Add(time.Minute)
+    os.Chtimes(certA.CertFile, future, future)
+
+    deadline := time.Now().Add(2 * time.Second)
+    for serialFor("a.example.com") != 3 {
+        if time.Now().After(deadline) {
+            t.Fatal("certificate was not reloaded after the file changed")
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+}
+
+func TestUpstreamTLS_MutualTLSAndHealthChecks(t *testing.T) {
+    dir := t.TempDir()
+    clientCert := writeCert(t, dir, "client", 10, "lb.internal")
+    clientCAs := x509.NewCertPool()
+    pemData, _ := os.ReadFile(clientCert.CertFile)
+    clientCAs.AppendCertsFromPEM(pemData)
+
+    // Backend принимает только соединения с клиентским сертификатом балансировщика
+    backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+    }))
+    backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+    backend.StartTLS()
+    t.Cleanup(backend.Close)
+
+    // Сертификат httptest выписан на example.com — проверяем подмену имени сервера
+    caFile := filepath.Join(dir, "backend-ca.pem")
+    os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o644)
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "secure": {
+                Backends:    []string{backend.URL},
+                HealthCheck: config.HealthCheck{Path: "/", Interval: 50 * time.Millisecond},
+                TLS: config.UpstreamTLS{
+                    CAFile:     caFile,
+                    CertFile:   clientCert.CertFile,
+                    KeyFile:    clientCert.KeyFile,
+                    ServerName: "example.com",
+                },
+            },
+            "anonymous": {
+                Backends: []string{backend.URL},
+                TLS:      config.UpstreamTLS{CAFile: caFile, ServerName: "example.com"},
+            },
+        },
+        Routes: []config.Route{
+            {Name: "anonymous", PathPrefix: "/anonymous", Pool: "anonymous"},
+            {Name: "secure", Pool: "secure"},
+        },
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    handler := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler()
+
+    // Несколько циклов health-check'а: без TLS-настроек пула backend был бы помечен мёртвым
+    time.Sleep(200 * time.Millisecond)
+
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    if rec.Code != http.StatusOK || rec.Body.String() != "lb.internal" {
+        t.Fatalf("expected mTLS request to succeed, got %d %q", rec.Code, rec.Body.String())
+    }
+
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest("GET", "/anonymous", nil))
+    if rec.Code != http.StatusServiceUnavailable {
+        t.Fatalf("request without client certificate should fail, got %d", rec.Code)
+    }
+}
+
+func TestTLS_ClientCertificateAuth(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "%s|%d", r.Header.Get("X-Client-Cert-Subject"), len(r.Header.Get("X-Client-Cert-Fingerprint")))
+    }))
+    t.Cleanup(backend.Close)
+
+    dir := t.TempDir()
+    serverCert := writeCert(t, dir, "server", 1, "lb.example.com")
+    partnerCert := writeCert(t, dir, "partner", 2, "partner.example.com")
+
+    cfg := &config.Config{
+        Backends: []string{backend.URL},
+        Routes: []config.Route{
+            {Name: "partner", PathPrefix: "/partner", Pool: "default", ClientCert: "required"},
+            {Name: "public", Pool: "default"},
+        },
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 3, 0
+    cfg.TLS = config.TLS{
+        Port:         freePort(t),
+        Certificates: []config.Certificate{serverCert},
+        ClientAuth:   config.ClientAuth{CAFile: partnerCert.CertFile},
+    }
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+    tlsAddr := fmt.Sprintf("127.0.0.1:%d", cfg.TLS.Port)
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr)
+    waitForListener(t, tlsAddr)
+
+    partnerPair, err := tls.LoadX509KeyPair(partnerCert.CertFile, partnerCert.KeyFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    newClient := func(certs ...tls.Certificate) *http.Client {
+        return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs}}}
+    }
+    get := func(client *http.Client, path string, header ...string) (int, string) {
+        req, _ := http.NewRequest("GET", "https://"+tlsAddr+path, nil)
+        for i := 0; i+1 < len(header); i += 2 {
+            req.Header.Set(header[i], header[i+1])
+        }
+        resp, err := client.Do(req)
+        if err != nil {
+            t.Fatalf("request to %s failed: %v", path, err)
+        }
+        defer resp.Body.Close()
+        body, _ := io.ReadAll(resp.Body)
+        return resp.StatusCode, string(body)
+    }
+    partner, anonymous := newClient(partnerPair), newClient()
+
+    // Маршрут с обязательным сертификатом
+    if code, _ := get(anonymous, "/partner"); code != http.StatusForbidden {
+        t.Fatalf("expected 403 without client certificate, got %d", code)
+    }
+    code, body := get(partner, "/partner")
+    if code != http.StatusOK || body != "CN=partner.example.com|64" {
+        t.Fatalf("expected subject and fingerprint at backend, got %d %q", code, body)
+    }
+
+    // Подделанные заголовки клиента без сертификата не доходят до backend'а
+    if _, body := get(anonymous, "/", "X-Client-Cert-Subject", "CN=evil"); body != "|0" {
+        t.Fatalf("spoofed certificate header reached backend: %q", body)
+    }
+
+    // Rate limit считается по сертификату, а не по IP: партнёр исчерпал свои токены,
+    // анонимный клиент с того же адреса — ещё нет
+    for i := 0; i < 2; i++ {
+        if code, _ := get(partner, "/"); code != http.StatusOK {
+            t.Fatalf("partner's request %d should pass, got %d", i+2, code)
+        }
+    }
+    if code, _ := get(partner, "/"); code != http.StatusTooManyRequests {
+        t.Fatalf("partner should be rate limited by certificate, got %d", code)
+    }
+    if code, _ := get(anonymous, "/"); code != http.StatusOK {
+        t.Fatalf("anonymous client shares the IP but not the bucket, got %d", code)
+    }
+}
@@ -0,0 +1,166 @@
+package integration
+
+import (
+    "fmt"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+)
+
+// newUDPBackend поднимает UDP-сервер, отвечающий на каждую датаграмму "<name>:<данные>"
+func newUDPBackend(t *testing.T, name string) *net.UDPConn {
+    conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    go func() {
+        buf := make([]byte, 2048)
+        for {
+            n, addr, err := conn.ReadFromUDP(buf)
+            if err != nil {
+                return
+            }
+            conn.WriteToUDP([]byte(name+":"+string(buf[:n])), addr)
+        }
+    }()
+    return conn
+}
+
+// udpExchange отправляет датаграмму и ждёт ответ; "" — ответа нет
+func udpExchange(t *testing.T, c *net.UDPConn, msg string) string {
+    t.Helper()
+    if _, err := c.Write([]byte(msg)); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+    c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+    buf := make([]byte, 2048)
+    n, err := c.Read(buf)
+    if err != nil {
+        return ""
+    }
+    return string(buf[:n])
+}
+
+func TestUDP_SessionsExpiryAndRateLimit(t *testing.T) {
+    a := newUDPBackend(t, "a")
+    b := newUDPBackend(t, "b")
+
+    uc := config.UDPListener{
+        Name:           "dns",
+        Port:           freePort(t),
+        Backends:       []string{a.LocalAddr().String(), b.LocalAddr().String()},
+        SessionTimeout: 200 * time.Millisecond,
+    }
+    uc.RateLimit.Capacity = 10 // Без пополнения: ровно 10 датаграмм за тест
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}, UDP: []config.UDPListener{uc}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr) // UDP-листенеры открываются раньше HTTP
+
+    lbAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: uc.Port}
+    dial := func() *net.UDPConn {
+        c, err := net.DialUDP("udp", nil, lbAddr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { c.Close() })
+        return c
+    }
+    backendOf := func(reply string) string {
+        name, _, _ := strings.Cut(reply, ":")
+        return name
+    }
+
+    // Датаграммы одного клиента идут на один backend, разных — распределяются
+    c1, c2 := dial(), dial()
+    first := udpExchange(t, c1, "q1")
+    if first == "" {
+        t.Fatal("no reply through udp listener")
+    }
+    for _, q := range []string{"q2", "q3"} {
+        if reply := udpExchange(t, c1, q); backendOf(reply) != backendOf(first) || !strings.HasSuffix(reply, q) {
+            t.Fatalf("expected session to stay on %s, got %q", backendOf(first), reply)
+        }
+    }
+    second := udpExchange(t, c2, "q1")
+    if backendOf(second) == backendOf(first) || second == "" {
+        t.Fatalf("expected second client on the other backend, got %q and %q", first, second)
+    }
+
+    // Сессии без трафика удаляются, и клиент заново получает backend по стратегии
+    time.Sleep(400 * time.Millisecond)
+    if reply := udpExchange(t, c2, "again"); backendOf(reply) != backendOf(first) {
+        t.Fatalf("expected expired session of second client to move to %s, got %q", backendOf(first), reply)
+    }
+    udpExchange(t, c1, "again")
+
+    // Лимит датаграмм на IP: из 10 токенов 6 уже потрачено
+    replies := 0
+    for i := 0; i < 10; i++ {
+        if udpExchange(t, c1, "burst") != "" {
+            replies++
+        }
+    }
+    if replies != 4 {
+        t.Fatalf("expected 4 datagrams within rate limit, got %d", replies)
+    }
+}
+
+func TestUDP_MaxSessions(t *testing.T) {
+    a := newUDPBackend(t, "a")
+
+    uc := config.UDPListener{
+        Name:           "syslog",
+        Port:           freePort(t),
+        Backends:       []string{a.LocalAddr().String()},
+        MaxSessions:    1,
+        SessionTimeout: 200 * time.Millisecond,
+    }
+    cfg := &config.Config{Backends: []string{"http://127.0.0.1:1"}, UDP: []config.UDPListener{uc}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 10, 1
+    plainAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(plainAddr)
+    t.Cleanup(lb.Shutdown)
+    waitForListener(t, plainAddr)
+
+    lbAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: uc.Port}
+    dial := func() *net.UDPConn {
+        c, err := net.DialUDP("udp", nil, lbAddr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { c.Close() })
+        return c
+    }
+
+    c1, c2 := dial(), dial()
+    if reply := udpExchange(t, c1, "q1"); reply != "a:q1" {
+        t.Fatalf("expected reply through udp listener, got %q", reply)
+    }
+
+    // Сессий уже max_sessions: датаграммы нового клиента отбрасываются, а существующего — нет
+    if reply := udpExchange(t, c2, "q1"); reply != "" {
+        t.Fatalf("expected new session over the limit to be dropped, got %q", reply)
+    }
+    if reply := udpExchange(t, c1, "q2"); reply != "a:q2" {
+        t.Fatalf("expected existing session to keep working, got %q", reply)
+    }
+
+    // После удаления простаивающей сессии место освобождается
+    time.Sleep(400 * time.Millisecond)
+    if reply := udpExchange(t, c2, "q2"); reply != "a:q2" {
+        t.Fatalf("expected new session once the limit freed up, got %q", reply)
+    }
+}
@@ -0,0 +1,232 @@
+package integration
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxy"
+    "go.uber.org/zap"
+)
+
+// newEchoUpgradeBackend поднимает backend, который переходит на протокол "echo"
+// и возвращает клиенту каждую полученную строку. Обычные запросы получают имя backend'а.
+func newEchoUpgradeBackend(t *testing.T, name string) *httptest.Server {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Upgrade") != "echo" {
+            fmt.Fprint(w, name)
+            return
+        }
+        conn, brw, err := http.NewResponseController(w).Hijack()
+        if err != nil {
+            t.Errorf("hijack failed: %v", err)
+            return
+        }
+        defer conn.Close()
+        fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\nX-Backend: %s\r\n\r\n", name)
+        brw.Flush()
+        for {
+            line, err := brw.ReadString('\n')
+            if err != nil {
+                return
+            }
+            brw.WriteString(line)
+            brw.Flush()
+        }
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// dialUpgrade открывает соединение через балансировщик и выполняет рукопожатие
+func dialUpgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader, string) {
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatalf("dial failed: %v", err)
+    }
+    fmt.Fprint(conn, "GET /stream HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+    br := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(br, nil)
+    if err != nil {
+        t.Fatalf("failed to read handshake: %v", err)
+    }
+    if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+        t.Fatalf("expected 101 with Upgrade: echo, got %d %v", resp.StatusCode, resp.Header)
+    }
+    return conn, br, resp.Header.Get("X-Backend")
+}
+
+func TestUpgrade_EchoAndLeastConnections(t *testing.T) {
+    a := newEchoUpgradeBackend(t, "a")
+    b := newEchoUpgradeBackend(t, "b")
+
+    cfg := &config.Config{
+        Pools: map[string]config.Pool{
+            "ws": {Backends: []string{a.URL, b.URL}, Strategy: "least_connections"},
+        },
+        Routes: []config.Route{{Name: "ws", Pool: "ws"}},
+    }
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+
+    lb := httptest.NewServer(proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler())
+    t.Cleanup(lb.Close)
+
+    conn, br, busy := dialUpgrade(t, lb.Listener.Addr().String())
+    defer conn.Close()
+
+    fmt.Fprint(conn, "ping\n")
+    conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if line, err := br.ReadString('\n'); err != nil || line != "ping\n" {
+        t.Fatalf("expected echo, got %q (%v)", line, err)
+    }
+
+    // Открытое соединение учитывается в least_connections: обычные запросы идут на другой backend
+    for i := 0; i < 5; i++ {
+        resp, err := http.Get(lb.URL + "/")
+        if err != nil {
+            t.Fatalf("request failed: %v", err)
+        }
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if string(body) == busy {
+            t.Fatalf("request %d went to backend %s that holds the upgraded connection", i, busy)
+        }
+    }
+}
+
+func TestUpgrade_IdleTimeoutAndShutdown(t *testing.T) {
+    backend := newEchoUpgradeBackend(t, "a")
+
+    // Shutdown работает только с собственным сервером балансировщика
+    addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.Upgrade.IdleTimeout = 300 * time.Millisecond
+    cfg.Upgrade.ShutdownGrace = 200 * time.Millisecond
+
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    go lb.ListenAndServe(addr)
+    waitForListener(t, addr)
+
+    // Соединение без трафика закрывается по таймауту простоя
+    idle, idleReader, _ := dialUpgrade(t, addr)
+    defer idle.Close()
+    idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if _, err := idleReader.ReadString('\n'); err == nil || isTimeout(err) {
+        t.Fatalf("idle connection should be closed by the balancer, got %v", err)
+    }
+
+    // Активное соединение закрывается при остановке после grace-периода
+    active, activeReader, _ := dialUpgrade(t, addr)
+    defer active.Close()
+
+    start := time.Now()
+    lb.Shutdown()
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("shutdown took %s, expected about the grace period", elapsed)
+    }
+    active.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if _, err := activeReader.ReadString('\n'); err == nil || isTimeout(err) {
+        t.Fatalf("upgraded connection should be closed on shutdown, got %v", err)
+    }
+}
+
+func isTimeout(err error) bool {
+    ne, ok := err.(net.Error)
+    return ok && ne.Timeout()
+}
+
+func TestUpgrade_HandshakeTimeout(t *testing.T) {
+    // Backend принимает соединение, читает запрос и молчит
+    silent, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { silent.Close() })
+    go func() {
+        for {
+            c, err := silent.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer c.Close()
+                io.Copy(io.Discard, c)
+            }()
+        }
+    }()
+
+    cfg := &config.Config{Backends: []string{"http://" + silent.Addr().String()}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.Upgrade.HandshakeTimeout = 200 * time.Millisecond
+    lb := proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar())
+    srv := httptest.NewServer(lb.Handler())
+    t.Cleanup(srv.Close)
+
+    conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    fmt.Fprint(conn, "GET /stream HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+    start := time.Now()
+    conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+    resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+    if err != nil {
+        t.Fatalf("handshake hung: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusServiceUnavailable {
+        t.Fatalf("expected 503 for a silent backend, got %d", resp.StatusCode)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("handshake timeout took %s", elapsed)
+    }
+
+    // Слот backend'а освобождён
+    rec := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/backends", nil))
+    if body := rec.Body.String(); !strings.Contains(body, `"active_requests":0`) {
+        t.Fatalf("backend slot was not released: %s", body)
+    }
+}
+
+func TestUpgrade_ConnectionsDoNotHoldConcurrencySlots(t *testing.T) {
+    backend := newEchoUpgradeBackend(t, "a")
+
+    const n = 2
+    cfg := &config.Config{Backends: []string{backend.URL}}
+    cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate = 1000, 100
+    cfg.Concurrency.MaxGlobal, cfg.Concurrency.MaxPerClient = n, n
+    cfg.LoadShedding.Enabled, cfg.LoadShedding.MaxInflight = true, n
+
+    lb := httptest.NewServer(proxy.NewLoadBalancer(cfg, zap.NewNop().Sugar()).Handler())
+    t.Cleanup(lb.Close)
+
+    // Открытые соединения не считаются запросами в обработке
+    for i := 0; i < n; i++ {
+        conn, _, _ := dialUpgrade(t, lb.Listener.Addr().String())
+        defer conn.Close()
+    }
+    for i := 0; i < n+1; i++ {
+        resp, err := http.Get(lb.URL + "/plain")
+        if err != nil {
+            t.Fatalf("request failed: %v", err)
+        }
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK || string(body) != "a" {
+            t.Fatalf("plain request with %d open upgrades: %d %q", n, resp.StatusCode, body)
+        }
+    }
+}
@@ -0,0 +1,109 @@
+package balancer
+
+import (
+    "math"
+    "sync"
+    "time"
+)
+
+// AdaptiveOptions — настройки адаптивного лимита одновременных запросов к backend'у
+type AdaptiveOptions struct {
+    InitialLimit  int           // Начальный лимит
+    MinLimit      int           // Нижняя граница лимита
+    MaxLimit      int           // Верхняя граница лимита
+    Backoff       float64       // Множитель уменьшения лимита при перегрузке (например, 0.9)
+    Tolerance     float64       // Во сколько раз задержка может превышать минимальную, прежде чем считаться перегрузкой
+    TargetLatency time.Duration // Фиксированный порог задержки; если задан, Tolerance не используется
+}
+
+// minRTTWindow — через сколько замеров пересчитывается базовая (минимальная) задержка,
+// чтобы лимит подстраивался под изменившуюся "норму" backend'а
+const minRTTWindow = 500
+
+// AdaptiveLimit реализует AIMD-алгоритм: пока задержка backend'а в норме, лимит
+// растёт на единицу за "окно" из limit запросов; при росте задержки или ошибке
+// лимит умножается на Backoff.
+type AdaptiveLimit struct {
+    opts AdaptiveOptions
+
+    mu        sync.Mutex
+    limit     float64       // Текущий лимит одновременных запросов
+    minRTT    time.Duration // Базовая задержка backend'а
+    windowMin time.Duration // Минимальная задержка в текущем окне
+    samples   int           // Замеров в текущем окне
+}
+
+// NewAdaptiveLimit создаёт адаптивный лимит, подставляя значения по умолчанию
+func NewAdaptiveLimit(opts AdaptiveOptions) *AdaptiveLimit {
+    if opts.MinLimit <= 0 {
+        opts.MinLimit = 1
+    }
+    if opts.MaxLimit <= 0 {
+        opts.MaxLimit = 1000
+    }
+    if opts.InitialLimit <= 0 {
+        opts.InitialLimit = 20
+    }
+    if opts.Backoff <= 0 || opts.Backoff >= 1 {
+        opts.Backoff = 0.9
+    }
+    if opts.Tolerance <= 1 {
+        opts.Tolerance = 2
+    }
+    return &AdaptiveLimit{
+        opts:  opts,
+        limit: float64(opts.InitialLimit),
+    }
+}
+
+// Limit возвращает текущий лимит одновременных запросов
+func (a *AdaptiveLimit) Limit() int64 {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return int64(a.limit)
+}
+
+// Observe учитывает задержку очередного запроса к backend'у и корректирует лимит
+func (a *AdaptiveLimit) Observe(rtt time.Duration, failed bool) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    if !failed {
+        a.trackMinRTT(rtt)
+    }
+
+    if failed || rtt > a.threshold() {
+        // Мультипликативное уменьшение
+        a.limit = math.Max(float64(a.opts.MinLimit), a.limit*a.opts.Backoff)
+        return
+    }
+    // Аддитивное увеличение: +1 за каждые limit успешных запросов
+    a.limit = math.Min(float64(a.opts.MaxLimit), a.limit+1/a.limit)
+}
+
+// threshold возвращает задержку, выше которой backend считается перегруженным
+func (a *AdaptiveLimit) threshold() time.Duration {
+    if a.opts.TargetLatency > 0 {
+        return a.opts.TargetLatency
+    }
+    if a.minRTT == 0 {
+        return time.Duration(math.MaxInt64) // Ещё нет базовой задержки
+    }
+    return time.Duration(float64(a.minRTT) * a.opts.Tolerance)
+}
+
+// trackMinRTT обновляет базовую задержку: минимум по текущему окну замеров
+func (a *AdaptiveLimit) trackMinRTT(rtt time.Duration) {
+    if a.minRTT == 0 || rtt < a.minRTT {
+        a.minRTT = rtt
+    }
+    if a.windowMin == 0 || rtt < a.windowMin {
+        a.windowMin = rtt
+    }
+    a.samples++
+    if a.samples >= minRTTWindow {
+        a.minRTT = a.windowMin
+        a.windowMin = 0
+        a.samples = 0
+    }
+}
@@ -1,8 +1,10 @@
 package balancer
 
 import (
-    "net/http"
+    "errors"
+    "fmt"
     "net/url"
+    "slices"
     "sync/atomic"
     "time"
 
@@ -13,26 +15,131 @@ import (
 type Backend struct {
     URL   *url.URL     // Адрес backend-сервера
     Alive atomic.Bool  // Флаг, указывающий, жив ли backend (используется в health-check)
+
+    active   atomic.Int64 // Количество запросов, обрабатываемых backend'ом прямо сейчас
+    draining atomic.Bool  // Backend выводится из ротации: новые запросы на него не назначаются
+    maxConns int64        // Максимум одновременных запросов (0 — без ограничения)
+    adaptive *AdaptiveLimit // Адаптивный лимит одновременных запросов (nil — выключен)
 }
 
-// RoundRobinBalancer реализует балансировку нагрузки по принципу Round-Robin
-// с проверкой состояния backend'ов (health-check).
-type RoundRobinBalancer struct {
+var (
+    // ErrNoAliveBackends — ни один backend не прошёл health-check
+    ErrNoAliveBackends = errors.New("no alive backends")
+    // ErrBackendsAtCapacity — живые backend'ы есть, но все заняты до предела
+    ErrBackendsAtCapacity = errors.New("all backends are at concurrency limit")
+)
+
+// acquire занимает слот для запроса, если backend не достиг лимита
+func (b *Backend) acquire() bool {
+    for {
+        n := b.active.Load()
+        if b.maxConns > 0 && n >= b.maxConns {
+            return false
+        }
+        if b.adaptive != nil && n >= b.adaptive.Limit() {
+            return false
+        }
+        if b.active.CompareAndSwap(n, n+1) {
+            return true
+        }
+    }
+}
+
+// Release освобождает слот, занятый при выборе backend'а в NextBackend
+func (b *Backend) Release() {
+    b.active.Add(-1)
+}
+
+// ObserveLatency сообщает адаптивному лимиту задержку ответа backend'а.
+// failed — запрос завершился ошибкой соединения или 5xx.
+func (b *Backend) ObserveLatency(rtt time.Duration, failed bool) {
+    if b.adaptive != nil {
+        b.adaptive.Observe(rtt, failed)
+    }
+}
+
+// ConcurrencyLimit возвращает текущий лимит одновременных запросов к backend'у (0 — без ограничения)
+func (b *Backend) ConcurrencyLimit() int64 {
+    if b.adaptive != nil {
+        if b.maxConns > 0 && b.maxConns < b.adaptive.Limit() {
+            return b.maxConns
+        }
+        return b.adaptive.Limit()
+    }
+    return b.maxConns
+}
+
+// ActiveRequests возвращает количество запросов, обрабатываемых backend'ом
+func (b *Backend) ActiveRequests() int64 {
+    return b.active.Load()
+}
+
+// SetDraining выводит backend из ротации (или возвращает в неё). Запросы в обработке
+// завершаются как обычно, а новые, в том числе от закреплённых за ним клиентов,
+// достаются другим backend'ам.
+func (b *Backend) SetDraining(draining bool) {
+    b.draining.Store(draining)
+}
+
+// Draining сообщает, выведен ли backend из ротации
+func (b *Backend) Draining() bool {
+    return b.draining.Load()
+}
+
+// Balancer выбирает backend для очередного запроса (или соединения)
+type Balancer interface {
+    // NextBackend выбирает backend и занимает на нём слот — после обработки нужно
+    // вызвать Backend.Release. key идентифицирует клиента (используется стратегиями
+    // с привязкой клиента к backend'у).
+    NextBackend(key string) (*Backend, error)
+    // NextBackendExcept — то же, что NextBackend, но backend'ы из exclude не выбираются
+    // (например, уже опробованные при повторе запроса)
+    NextBackendExcept(key string, exclude []*Backend) (*Backend, error)
+    // MarkBackendDead помечает backend как недоступный до следующей успешной проверки
+    MarkBackendDead(target *url.URL)
+    // Backends возвращает список всех backend'ов
+    Backends() []*Backend
+    // SetMaxConnsPerBackend ограничивает количество одновременных запросов к каждому backend'у
+    SetMaxConnsPerBackend(n int)
+    // EnableAdaptiveConcurrency включает адаптивный лимит одновременных запросов
+    EnableAdaptiveConcurrency(opts AdaptiveOptions)
+}
+
+// Стратегии балансировки
+const (
+    StrategyRoundRobin       = "round_robin"
+    StrategyLeastConnections = "least_connections"
+    StrategyIPHash           = "ip_hash"
+)
+
+// New создаёт балансировщик с указанной стратегией и запускает health-check loop.
+// Пустая стратегия означает round_robin.
+func New(strategy string, urls []string, health HealthCheck, logger *zap.SugaredLogger) (Balancer, error) {
+    switch strategy {
+    case "", StrategyRoundRobin:
+        return &RoundRobinBalancer{backendSet: newBackendSet(urls, health, logger)}, nil
+    case StrategyLeastConnections:
+        return &LeastConnectionsBalancer{backendSet: newBackendSet(urls, health, logger)}, nil
+    case StrategyIPHash:
+        return &IPHashBalancer{backendSet: newBackendSet(urls, health, logger)}, nil
+    default:
+        return nil, fmt.Errorf("unknown balancing strategy %q", strategy)
+    }
+}
+
+// backendSet — общая для всех стратегий часть: список backend'ов, лимиты и health-check
+type backendSet struct {
     backends []*Backend           // Список всех backend'ов
-    index    uint32               // Индекс текущего backend'a (для цикличного выбора)
     logger   *zap.SugaredLogger   // Логгер
-
-    checkInterval time.Duration   // Интервал между проверками состояния серверов
-    timeout       time.Duration   // Таймаут для health-check запроса
+    health   HealthCheck          // Настройки проверки состояния backend'ов
 }
 
-// NewRoundRobin создает новый RoundRobinBalancer и запускает health-check loop.
-func NewRoundRobin(urls []string, logger *zap.SugaredLogger) *RoundRobinBalancer {
-    bb := &RoundRobinBalancer{
-        backends:      make([]*Backend, 0, len(urls)),
-        logger:        logger,
-        checkInterval: 10 * time.Second, // Проверка каждые 10 секунд
-        timeout:       2 * time.Second,  // 2 секунды на health-check
+// newBackendSet разбирает адреса backend'ов и запускает фоновую проверку их здоровья
+func newBackendSet(urls []string, health HealthCheck, logger *zap.SugaredLogger) backendSet {
+    set := backendSet{
+        backends: make([]*Backend, 0, len(urls)),
+        logger:   logger,
+        health:   health.withDefaults(),
     }
 
     // Инициализация backend'ов
@@ -44,79 +151,113 @@ func NewRoundRobin(urls []string, logger *zap.SugaredLogger) *RoundRobinBalancer
         }
         b := &Backend{URL: u}
         b.Alive.Store(true) // По умолчанию считаем, что backend живой
-        bb.backends = append(bb.backends, b)
+        set.backends = append(set.backends, b)
         logger.Infof("added backend: %s", u.String())
     }
 
     // Запускаем фоновую проверку здоровья серверов
-    go bb.healthLoop()
-
-    return bb
-}
-
-// healthLoop запускается в отдельной горутине и периодически проверяет
-// доступность всех backend'ов по адресу /health.
-func (r *RoundRobinBalancer) healthLoop() {
-    client := &http.Client{Timeout: r.timeout}
-    ticker := time.NewTicker(r.checkInterval)
-    defer ticker.Stop()
-
-    for range ticker.C {
-        for _, b := range r.backends {
-            // Проверка каждого backend'a в отдельной горутине
-            go func(b *Backend) {
-                // Выполняем GET-запрос на /health
-                resp, err := client.Get(b.URL.String() + "/health")
-
-                // alive = true, если нет ошибки и статус код OK
-                alive := err == nil && resp.StatusCode == http.StatusOK
-                b.Alive.Store(alive)
-
-                if alive {
-                    r.logger.Debugf("health OK: %s", b.URL)
-                } else {
-                    r.logger.Warnf("health FAILED: %s (%v)", b.URL, err)
-                }
-
-                // Закрываем тело ответа, если он был
-                if resp != nil {
-                    resp.Body.Close()
-                }
-            }(b)
-        }
+    go set.healthLoop()
+
+    return set
+}
+
+// SetMaxConnsPerBackend ограничивает количество одновременных запросов к каждому backend'у.
+// Запросы сверх лимита уходят на другие backend'ы. Вызывается до начала обработки запросов.
+func (s *backendSet) SetMaxConnsPerBackend(n int) {
+    for _, b := range s.backends {
+        b.maxConns = int64(n)
     }
 }
 
-// NextBackend возвращает следующий доступный backend в порядке Round-Robin.
-// Пропускает мертвые сервера.
-func (r *RoundRobinBalancer) NextBackend() *Backend {
-    total := len(r.backends)
-    for i := 0; i < total; i++ {
-        // Инкрементируем индекс атомарно и берём модуль по количеству backend'ов
-        idx := atomic.AddUint32(&r.index, 1) % uint32(total)
-        b := r.backends[idx]
+// EnableAdaptiveConcurrency включает адаптивный (AIMD) лимит одновременных запросов
+// для каждого backend'а. Вызывается до начала обработки запросов.
+func (s *backendSet) EnableAdaptiveConcurrency(opts AdaptiveOptions) {
+    for _, b := range s.backends {
+        b.adaptive = NewAdaptiveLimit(opts)
+    }
+}
 
-        // Если backend живой, возвращаем его
-        if b.Alive.Load() {
-            r.logger.Debugf("selected backend: %s", b.URL)
-            return b
+// Backends возвращает список всех backend'ов балансировщика
+func (s *backendSet) Backends() []*Backend {
+    return s.backends
+}
+
+// pick перебирает кандидатов в заданном стратегией порядке и занимает слот
+// на первом живом backend'е не в режиме drain и не из exclude, не достигшем
+// лимита одновременных запросов.
+func (s *backendSet) pick(exclude []*Backend, candidates func(yield func(*Backend) bool)) (*Backend, error) {
+    var selected *Backend
+    busy := false
+    candidates(func(b *Backend) bool {
+        if !b.Alive.Load() || b.Draining() || slices.Contains(exclude, b) {
+            return true
+        }
+        // Если backend живой и не перегружен, выбираем его
+        if b.acquire() {
+            selected = b
+            return false
         }
+        busy = true
+        return true
+    })
+
+    if selected != nil {
+        s.logger.Debugf("selected backend: %s", selected.URL)
+        return selected, nil
+    }
+    if busy {
+        s.logger.Warn("all alive backends are at concurrency limit")
+        return nil, ErrBackendsAtCapacity
     }
 
     // Если ни один backend не доступен
-    r.logger.Warn("no alive backends available")
-    return nil
+    s.logger.Warn("no alive backends available")
+    return nil, ErrNoAliveBackends
 }
 
 // MarkBackendDead помечает указанный backend как "мертвый" (Alive = false).
 // Используется в случае ошибки проксирования.
-func (r *RoundRobinBalancer) MarkBackendDead(target *url.URL) {
-    for _, b := range r.backends {
+func (s *backendSet) MarkBackendDead(target *url.URL) {
+    for _, b := range s.backends {
         if b.URL.String() == target.String() {
             b.Alive.Store(false)
-            r.logger.Warnf("marked backend dead: %s", target)
+            s.logger.Warnf("marked backend dead: %s", target)
             // Он останется мертвым до следующей успешной проверки healthLoop
             return
         }
     }
 }
+
+// RoundRobinBalancer реализует балансировку нагрузки по принципу Round-Robin
+// с проверкой состояния backend'ов (health-check).
+type RoundRobinBalancer struct {
+    backendSet
+    index uint32 // Индекс текущего backend'a (для цикличного выбора)
+}
+
+// NewRoundRobin создает новый RoundRobinBalancer с проверкой /health по умолчанию
+// и запускает health-check loop.
+func NewRoundRobin(urls []string, logger *zap.SugaredLogger) *RoundRobinBalancer {
+    return &RoundRobinBalancer{backendSet: newBackendSet(urls, HealthCheck{}, logger)}
+}
+
+// NextBackend возвращает следующий доступный backend в порядке Round-Robin
+// и занимает на нём слот запроса — после обработки нужно вызвать Backend.Release.
+// Пропускает мертвые сервера и сервера, достигшие лимита одновременных запросов.
+func (r *RoundRobinBalancer) NextBackend(key string) (*Backend, error) {
+    return r.NextBackendExcept(key, nil)
+}
+
+// NextBackendExcept — NextBackend без backend'ов из exclude
+func (r *RoundRobinBalancer) NextBackendExcept(_ string, exclude []*Backend) (*Backend, error) {
+    return r.pick(exclude, func(yield func(*Backend) bool) {
+        total := len(r.backends)
+        for i := 0; i < total; i++ {
+            // Инкрементируем индекс атомарно и берём модуль по количеству backend'ов
+            idx := atomic.AddUint32(&r.index, 1) % uint32(total)
+            if !yield(r.backends[idx]) {
+                return
+            }
+        }
+    })
+}
@@ -0,0 +1,106 @@
+package balancer
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+)
+
+// grpcHealthPath — метод стандартного протокола проверки состояния gRPC
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// grpcServing — значение HealthCheckResponse.ServingStatus.SERVING
+const grpcServing = 1
+
+// probeGRPC вызывает grpc.health.v1.Health/Check. Сообщения protobuf кодируются
+// вручную: у HealthCheckRequest и HealthCheckResponse по одному полю, и ради
+// них не стоит тянуть в балансировщик gRPC и кодогенерацию. Транспорт должен
+// поддерживать HTTP/2 (protocol пула h2c или http2).
+func probeGRPC(client *http.Client, baseURL, service string) error {
+    // HealthCheckRequest { string service = 1; }
+    var msg []byte
+    if service != "" {
+        msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+        msg = append(msg, service...)
+    }
+    // Кадр gRPC: флаг сжатия, длина сообщения (big endian), сообщение
+    frame := make([]byte, 5, 5+len(msg))
+    binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+    frame = append(frame, msg...)
+
+    req, err := http.NewRequest(http.MethodPost, baseURL+grpcHealthPath, bytes.NewReader(frame))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/grpc")
+    req.Header.Set("TE", "trailers")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    body, err := io.ReadAll(resp.Body) // Трейлеры доступны только после чтения тела
+    if err != nil {
+        return err
+    }
+
+    status := resp.Trailer.Get("Grpc-Status")
+    if status == "" {
+        status = resp.Header.Get("Grpc-Status") // Ответ только из заголовков (ошибка)
+    }
+    if status != "0" {
+        return fmt.Errorf("grpc status %s: %s", status, resp.Header.Get("Grpc-Message")+resp.Trailer.Get("Grpc-Message"))
+    }
+
+    serving, err := parseServingStatus(body)
+    if err != nil {
+        return err
+    }
+    if serving != grpcServing {
+        return fmt.Errorf("serving status %d", serving)
+    }
+    return nil
+}
+
+// parseServingStatus извлекает поле status из HealthCheckResponse { ServingStatus status = 1; }
+func parseServingStatus(frame []byte) (uint64, error) {
+    if len(frame) < 5 {
+        return 0, errors.New("short grpc response")
+    }
+    if frame[0] != 0 {
+        return 0, errors.New("compressed grpc response is not supported")
+    }
+    n := binary.BigEndian.Uint32(frame[1:5])
+    if uint32(len(frame)-5) < n {
+        return 0, errors.New("truncated grpc response")
+    }
+    msg := frame[5 : 5+n]
+
+    var status uint64 // Поле по умолчанию (UNKNOWN) в protobuf не передаётся
+    for len(msg) > 0 {
+        key, k := binary.Uvarint(msg)
+        if k <= 0 {
+            return 0, errors.New("malformed health response")
+        }
+        msg = msg[k:]
+        if key&7 != 0 {
+            return 0, fmt.Errorf("unexpected wire type %d in health response", key&7)
+        }
+        value, v := binary.Uvarint(msg)
+        if v <= 0 {
+            return 0, errors.New("malformed health response")
+        }
+        msg = msg[v:]
+        if key>>3 == 1 {
+            status = value
+        }
+    }
+    return status, nil
+}
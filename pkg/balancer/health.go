@@ -0,0 +1,96 @@
+package balancer
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "time"
+)
+
+// HealthCheck — настройки периодической проверки состояния backend'ов
+type HealthCheck struct {
+    Path     string        // Путь проверки (по умолчанию /health)
+    Interval time.Duration // Интервал между проверками (по умолчанию 10s)
+    Timeout  time.Duration // Таймаут одной проверки (по умолчанию 2s)
+
+    Transport http.RoundTripper // Транспорт проверок, например с TLS-настройками пула (nil — по умолчанию)
+
+    GRPC     bool   // Проверять по протоколу grpc.health.v1 вместо GET на Path
+    Service  string // Имя сервиса для grpc.health.v1 ("" — сервер целиком)
+    TCP      bool   // Проверять только установку TCP-соединения (L4-прокси)
+    Disabled bool   // Не проверять: backend'ы всегда считаются живыми
+}
+
+// withDefaults подставляет значения по умолчанию для незаданных полей
+func (h HealthCheck) withDefaults() HealthCheck {
+    if h.Path == "" {
+        h.Path = "/health"
+    }
+    if h.Interval <= 0 {
+        h.Interval = 10 * time.Second // Проверка каждые 10 секунд
+    }
+    if h.Timeout <= 0 {
+        h.Timeout = 2 * time.Second // 2 секунды на health-check
+    }
+    return h
+}
+
+// healthLoop запускается в отдельной горутине и периодически проверяет
+// доступность всех backend'ов: GET на health.Path, grpc.health.v1 или TCP-соединением.
+func (s *backendSet) healthLoop() {
+    if s.health.Disabled {
+        return
+    }
+    client := &http.Client{Timeout: s.health.Timeout, Transport: s.health.Transport}
+    ticker := time.NewTicker(s.health.Interval)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        for _, b := range s.backends {
+            // Проверка каждого backend'a в отдельной горутине
+            go func(b *Backend) {
+                var err error
+                switch {
+                case s.health.TCP:
+                    err = probeTCP(b.URL.Host, s.health.Timeout)
+                case s.health.GRPC:
+                    err = probeGRPC(client, b.URL.String(), s.health.Service)
+                default:
+                    err = probeHTTP(client, b.URL.String()+s.health.Path)
+                }
+
+                // alive = true, если проверка прошла без ошибок
+                alive := err == nil
+                b.Alive.Store(alive)
+
+                if alive {
+                    s.logger.Debugf("health OK: %s", b.URL)
+                } else {
+                    s.logger.Warnf("health FAILED: %s (%v)", b.URL, err)
+                }
+            }(b)
+        }
+    }
+}
+
+// probeTCP проверяет, что backend принимает TCP-соединения
+func probeTCP(addr string, timeout time.Duration) error {
+    conn, err := net.DialTimeout("tcp", addr, timeout)
+    if err != nil {
+        return err
+    }
+    return conn.Close()
+}
+
+// probeHTTP выполняет GET-запрос и ожидает статус 200 OK
+func probeHTTP(client *http.Client, url string) error {
+    resp, err := client.Get(url)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return nil
+}
@@ -0,0 +1,88 @@
+package balancer
+
+import (
+    "hash/fnv"
+    "sort"
+    "sync/atomic"
+)
+
+// LeastConnectionsBalancer выбирает backend с наименьшим количеством
+// запросов в обработке. Подходит для запросов с сильно разной длительностью.
+type LeastConnectionsBalancer struct {
+    backendSet
+    next uint32 // Сдвиг начала перебора: при равной загрузке backend'ы чередуются
+}
+
+// NextBackend возвращает наименее загруженный доступный backend и занимает на нём слот.
+// Среди одинаково загруженных выбор идёт по кругу, иначе короткие запросы
+// всегда доставались бы первому backend'у.
+func (l *LeastConnectionsBalancer) NextBackend(key string) (*Backend, error) {
+    return l.NextBackendExcept(key, nil)
+}
+
+// NextBackendExcept — NextBackend без backend'ов из exclude
+func (l *LeastConnectionsBalancer) NextBackendExcept(_ string, exclude []*Backend) (*Backend, error) {
+    total := len(l.backends)
+    if total == 0 {
+        return l.pick(exclude, func(func(*Backend) bool) {})
+    }
+    start := int(atomic.AddUint32(&l.next, 1) % uint32(total))
+    ordered := make([]*Backend, 0, total)
+    ordered = append(ordered, l.backends[start:]...)
+    ordered = append(ordered, l.backends[:start]...)
+    sort.SliceStable(ordered, func(i, j int) bool {
+        return ordered[i].ActiveRequests() < ordered[j].ActiveRequests()
+    })
+
+    return l.pick(exclude, func(yield func(*Backend) bool) {
+        for _, b := range ordered {
+            if !yield(b) {
+                return
+            }
+        }
+    })
+}
+
+// IPHashBalancer закрепляет клиента за backend'ом (sticky-сессии) с помощью
+// rendezvous-хэширования: при выходе backend'а из строя переезжают только его клиенты.
+type IPHashBalancer struct {
+    backendSet
+}
+
+// NextBackend возвращает backend, закреплённый за клиентом key. Если он недоступен
+// или перегружен, выбирается следующий по рангу для этого клиента.
+func (h *IPHashBalancer) NextBackend(key string) (*Backend, error) {
+    return h.NextBackendExcept(key, nil)
+}
+
+// NextBackendExcept — NextBackend без backend'ов из exclude
+func (h *IPHashBalancer) NextBackendExcept(key string, exclude []*Backend) (*Backend, error) {
+    type ranked struct {
+        backend *Backend
+        score   uint64
+    }
+    ordered := make([]ranked, len(h.backends))
+    for i, b := range h.backends {
+        ordered[i] = ranked{b, rendezvousScore(key, b.URL.String())}
+    }
+    sort.Slice(ordered, func(i, j int) bool {
+        return ordered[i].score > ordered[j].score
+    })
+
+    return h.pick(exclude, func(yield func(*Backend) bool) {
+        for _, r := range ordered {
+            if !yield(r.backend) {
+                return
+            }
+        }
+    })
+}
+
+// rendezvousScore — вес пары (клиент, backend) для rendezvous-хэширования
+func rendezvousScore(key, backend string) uint64 {
+    hash := fnv.New64a()
+    hash.Write([]byte(key))
+    hash.Write([]byte{0})
+    hash.Write([]byte(backend))
+    return hash.Sum64()
+}
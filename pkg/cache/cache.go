@@ -0,0 +1,307 @@
+// Package cache реализует HTTP-кэш ответов в памяти: свежесть по Cache-Control
+// и Expires, проверка через ETag/Last-Modified, варианты по Vary, вытеснение
+// по LRU с ограничением суммарного размера.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry — сохранённый ответ
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	stored  time.Time     // Момент, когда возраст ответа был нулевым (с учётом заголовка Age)
+	fresh   time.Duration // Время свежести
+	swr     time.Duration // stale-while-revalidate
+	sie     time.Duration // stale-if-error
+	key     string
+	variant string // Значения заголовков из Vary
+}
+
+// Age возвращает возраст ответа
+func (e *Entry) Age(now time.Time) time.Duration {
+	if age := now.Sub(e.stored); age > 0 {
+		return age
+	}
+	return 0
+}
+
+// Fresh сообщает, что ответ можно выдать без обращения к backend'у
+func (e *Entry) Fresh(now time.Time) bool {
+	return e.Age(now) < e.fresh
+}
+
+// StaleWhileRevalidate сообщает, что устаревший ответ можно выдать, обновляя его в фоне
+func (e *Entry) StaleWhileRevalidate(now time.Time) bool {
+	return e.Age(now) < e.fresh+e.swr
+}
+
+// StaleIfError сообщает, что устаревший ответ можно выдать при ошибке backend'а
+func (e *Entry) StaleIfError(now time.Time) bool {
+	return e.Age(now) < e.fresh+e.sie
+}
+
+// Validators возвращает ETag и Last-Modified для условного запроса
+func (e *Entry) Validators() (etag, lastModified string) {
+	return e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body) + len(e.key) + len(e.variant))
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// Cache — кэш ответов с вытеснением давно не использованных записей
+type Cache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	lru     *list.List               // Записи от недавно использованных к давним
+	entries map[string]*list.Element // По ключу и варианту
+	vary    map[string][]string      // Заголовки Vary последнего ответа по ключу
+	size    int64
+}
+
+// New создаёт кэш объёмом не больше maxBytes
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		vary:     make(map[string][]string),
+	}
+}
+
+// variant возвращает значения заголовков запроса, по которым различаются ответы
+func variant(names []string, r *http.Request) string {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// varyNames разбирает заголовок Vary ответа
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, line := range h.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// Get возвращает запись для запроса (свежую или устаревшую)
+func (c *Cache) Get(key string, r *http.Request) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key+"\x00"+variant(c.vary[key], r)]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*Entry), true
+}
+
+// Put сохраняет ответ, если политика это разрешает. Возвращает сохранённую запись.
+func (c *Cache) Put(key string, r *http.Request, p Policy, status int, h http.Header, body []byte) (*Entry, bool) {
+	now := time.Now()
+	fresh, swr, sie, ok := p.freshness(r, status, h, now)
+	if !ok {
+		return nil, false
+	}
+
+	h = h.Clone()
+	stored := now
+	if age, err := strconv.Atoi(h.Get("Age")); err == nil && age > 0 {
+		stored = now.Add(-time.Duration(age) * time.Second) // Ответ уже полежал в кэше выше
+	}
+	names := varyNames(h)
+	e := &Entry{
+		Status:  status,
+		Header:  h,
+		Body:    body,
+		stored:  stored,
+		fresh:   fresh,
+		swr:     swr,
+		sie:     sie,
+		key:     key,
+		variant: variant(names, r),
+	}
+	if e.size() > c.maxBytes {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vary[key] = names
+	c.removeLocked(key + "\x00" + e.variant)
+	c.entries[key+"\x00"+e.variant] = c.lru.PushFront(e)
+	c.size += e.size()
+	for c.size > c.maxBytes {
+		oldest := c.lru.Back().Value.(*Entry)
+		c.removeLocked(oldest.key + "\x00" + oldest.variant)
+	}
+	return e, true
+}
+
+// Refresh обновляет запись по ответу 304 Not Modified: заголовки и свежесть
+// берутся из нового ответа, тело остаётся прежним
+func (c *Cache) Refresh(e *Entry, r *http.Request, p Policy, h http.Header) (*Entry, bool) {
+	merged := e.Header.Clone()
+	for k, vs := range h {
+		merged[k] = vs
+	}
+	return c.Put(e.key, r, p, e.Status, merged, e.Body)
+}
+
+// removeLocked удаляет запись по полному ключу. Вызывается под c.mu.
+func (c *Cache) removeLocked(full string) {
+	el, ok := c.entries[full]
+	if !ok {
+		return
+	}
+	e := el.Value.(*Entry)
+	c.lru.Remove(el)
+	delete(c.entries, full)
+	c.size -= e.size()
+}
+
+// Purge удаляет записи, ключ которых (хост и путь) начинается с prefix.
+// Пустой prefix очищает кэш. Возвращает количество удалённых записей.
+func (c *Cache) Purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix = normalizePrefix(prefix)
+	n := 0
+	for full, el := range c.entries {
+		if strings.HasPrefix(el.Value.(*Entry).key, prefix) {
+			c.removeLocked(full)
+			n++
+		}
+	}
+	for key := range c.vary {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.vary, key)
+		}
+	}
+	return n
+}
+
+// normalizePrefix приводит префикс к виду ключа (см. Key): регистр не важен
+// только в имени хоста, путь сравнивается как есть
+func normalizePrefix(prefix string) string {
+	host, path, found := strings.Cut(prefix, "/")
+	if !found {
+		return strings.ToLower(prefix)
+	}
+	return strings.ToLower(host) + "/" + path
+}
+
+// Len возвращает количество записей
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Size возвращает суммарный размер записей в байтах
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Flight объединяет одновременные промахи по одному ключу: к backend'у идёт
+// один запрос, остальные ждут его результата в кэше. Ключи, ответ по которым
+// не сохраняется, на время перестают объединяться: ждать такого ответа бесполезно.
+type Flight struct {
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+	pass    map[string]time.Time // Некэшируемые ключи и время, до которого они не объединяются
+	swept   time.Time            // Последняя очистка pass от истёкших ключей
+}
+
+// passTTL — сколько ключ с некэшируемым ответом не объединяется
+const passTTL = 30 * time.Second
+
+// NewFlight создаёт пустую группу запросов
+func NewFlight() *Flight {
+	return &Flight{waiting: make(map[string]chan struct{}), pass: make(map[string]time.Time)}
+}
+
+// Join возвращает leader = true для первого запроса по ключу; он должен вызвать
+// Done. Остальные получают канал, закрывающийся по завершении первого.
+func (f *Flight) Join(key string) (leader bool, done <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ch, ok := f.waiting[key]; ok {
+		return false, ch
+	}
+	f.waiting[key] = make(chan struct{})
+	return true, nil
+}
+
+// Done завершает запрос лидера и будит ожидающих
+func (f *Flight) Done(key string) {
+	f.mu.Lock()
+	ch := f.waiting[key]
+	delete(f.waiting, key)
+	f.mu.Unlock()
+	if ch != nil {
+		close(ch)
+	}
+}
+
+// Uncacheable сообщает, что последний ответ по ключу не сохранился в кэш
+// и одновременные запросы по нему не нужно объединять
+func (f *Flight) Uncacheable(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	until, ok := f.pass[key]
+	if ok && time.Now().After(until) {
+		delete(f.pass, key)
+		return false
+	}
+	return ok
+}
+
+// SetCacheable запоминает, сохранился ли ответ по ключу в кэш
+func (f *Flight) SetCacheable(key string, cacheable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cacheable {
+		delete(f.pass, key)
+		return
+	}
+	now := time.Now()
+	f.pass[key] = now.Add(passTTL)
+	if now.Sub(f.swept) > passTTL {
+		f.swept = now
+		for k, until := range f.pass {
+			if now.After(until) {
+				delete(f.pass, k)
+			}
+		}
+	}
+}
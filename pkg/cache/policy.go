@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directives разбирает Cache-Control в словарь директив (значения без кавычек)
+func directives(h http.Header) map[string]string {
+	d := make(map[string]string)
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			d[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return d
+}
+
+// seconds возвращает значение директивы в секундах
+func seconds(d map[string]string, name string) (time.Duration, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// Key возвращает ключ запроса в кэше. HEAD использует записи GET.
+func Key(r *http.Request) string {
+	return strings.ToLower(r.Host) + r.URL.RequestURI()
+}
+
+// Cacheable сообщает, может ли запрос быть обслужен из кэша
+func Cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Range") != "" {
+		return false // Частичные ответы не кэшируются
+	}
+	return !directivesHas(r.Header, "no-store")
+}
+
+// Bypass сообщает, что клиент требует ответ от backend'а (no-cache в запросе).
+// Полученный ответ всё равно сохраняется в кэш.
+func Bypass(r *http.Request) bool {
+	return directivesHas(r.Header, "no-cache") || strings.Contains(r.Header.Get("Pragma"), "no-cache")
+}
+
+func directivesHas(h http.Header, name string) bool {
+	_, ok := directives(h)[name]
+	return ok
+}
+
+// cacheableStatus — коды ответов, которые кэшируются (RFC 9111, "heuristically cacheable")
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// Policy — правила сохранения ответов
+type Policy struct {
+	TTL time.Duration // Время свежести вместо заданного backend'ом (0 — по заголовкам ответа)
+}
+
+// freshness вычисляет время свежести ответа и окна выдачи устаревшей копии.
+// ok = false — ответ сохранять нельзя.
+func (p Policy) freshness(r *http.Request, status int, h http.Header, now time.Time) (fresh, swr, sie time.Duration, ok bool) {
+	if !cacheableStatus[status] {
+		return 0, 0, 0, false
+	}
+	if h.Get("Set-Cookie") != "" {
+		return 0, 0, 0, false // Персональные данные в общем кэше
+	}
+	if strings.TrimSpace(h.Get("Vary")) == "*" {
+		return 0, 0, 0, false
+	}
+
+	d := directives(h)
+	if _, noStore := d["no-store"]; noStore {
+		return 0, 0, 0, false
+	}
+	if _, private := d["private"]; private {
+		return 0, 0, 0, false
+	}
+	if r.Header.Get("Authorization") != "" {
+		_, public := d["public"]
+		_, shared := d["s-maxage"]
+		if !public && !shared {
+			return 0, 0, 0, false
+		}
+	}
+	swr, _ = seconds(d, "stale-while-revalidate")
+	sie, _ = seconds(d, "stale-if-error")
+
+	explicit := true
+	if _, noCache := d["no-cache"]; noCache {
+		fresh = 0 // Хранится, но перед каждой выдачей проверяется у backend'а
+	} else if p.TTL > 0 {
+		fresh = p.TTL
+	} else if v, has := seconds(d, "s-maxage"); has {
+		fresh = v
+	} else if v, has := seconds(d, "max-age"); has {
+		fresh = v
+	} else if exp := h.Get("Expires"); exp != "" {
+		expires, err := http.ParseTime(exp)
+		date, derr := http.ParseTime(h.Get("Date"))
+		if derr != nil {
+			date = now
+		}
+		if err == nil && expires.After(date) {
+			fresh = expires.Sub(date)
+		}
+	} else {
+		explicit = false
+	}
+
+	if !explicit {
+		return 0, 0, 0, false // Эвристическую свежесть не используем
+	}
+	// Без времени свежести запись полезна, только если её можно проверить или выдать устаревшей
+	if fresh == 0 && h.Get("ETag") == "" && h.Get("Last-Modified") == "" && swr == 0 && sie == 0 {
+		return 0, 0, 0, false
+	}
+	return fresh, swr, sie, true
+}
@@ -0,0 +1,272 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Pair — пути к сертификату (PEM, можно с цепочкой) и его закрытому ключу
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// loaded — загруженный сертификат и время изменения его файлов на момент загрузки
+type loaded struct {
+	pair    Pair
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// table — неизменяемая таблица выбора сертификата по SNI. При перезагрузке
+// строится заново и подменяется целиком, поэтому рукопожатия не блокируются.
+type table struct {
+	byName   map[string]*tls.Certificate // Точные имена и шаблоны "*.example.com"
+	fallback *tls.Certificate            // Для клиентов без SNI или с неизвестным именем
+}
+
+// Store хранит сертификаты листенера, выбирает нужный по SNI и перечитывает
+// файлы, когда они меняются на диске (например, после обновления certbot'ом)
+type Store struct {
+	logger *zap.SugaredLogger
+
+	mu      sync.Mutex // Сериализует перезагрузки
+	entries []loaded
+	table   atomic.Pointer[table]
+}
+
+// NewStore загружает сертификаты. Первый сертификат используется по умолчанию.
+func NewStore(pairs []Pair, logger *zap.SugaredLogger) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+	s := &Store{logger: logger}
+	for _, p := range pairs {
+		entry, err := load(p)
+		if err != nil {
+			return nil, err
+		}
+		s.entries = append(s.entries, entry)
+	}
+	s.rebuild()
+	return s, nil
+}
+
+// load читает пару файлов и разбирает leaf-сертификат
+func load(p Pair) (loaded, error) {
+	modTime, err := latestModTime(p)
+	if err != nil {
+		return loaded{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+	if err != nil {
+		return loaded{}, fmt.Errorf("load certificate %s: %w", p.CertFile, err)
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return loaded{}, fmt.Errorf("parse certificate %s: %w", p.CertFile, err)
+		}
+		cert.Leaf = leaf
+	}
+	return loaded{pair: p, cert: &cert, modTime: modTime}, nil
+}
+
+// latestModTime возвращает время последнего изменения сертификата или ключа
+func latestModTime(p Pair) (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{p.CertFile, p.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// rebuild строит таблицу выбора по SAN (или CN) сертификатов; вызывается под mu
+// или до начала использования Store. При совпадении имён побеждает первый сертификат.
+func (s *Store) rebuild() {
+	t := &table{byName: make(map[string]*tls.Certificate), fallback: s.entries[0].cert}
+	for _, e := range s.entries {
+		names := e.cert.Leaf.DNSNames
+		if len(names) == 0 && e.cert.Leaf.Subject.CommonName != "" {
+			names = []string{e.cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := t.byName[name]; !ok {
+				t.byName[name] = e.cert
+			}
+		}
+	}
+	s.table.Store(t)
+}
+
+// GetCertificate выбирает сертификат по имени из SNI: точное совпадение, затем
+// шаблон на один уровень ("*.example.com"), иначе сертификат по умолчанию.
+// Подходит для tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	t := s.table.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return t.fallback, nil
+	}
+	if cert, ok := t.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := t.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return t.fallback, nil
+}
+
+// Reload перечитывает сертификаты, файлы которых изменились. Если новый файл
+// не читается (например, записан наполовину), остаётся прежний сертификат.
+// Возвращает количество перезагруженных сертификатов.
+func (s *Store) Reload() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reloaded := 0
+	for i, e := range s.entries {
+		modTime, err := latestModTime(e.pair)
+		if err != nil {
+			s.logger.Warnf("failed to stat certificate %s: %v", e.pair.CertFile, err)
+			continue
+		}
+		if !modTime.After(e.modTime) {
+			continue
+		}
+		entry, err := load(e.pair)
+		if err != nil {
+			s.logger.Errorf("failed to reload certificate, keeping previous: %v", err)
+			continue
+		}
+		s.entries[i] = entry
+		reloaded++
+		s.logger.Infof("reloaded certificate %s (%s, expires %s)",
+			e.pair.CertFile, strings.Join(entry.cert.Leaf.DNSNames, ","), entry.cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	if reloaded > 0 {
+		s.rebuild()
+	}
+	return reloaded
+}
+
+// Run периодически проверяет файлы сертификатов, пока не закрыт stop
+func (s *Store) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// versions — поддерживаемые значения минимальной версии TLS
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion разбирает версию TLS ("1.2", "1.3"). Пустая строка — TLS 1.2.
+func ParseVersion(s string) (uint16, error) {
+	if s == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := versions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites переводит имена наборов шифров (как в crypto/tls, например
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) в идентификаторы. Небезопасные наборы
+// не принимаются. Для TLS 1.3 наборы шифров не настраиваются.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ClientOptions — настройки TLS-клиента для соединений с backend'ами
+type ClientOptions struct {
+	CAFile             string // CA для проверки сертификата сервера ("" — системные)
+	CertFile           string // Клиентский сертификат для mTLS
+	KeyFile            string
+	ServerName         string // Имя для SNI и проверки сертификата вместо хоста из URL
+	InsecureSkipVerify bool   // Не проверять сертификат сервера — только для тестов
+}
+
+// ClientConfig собирает tls.Config для соединений с backend'ами
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if opts.CAFile != "" {
+		pool, err := LoadCAPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate %s: %w", opts.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// LoadCAPool читает PEM-файл с одним или несколькими сертификатами CA
+func LoadCAPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
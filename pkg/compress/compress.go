@@ -0,0 +1,412 @@
+// Package compress сжимает ответы (gzip, brotli, zstd) для клиентов,
+// которые это поддерживают, если backend не сжал ответ сам.
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// Поддерживаемые кодировки
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// DefaultTypes — типы содержимого, которые сжимаются по умолчанию
+var DefaultTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// DefaultStreamingTypes — потоковые типы содержимого: такие ответы не сжимаются и не
+// буферизуются, чтобы каждая запись backend'а сразу доходила до клиента
+var DefaultStreamingTypes = []string{
+	"text/event-stream",
+	"application/x-ndjson",
+	"application/stream+json",
+	"application/grpc",
+}
+
+// Options — настройки сжатия
+type Options struct {
+	Encodings      []string // Кодировки в порядке предпочтения (по умолчанию br, zstd, gzip)
+	Types          []string // Типы содержимого; "text/*" — все подтипы (по умолчанию DefaultTypes)
+	StreamingTypes []string // Потоковые типы, которые не сжимаются (по умолчанию DefaultStreamingTypes)
+	MinSize        int      // Ответы меньше не сжимаются (1024 байта)
+}
+
+// encoder — кодировщик, который можно переиспользовать для следующего ответа
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compressor выбирает кодировку и сжимает ответы
+type Compressor struct {
+	encodings []string
+	types     []string
+	streaming []string
+	minSize   int
+	encoders  map[string]*sync.Pool // Кодировщики по кодировке: создавать их на каждый ответ дорого
+}
+
+// New проверяет настройки сжатия
+func New(opts Options) (*Compressor, error) {
+	c := &Compressor{
+		encodings: opts.Encodings,
+		types:     opts.Types,
+		streaming: opts.StreamingTypes,
+		minSize:   opts.MinSize,
+		encoders:  make(map[string]*sync.Pool),
+	}
+	if len(c.encodings) == 0 {
+		c.encodings = []string{Brotli, Zstd, Gzip}
+	}
+	for _, enc := range c.encodings {
+		if enc != Gzip && enc != Brotli && enc != Zstd {
+			return nil, fmt.Errorf("unsupported encoding %q", enc)
+		}
+		// Первый кодировщик создаётся сразу: ошибки настроек видны при запуске
+		e, err := newEncoder(enc)
+		if err != nil {
+			return nil, fmt.Errorf("%s encoder: %w", enc, err)
+		}
+		pool := &sync.Pool{}
+		pool.Put(e)
+		c.encoders[enc] = pool
+	}
+	if len(c.types) == 0 {
+		c.types = DefaultTypes
+	}
+	if len(c.streaming) == 0 {
+		c.streaming = DefaultStreamingTypes
+	}
+	if c.minSize <= 0 {
+		c.minSize = 1024
+	}
+	return c, nil
+}
+
+// newEncoder создаёт кодировщик без получателя (он задаётся через Reset)
+func newEncoder(encoding string) (encoder, error) {
+	switch encoding {
+	case Brotli:
+		return brotli.NewWriterLevel(nil, 5), nil
+	case Zstd:
+		// Кодировщик на ответ: параллельное сжатие одного потока только плодит горутины
+		return zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	default:
+		return gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	}
+}
+
+// getEncoder берёт кодировщик из пула (или создаёт новый) и направляет его вывод в w
+func (c *Compressor) getEncoder(encoding string, w io.Writer) (encoder, error) {
+	if e, ok := c.encoders[encoding].Get().(encoder); ok {
+		e.Reset(w)
+		return e, nil
+	}
+	e, err := newEncoder(encoding)
+	if err != nil {
+		return nil, err
+	}
+	e.Reset(w)
+	return e, nil
+}
+
+// putEncoder возвращает закрытый кодировщик в пул
+func (c *Compressor) putEncoder(encoding string, e encoder) {
+	e.Reset(nil) // Не держим ссылку на ResponseWriter завершённого ответа
+	c.encoders[encoding].Put(e)
+}
+
+// negotiate выбирает кодировку по Accept-Encoding: сначала по весу q клиента,
+// при равном весе — по порядку предпочтения сервера. "" — сжимать нельзя.
+func (c *Compressor) negotiate(acceptEncoding string) string {
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else if name != "" {
+			weights[name] = q
+		}
+	}
+
+	candidates := make([]string, 0, len(c.encodings))
+	for _, enc := range c.encodings {
+		q, ok := weights[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, enc)
+			weights[enc] = q
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weights[candidates[i]] > weights[candidates[j]]
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+// compressibleType проверяет Content-Type по спискам типов. Потоковые типы
+// не сжимаются: каждая запись должна доходить до клиента без буферизации.
+func (c *Compressor) compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || matchType(c.streaming, mediaType) {
+		return false
+	}
+	return matchType(c.types, mediaType)
+}
+
+// matchType проверяет тип содержимого по списку; "text/*" — все подтипы
+func matchType(types []string, mediaType string) bool {
+	for _, t := range types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware сжимает ответы обработчика next
+func Middleware(c *Compressor, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Частичные ответы и смена протокола проходят без изменений
+			if r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &responseWriter{
+				ResponseWriter: w,
+				c:              c,
+				encoding:       c.negotiate(r.Header.Get("Accept-Encoding")),
+				head:           r.Method == http.MethodHead,
+				logger:         logger,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// Состояния responseWriter
+const (
+	stateUndecided  = iota // Копим начало тела, пока не станет ясно, сжимать ли
+	statePlain             // Ответ передаётся без изменений
+	stateCompressed        // Ответ сжимается
+)
+
+// responseWriter решает, сжимать ли ответ, по заголовкам и первым MinSize байтам
+type responseWriter struct {
+	http.ResponseWriter
+	c        *Compressor
+	encoding string // Выбранная для клиента кодировка ("" — клиент не поддерживает сжатие)
+	head     bool
+	logger   *zap.SugaredLogger
+
+	status      int
+	wroteHeader bool
+	state       int
+	buf         bytes.Buffer
+	encoder     encoder
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code) // Информационные ответы (1xx) — не окончательные
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+
+	h := w.Header()
+	compressible := code != http.StatusNoContent && code != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("X-Accel-Buffering") != "no" && // Backend просит отдавать ответ без буферизации
+		w.c.compressibleType(h.Get("Content-Type")) &&
+		!strings.Contains(h.Get("Cache-Control"), "no-transform")
+	if compressible {
+		// Ответ зависит от Accept-Encoding, даже если этот клиент сжатие не поддерживает
+		addVary(h, "Accept-Encoding")
+	}
+	if !compressible || w.encoding == "" || w.head {
+		w.state = statePlain
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		if cl < w.c.minSize {
+			w.state = statePlain
+			w.ResponseWriter.WriteHeader(code)
+			return
+		}
+		w.startCompression() // Размер известен и достаточен
+		return
+	}
+	w.state = stateUndecided
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.state {
+	case stateCompressed:
+		return w.encoder.Write(b)
+	case stateUndecided:
+		w.buf.Write(b)
+		if w.buf.Len() >= w.c.minSize {
+			w.startCompression()
+			if err := w.flushBuffer(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	default:
+		return w.ResponseWriter.Write(b)
+	}
+}
+
+// startCompression берёт кодировщик и отправляет заголовки сжатого ответа.
+// Если кодировщик создать не удалось, ответ уходит без сжатия.
+func (w *responseWriter) startCompression() {
+	enc, err := w.c.getEncoder(w.encoding, w.ResponseWriter)
+	if err != nil {
+		w.logger.Errorf("failed to create %s encoder: %v", w.encoding, err)
+		w.state = statePlain
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+	w.encoder = enc
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.encoding)
+	// Сжатое тело отличается побайтно: строгий ETag становится слабым
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.state = stateCompressed
+}
+
+// flushBuffer передаёт накопленное начало тела дальше в выбранном режиме
+func (w *responseWriter) flushBuffer() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.state == stateCompressed {
+		_, err = w.encoder.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// plain отказывается от сжатия и отправляет заголовки как есть
+func (w *responseWriter) plain() error {
+	w.state = statePlain
+	w.ResponseWriter.WriteHeader(w.status)
+	return w.flushBuffer()
+}
+
+// Flush передаёт клиенту уже сжатые данные. Пока решение о сжатии не принято,
+// начало тела остаётся в буфере: ReverseProxy вызывает Flush после каждой записи
+// ответов без Content-Length, и иначе они никогда бы не сжимались.
+// Потоковые ответы отсекаются по типу содержимого (StreamingTypes)
+// и заголовку X-Accel-Buffering: no.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.state {
+	case stateUndecided:
+		return
+	case stateCompressed:
+		w.encoder.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close завершает ответ: короткое тело отправляется без сжатия, кодировщик закрывается
+func (w *responseWriter) close() {
+	switch w.state {
+	case stateUndecided:
+		if w.wroteHeader {
+			if w.buf.Len() > 0 {
+				w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+			}
+			w.plain()
+		}
+	case stateCompressed:
+		if err := w.encoder.Close(); err != nil {
+			w.logger.Debugf("failed to finish %s stream: %v", w.encoding, err)
+		}
+		w.c.putEncoder(w.encoding, w.encoder)
+		w.encoder = nil
+	}
+}
+
+// Hijack нужен для WebSocket: соединение забирается без сжатия
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// addVary добавляет заголовок в Vary, если его там нет
+func addVary(h http.Header, name string) {
+	for _, line := range h.Values("Vary") {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
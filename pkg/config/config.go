@@ -5,17 +5,297 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv" 
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
     Port     int      `yaml:"port"`
-    Backends []string `yaml:"backends"`
+    Backends []string `yaml:"backends"` // Backend'ы пула "default"
+    Pools    map[string]Pool `yaml:"pools"`  // Именованные пулы backend'ов
+    Routes   []Route         `yaml:"routes"` // Таблица маршрутизации запросов в пулы
     RateLimit struct {
         Capacity   int `yaml:"capacity"`
         RefillRate int `yaml:"refill_rate"`
+        Rules      []RateLimitRule `yaml:"rules"`
+        MaxClients int             `yaml:"max_clients"` // Максимум хранимых бакетов клиентов
+        BucketTTL  time.Duration   `yaml:"bucket_ttl"`  // Время жизни неактивного бакета (5m)
+
+        SnapshotFile string `yaml:"snapshot_file"` // Файл для сохранения бакетов между перезапусками
     } `yaml:"rate_limit"`
+    Concurrency struct {
+        MaxPerClient  int `yaml:"max_per_client"`  // Максимум запросов в обработке на клиента
+        MaxPerBackend int `yaml:"max_per_backend"` // Максимум запросов в обработке на backend
+        MaxGlobal     int `yaml:"max_global"`      // Общий максимум запросов в обработке
+    } `yaml:"concurrency"`
+    AdaptiveConcurrency AdaptiveConcurrency `yaml:"adaptive_concurrency"`
+    LoadShedding        LoadShedding        `yaml:"load_shedding"`
+    Quotas              Quotas              `yaml:"quotas"`
+    Upgrade struct {
+        IdleTimeout      time.Duration `yaml:"idle_timeout"`      // Закрывать соединение без трафика дольше (10m)
+        ShutdownGrace    time.Duration `yaml:"shutdown_grace"`    // Ожидание завершения соединений при остановке (10s)
+        HandshakeTimeout time.Duration `yaml:"handshake_timeout"` // Ожидание ответа backend'а на рукопожатие (10s)
+    } `yaml:"upgrade"` // WebSocket и другие соединения с Upgrade
+    Admin struct {
+        Port int `yaml:"port"` // Порт админского API (0 — выключен)
+    } `yaml:"admin"`
+    Drain struct {
+        Grace time.Duration `yaml:"grace"` // Ожидание завершения соединений и сессий backend'а после drain, затем они закрываются (30s)
+    } `yaml:"drain"`
+    TLS TLS `yaml:"tls"`
+    HTTP2 struct {
+        H2C                  bool   `yaml:"h2c"`                    // HTTP/2 без TLS на основном листенере
+        MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams"` // Одновременных потоков на соединение (250)
+    } `yaml:"http2"`
+    TCP []TCPListener `yaml:"tcp"` // L4-прокси для протоколов не поверх HTTP
+    UDP []UDPListener `yaml:"udp"` // Балансировка UDP (DNS, syslog)
+    ProxyProtocol ProxyProtocol `yaml:"proxy_protocol"`
+    Cache struct {
+        MaxBytes          int64         `yaml:"max_bytes"`          // Общий объём кэша ответов (64 MiB)
+        MaxEntryBytes     int64         `yaml:"max_entry_bytes"`    // Ответы больше не сохраняются (1 MiB)
+        RevalidateTimeout time.Duration `yaml:"revalidate_timeout"` // Предел фонового обновления записи (30s)
+        LockTimeout       time.Duration `yaml:"lock_timeout"`       // Сколько промах ждёт ответа первого запроса по ключу, затем идёт к backend'у сам (5s)
+    } `yaml:"cache"` // Включается на маршрутах
+    Compression struct {
+        Enabled        bool     `yaml:"enabled"`
+        Encodings      []string `yaml:"encodings"`       // br, zstd, gzip в порядке предпочтения (по умолчанию все три)
+        Types          []string `yaml:"types"`           // Сжимаемые типы, "text/*" — все подтипы
+        StreamingTypes []string `yaml:"streaming_types"` // Потоковые типы: не сжимаются, каждая запись сразу уходит клиенту
+        MinSize        int      `yaml:"min_size"`        // Ответы меньше не сжимаются (1024 байта)
+    } `yaml:"compression"`
+}
+
+// ProxyProtocol — приём заголовков PROXY protocol v1/v2 на HTTP, HTTPS и TCP листенерах
+type ProxyProtocol struct {
+    Enabled      bool          `yaml:"enabled"`
+    TrustedCIDRs []string      `yaml:"trusted_cidrs"` // Адреса L4-балансировщиков; от остальных заголовок не принимается
+    Required     bool          `yaml:"required"`      // Закрывать соединения доверенных адресов без заголовка
+    Timeout      time.Duration `yaml:"timeout"`       // Ожидание заголовка (5s)
+}
+
+// UDPListener — листенер UDP: датаграммы клиента идут на один backend,
+// пока сессия не простаивает дольше session_timeout
+type UDPListener struct {
+    Name           string        `yaml:"name"`
+    Port           int           `yaml:"port"`
+    Backends       []string      `yaml:"backends"`        // Адреса host:port
+    Strategy       string        `yaml:"strategy"`        // round_robin (по умолчанию), least_connections, ip_hash
+    MaxPerBackend  int           `yaml:"max_per_backend"` // Сессий на backend (0 — без ограничения)
+    MaxSessions    int           `yaml:"max_sessions"`    // Сессий на листенер, новые клиенты сверх лимита отбрасываются (10000)
+    SessionTimeout time.Duration `yaml:"session_timeout"` // Удалять сессию без датаграмм дольше (30s)
+    RateLimit      struct {
+        Capacity   int `yaml:"capacity"`    // Датаграмм в запасе у клиента (0 — без ограничения)
+        RefillRate int `yaml:"refill_rate"` // Датаграмм в секунду
+    } `yaml:"rate_limit"` // Лимит датаграмм на IP клиента
+    HealthCheck HealthCheck `yaml:"health_check"` // protocol: tcp (TCP-соединение на тот же адрес) или none (по умолчанию)
+}
+
+// TCPListener — листенер L4-прокси: соединения распределяются между backend'ами
+// без разбора протокола (Postgres, Redis и т.п.)
+type TCPListener struct {
+    Name           string        `yaml:"name"`
+    Port           int           `yaml:"port"`
+    Backends       []string      `yaml:"backends"`        // Адреса host:port
+    Strategy       string        `yaml:"strategy"`        // round_robin (по умолчанию), least_connections, ip_hash
+    MaxPerBackend  int           `yaml:"max_per_backend"` // Одновременных соединений на backend (0 — без ограничения)
+    IdleTimeout    time.Duration `yaml:"idle_timeout"`    // Закрывать соединение без трафика дольше (10m)
+    ConnectTimeout time.Duration `yaml:"connect_timeout"` // Таймаут подключения к backend'у (5s)
+    ShutdownGrace  time.Duration `yaml:"shutdown_grace"`  // Ожидание завершения соединений при остановке (30s)
+    HealthCheck    HealthCheck   `yaml:"health_check"`    // Проверка — установка TCP-соединения; используются interval и timeout
+
+    SendProxyProtocol string `yaml:"send_proxy_protocol"` // v1 или v2 — передавать backend'ам адрес клиента
+}
+
+// TLS — HTTPS-листенер балансировщика
+type TLS struct {
+    Port           int           `yaml:"port"`            // Порт HTTPS (0 — выключен)
+    Certificates   []Certificate `yaml:"certificates"`    // Выбираются по SNI, первый — по умолчанию
+    MinVersion     string        `yaml:"min_version"`     // Минимальная версия TLS: 1.2 (по умолчанию) или 1.3
+    CipherSuites   []string      `yaml:"cipher_suites"`   // Наборы шифров для TLS 1.2 (по умолчанию — из Go)
+    ReloadInterval time.Duration `yaml:"reload_interval"` // Период проверки файлов сертификатов (30s)
+    RedirectHTTP   bool          `yaml:"redirect_http"`   // Перенаправлять HTTP-запросы на HTTPS
+    ClientAuth     ClientAuth    `yaml:"client_auth"`     // Аутентификация клиентов по сертификатам
+}
+
+// ClientAuth — проверка клиентских сертификатов на HTTPS-листенере
+type ClientAuth struct {
+    CAFile            string `yaml:"ca_file"`            // CA, которым подписаны сертификаты клиентов
+    Mode              string `yaml:"mode"`               // optional (по умолчанию) или required; маршрут может переопределить
+    SubjectHeader     string `yaml:"subject_header"`     // Заголовок с subject сертификата (X-Client-Cert-Subject)
+    FingerprintHeader string `yaml:"fingerprint_header"` // Заголовок с SHA-256 отпечатком (X-Client-Cert-Fingerprint)
+}
+
+// Certificate — сертификат (PEM, с цепочкой) и закрытый ключ
+type Certificate struct {
+    CertFile string `yaml:"cert_file"`
+    KeyFile  string `yaml:"key_file"`
+}
+
+// Pool — именованная группа backend'ов со своей стратегией балансировки и health-check
+type Pool struct {
+    Backends      []string    `yaml:"backends"`
+    Strategy      string      `yaml:"strategy"`        // round_robin (по умолчанию), least_connections, ip_hash
+    MaxPerBackend int         `yaml:"max_per_backend"` // Переопределяет concurrency.max_per_backend
+    HealthCheck   HealthCheck `yaml:"health_check"`
+    TLS           UpstreamTLS `yaml:"tls"`      // Для backend'ов с https:// URL
+    Protocol      string      `yaml:"protocol"` // http1, http2, h2c; по умолчанию HTTP/2 по ALPN для https
+}
+
+// UpstreamTLS — настройки TLS-соединений с backend'ами пула
+type UpstreamTLS struct {
+    CAFile             string `yaml:"ca_file"`              // CA для проверки backend'ов (по умолчанию — системные)
+    CertFile           string `yaml:"cert_file"`            // Клиентский сертификат для mTLS
+    KeyFile            string `yaml:"key_file"`
+    ServerName         string `yaml:"server_name"`          // Имя для SNI и проверки вместо хоста из URL
+    InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Не проверять сертификат — только для тестов!
+}
+
+// HealthCheck — настройки проверки состояния backend'ов пула
+type HealthCheck struct {
+    Path     string        `yaml:"path"`     // /health по умолчанию
+    Interval time.Duration `yaml:"interval"` // 10s по умолчанию
+    Timeout  time.Duration `yaml:"timeout"`  // 2s по умолчанию
+    Protocol string        `yaml:"protocol"` // http (по умолчанию) или grpc — стандартный grpc.health.v1
+    Service  string        `yaml:"service"`  // Сервис для grpc.health.v1 ("" — сервер целиком)
+}
+
+// Route — правило маршрутизации: все заданные условия должны совпасть.
+// Маршруты проверяются по порядку, применяется первый подходящий.
+type Route struct {
+    Name       string            `yaml:"name"`
+    Host       string            `yaml:"host"`        // Хост, допускается "*.example.com"
+    PathPrefix string            `yaml:"path_prefix"`
+    PathRegex  string            `yaml:"path_regex"`
+    Methods    []string          `yaml:"methods"`
+    Headers    map[string]string `yaml:"headers"`     // Значение "*" — заголовок просто присутствует
+    Query      map[string]string `yaml:"query"`       // Значение "*" — параметр просто присутствует
+    Pool       string            `yaml:"pool"`
+    Rewrite    Rewrite           `yaml:"rewrite"`
+
+    Split         []Split       `yaml:"split"`          // Разделение трафика между пулами по весам (вместо pool)
+    SplitOverride SplitOverride `yaml:"split_override"` // Принудительный выбор пула заголовком или cookie
+    Mirror        Mirror        `yaml:"mirror"`         // Зеркалирование копии трафика в другой пул
+    ClientCert    string        `yaml:"client_cert"`    // optional или required (по умолчанию — tls.client_auth.mode)
+    Retry         Retry         `yaml:"retry"`          // Повторы gRPC-запросов по статусу ответа
+    Cache         RouteCache    `yaml:"cache"`          // Кэширование ответов GET
+}
+
+// RouteCache — кэширование ответов маршрута по Cache-Control и Expires
+type RouteCache struct {
+    Enabled bool          `yaml:"enabled"`
+    TTL     time.Duration `yaml:"ttl"` // Время свежести вместо заданного backend'ом (0 — по заголовкам)
+}
+
+// Retry — повтор gRPC-запросов на другом backend'е пула. Повторяются только
+// ответы без данных (trailers-only) и только если тело запроса уже прочитано целиком.
+type Retry struct {
+    Attempts     int      `yaml:"attempts"`       // Дополнительных попыток (0 — без повторов)
+    GRPCCodes    []string `yaml:"grpc_codes"`     // Статусы для повтора, например UNAVAILABLE (по умолчанию — только он)
+    MaxBodyBytes int64    `yaml:"max_body_bytes"` // Запросы с телом больше лимита не повторяются (64 KiB)
+}
+
+// Mirror — отправка копий запросов маршрута в пул для тестирования на реальном трафике.
+// Ответы зеркала клиенту не отдаются, а только сравниваются с основным пулом.
+type Mirror struct {
+    Pool         string        `yaml:"pool"`
+    Percent      *float64      `yaml:"percent"`        // Доля зеркалируемых запросов, % (не задана — 100, 0 — зеркалирование выключено)
+    MaxBodyBytes int64         `yaml:"max_body_bytes"` // Запросы с телом больше лимита не зеркалируются (64 KiB)
+    Timeout      time.Duration `yaml:"timeout"`        // Таймаут запроса к зеркалу (5s)
+    MaxInflight  int           `yaml:"max_inflight"`   // Одновременных запросов к зеркалу, сверх — пропуск (100)
+}
+
+// Split — доля трафика маршрута, направляемая в пул
+type Split struct {
+    Pool   string `yaml:"pool" json:"pool"`
+    Weight int    `yaml:"weight" json:"weight"`
+}
+
+// SplitOverride — принудительный выбор пула: значение заголовка или cookie -> имя пула
+type SplitOverride struct {
+    Header string            `yaml:"header"` // Например, X-Canary
+    Cookie string            `yaml:"cookie"`
+    Values map[string]string `yaml:"values"` // Например, {always: v2, never: v1}
+}
+
+// Rewrite — правила изменения запроса к backend'у и ответа клиенту
+type Rewrite struct {
+    StripPrefix     string        `yaml:"strip_prefix"`     // Удалить префикс пути
+    AddPrefix       string        `yaml:"add_prefix"`       // Добавить префикс пути
+    PathRegex       string        `yaml:"path_regex"`       // Регулярное выражение для пути...
+    PathReplacement string        `yaml:"path_replacement"` // ...и замена ($1, $2 — группы)
+    RequestHeaders  HeaderRewrite `yaml:"request_headers"`
+    Query           HeaderRewrite `yaml:"query"`            // Те же операции для query-параметров
+    ResponseHeaders HeaderRewrite `yaml:"response_headers"` // add дописывает значение к заголовку backend'а
+    PreserveHost    bool          `yaml:"preserve_host"`    // Передавать backend'у исходный Host клиента
+}
+
+// HeaderRewrite — операции над заголовками или query-параметрами
+type HeaderRewrite struct {
+    Add    map[string]string `yaml:"add"`    // Добавить значение к существующим
+    Set    map[string]string `yaml:"set"`    // Заменить значение
+    Remove []string          `yaml:"remove"` // Удалить
+}
+
+// AdaptiveConcurrency — адаптивный лимит одновременных запросов к каждому backend'у
+type AdaptiveConcurrency struct {
+    Enabled       bool          `yaml:"enabled"`
+    InitialLimit  int           `yaml:"initial_limit"`
+    MinLimit      int           `yaml:"min_limit"`
+    MaxLimit      int           `yaml:"max_limit"`
+    Backoff       float64       `yaml:"backoff"`        // Множитель уменьшения лимита (0.9)
+    Tolerance     float64       `yaml:"tolerance"`      // Допустимый рост задержки относительно минимальной (2.0)
+    TargetLatency time.Duration `yaml:"target_latency"` // Фиксированный порог задержки вместо Tolerance
+}
+
+// LoadShedding — сброс низкоприоритетных запросов при перегрузке
+type LoadShedding struct {
+    Enabled         bool               `yaml:"enabled"`
+    Status          int                `yaml:"status"`            // HTTP-статус для сброшенных запросов (503)
+    MaxInflight     int                `yaml:"max_inflight"`      // Запросов в обработке = 100% нагрузки
+    MaxQueueLatency time.Duration      `yaml:"max_queue_latency"` // Задержка в очереди = 100% нагрузки
+    Thresholds      map[string]float64 `yaml:"thresholds"`        // Класс приоритета -> доля нагрузки для сброса
+    PriorityHeader  string             `yaml:"priority_header"`   // Заголовок с явным классом (X-Priority)
+    TrustedCIDRs    []string           `yaml:"trusted_cidrs"`     // Прокси, от которых принимается priority_header; от остальных он удаляется
+    APIKeyHeader    string             `yaml:"api_key_header"`    // Заголовок с API-ключом (X-API-Key)
+    APIKeys         map[string]string  `yaml:"api_keys"`          // API-ключ -> класс приоритета
+    Routes          []struct {
+        PathPrefix string `yaml:"path_prefix"`
+        Priority   string `yaml:"priority"`
+    } `yaml:"routes"`
+    DefaultPriority string   `yaml:"default_priority"`
+    ExemptPaths     []string `yaml:"exempt_paths"` // Никогда не сбрасываются (по умолчанию /health)
+}
+
+// Quotas — долгосрочные квоты запросов (за день/месяц), сохраняемые между перезапусками
+type Quotas struct {
+    File          string                  `yaml:"file"`           // Журнал счётчиков ("" — только в памяти)
+    FlushInterval time.Duration           `yaml:"flush_interval"` // Период сброса счётчиков на диск (5s)
+    Limits        []QuotaLimit            `yaml:"limits"`         // Квоты по умолчанию
+    Clients       map[string][]QuotaLimit `yaml:"clients"`        // Индивидуальные квоты клиентов
+}
+
+// QuotaLimit — максимум запросов за календарный период (daily или monthly)
+type QuotaLimit struct {
+    Period string `yaml:"period"`
+    Limit  int64  `yaml:"limit"`
+}
+
+// RateLimitRule — правило rate limiting со стоимостью запроса для маршрута/метода
+type RateLimitRule struct {
+    Name              string   `yaml:"name"`
+    PathPrefix        string   `yaml:"path_prefix"`
+    Methods           []string `yaml:"methods"`
+    Cost              int      `yaml:"cost"`                 // Базовая стоимость запроса в токенах
+    BodyBytesPerToken int64    `yaml:"body_bytes_per_token"` // +1 токен за каждые N байт тела
+    CostHeader        string   `yaml:"cost_header"`          // Заголовок ответа с фактической стоимостью
+    Capacity          int      `yaml:"capacity"`             // Собственный лимит правила (0 — общий)
+    RefillRate        int      `yaml:"refill_rate"`
+
+    MaxWait  time.Duration `yaml:"max_wait"`  // Ожидание токенов вместо немедленного 429
+    MaxQueue int           `yaml:"max_queue"` // Максимум ожидающих запросов на клиента
+
+    Mode string `yaml:"mode"` // enforce (по умолчанию) или shadow — только логировать отказы
 }
 
 func Load(path string) (*Config, error) {
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter — монотонно растущий счётчик
+type Counter struct {
+	bits atomic.Uint64 // float64 в виде битов, чтобы обновлять атомарно
+}
+
+// Inc увеличивает счётчик на единицу
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add увеличивает счётчик на v
+func (c *Counter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value возвращает текущее значение счётчика
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge — значение, которое может как расти, так и уменьшаться
+type Gauge struct {
+	Counter
+}
+
+// Dec уменьшает значение на единицу
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Set устанавливает значение
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// series — одна временная серия (метрика с конкретным набором меток)
+type series struct {
+	name  string
+	kind  string // counter или gauge
+	value func() float64
+}
+
+// Registry хранит все метрики процесса и отдаёт их в текстовом формате Prometheus
+type Registry struct {
+	mu     sync.Mutex
+	series map[string]*series // ключ — имя метрики вместе с метками
+	values map[string]any     // *Counter / *Gauge по тому же ключу
+}
+
+// Default — реестр по умолчанию, который отдаёт админский эндпоинт /metrics
+var Default = NewRegistry()
+
+// NewRegistry создаёт пустой реестр метрик
+func NewRegistry() *Registry {
+	return &Registry{
+		series: make(map[string]*series),
+		values: make(map[string]any),
+	}
+}
+
+// Counter возвращает счётчик с указанными метками (пары ключ, значение), создавая его при необходимости
+func (r *Registry) Counter(name string, labels ...string) *Counter {
+	key := seriesKey(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.values[key].(*Counter); ok {
+		return c
+	}
+	c := &Counter{}
+	r.values[key] = c
+	r.series[key] = &series{name: name, kind: "counter", value: c.Value}
+	return c
+}
+
+// Gauge возвращает gauge с указанными метками, создавая его при необходимости
+func (r *Registry) Gauge(name string, labels ...string) *Gauge {
+	key := seriesKey(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g, ok := r.values[key].(*Gauge); ok {
+		return g
+	}
+	g := &Gauge{}
+	r.values[key] = g
+	r.series[key] = &series{name: name, kind: "gauge", value: g.Value}
+	return g
+}
+
+// GaugeFunc регистрирует gauge, значение которого вычисляется при каждом сборе метрик
+func (r *Registry) GaugeFunc(name string, fn func() float64, labels ...string) {
+	key := seriesKey(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series[key] = &series{name: name, kind: "gauge", value: fn}
+}
+
+// WriteText выводит все метрики в текстовом формате Prometheus
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.series))
+	for k := range r.series {
+		keys = append(keys, k)
+	}
+	snapshot := make(map[string]*series, len(r.series))
+	for k, s := range r.series {
+		snapshot[k] = s
+	}
+	r.mu.Unlock()
+
+	// Серии одной метрики должны идти подряд
+	sort.Slice(keys, func(i, j int) bool {
+		if ni, nj := snapshot[keys[i]].name, snapshot[keys[j]].name; ni != nj {
+			return ni < nj
+		}
+		return keys[i] < keys[j]
+	})
+
+	typed := make(map[string]bool)
+	for _, k := range keys {
+		s := snapshot[k]
+		if !typed[s.name] {
+			typed[s.name] = true
+			if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", s.name, s.kind); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s %g\n", k, s.value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler возвращает HTTP-обработчик, отдающий метрики реестра
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	})
+}
+
+// NewCounter возвращает счётчик из реестра по умолчанию
+func NewCounter(name string, labels ...string) *Counter {
+	return Default.Counter(name, labels...)
+}
+
+// NewGauge возвращает gauge из реестра по умолчанию
+func NewGauge(name string, labels ...string) *Gauge {
+	return Default.Gauge(name, labels...)
+}
+
+// seriesKey формирует имя серии вида name{k1="v1",k2="v2"}
+func seriesKey(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", labels[i], labels[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// AdminHandler возвращает обработчик админского API
+func (lb *LoadBalancer) AdminHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", metrics.Default.Handler()) // Метрики в формате Prometheus
+    mux.HandleFunc("/quotas", lb.handleQuotas)        // Использование долгосрочных квот
+    mux.HandleFunc("/routes", lb.handleRoutes)        // Маршруты и разделение трафика
+    mux.HandleFunc("/cache", lb.handleCache)          // Заполнение и очистка кэша ответов
+    mux.HandleFunc("/backends", lb.handleBackends)    // Состояние backend'ов и вывод из ротации
+    return mux
+}
+
+// startAdmin запускает админский API на отдельном порту, недоступном клиентам балансировщика
+func (lb *LoadBalancer) startAdmin(addr string) {
+    lb.adminServer = &http.Server{
+        Addr:    addr,
+        Handler: lb.AdminHandler(),
+    }
+
+    go func() {
+        lb.logger.Infof("starting admin API on %s", addr)
+        if err := lb.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            lb.logger.Errorf("admin API failed: %v", err)
+        }
+    }()
+}
@@ -0,0 +1,326 @@
+package proxy
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/cache"
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// cacheResults — исходы обращения к кэшу, для которых заводится счётчик lb_cache_requests_total
+var cacheResults = []string{"hit", "stale", "coalesced", "revalidated", "stale_if_error", "miss"}
+
+// routeCache — настройки кэширования маршрута
+type routeCache struct {
+    policy   cache.Policy
+    maxEntry int64
+    requests map[string]*metrics.Counter // Счётчики по исходу; после создания только читаются
+}
+
+// newRouteCache включает кэширование для маршрута. Кэш общий для всех маршрутов.
+func (lb *LoadBalancer) newRouteCache(route string, rc config.RouteCache) *routeCache {
+    if lb.cache == nil {
+        maxBytes := lb.cfg.Cache.MaxBytes
+        if maxBytes <= 0 {
+            maxBytes = 64 << 20
+        }
+        lb.cache = cache.New(maxBytes)
+        lb.cacheFlight = cache.NewFlight()
+        metrics.Default.GaugeFunc("lb_cache_entries", func() float64 { return float64(lb.cache.Len()) })
+        metrics.Default.GaugeFunc("lb_cache_bytes", func() float64 { return float64(lb.cache.Size()) })
+    }
+    maxEntry := lb.cfg.Cache.MaxEntryBytes
+    if maxEntry <= 0 {
+        maxEntry = 1 << 20
+    }
+    requests := make(map[string]*metrics.Counter, len(cacheResults))
+    for _, result := range cacheResults {
+        requests[result] = metrics.NewCounter("lb_cache_requests_total", "route", route, "result", result)
+    }
+    return &routeCache{policy: cache.Policy{TTL: rc.TTL}, maxEntry: maxEntry, requests: requests}
+}
+
+// count учитывает результат обращения к кэшу
+func (c *routeCache) count(result string) {
+    c.requests[result].Inc()
+}
+
+// serveCached отвечает из кэша или проксирует запрос в пул p, сохраняя ответ
+func (lb *LoadBalancer) serveCached(w http.ResponseWriter, r *http.Request, rt *route, p *pool, clientIP string) {
+    key := cacheKey(r, p)
+    bypass := cache.Bypass(r)
+
+    if e, ok := lb.cache.Get(key, r); ok && !bypass {
+        now := time.Now()
+        switch {
+        case e.Fresh(now):
+            rt.cache.count("hit")
+            writeEntry(w, r, e, "HIT")
+            return
+        case e.StaleWhileRevalidate(now):
+            rt.cache.count("stale")
+            writeEntry(w, r, e, "STALE")
+            lb.revalidateInBackground(key, r, rt, p, clientIP)
+            return
+        }
+    }
+
+    // Одновременные промахи ждут ответа первого запроса, но не дольше lock_timeout
+    // и только если ответы по ключу сохраняются в кэш
+    var leader bool
+    var done <-chan struct{}
+    if !lb.cacheFlight.Uncacheable(key) {
+        leader, done = lb.cacheFlight.Join(key)
+    }
+    if done != nil && !bypass {
+        timeout := time.NewTimer(lb.cacheLockTimeout())
+        defer timeout.Stop()
+        select {
+        case <-done:
+        case <-timeout.C:
+            lb.logger.Debugf("cache lock timeout for %s, fetching from backend", key)
+        case <-r.Context().Done():
+            return
+        }
+        if e, ok := lb.cache.Get(key, r); ok && e.Fresh(time.Now()) {
+            rt.cache.count("coalesced")
+            writeEntry(w, r, e, "HIT")
+            return
+        }
+        // Ответ первого запроса не сохранился — обращаемся к backend'у сами
+    }
+    if leader {
+        defer lb.cacheFlight.Done(key)
+    }
+    lb.fetchAndStore(w, r, rt, p, clientIP, key)
+}
+
+// cacheLockTimeout возвращает, сколько промах ждёт ответа первого запроса по ключу
+func (lb *LoadBalancer) cacheLockTimeout() time.Duration {
+    if lb.cfg.Cache.LockTimeout > 0 {
+        return lb.cfg.Cache.LockTimeout
+    }
+    return 5 * time.Second
+}
+
+// cacheKey — ключ записи: хост и путь (см. cache.Key) и пул, который ответил.
+// При разделении трафика ответы канареечного и основного пулов хранятся отдельно.
+// Пробел не встречается в URI запроса, а очистка по префиксу "хост/путь" затрагивает все пулы.
+func cacheKey(r *http.Request, p *pool) string {
+    return cache.Key(r) + " " + p.name
+}
+
+// revalidateInBackground обновляет устаревшую запись, пока клиенту отдана старая копия.
+// Обновление ограничено по времени: пока оно идёт, промахи по ключу ждут его завершения.
+func (lb *LoadBalancer) revalidateInBackground(key string, r *http.Request, rt *route, p *pool, clientIP string) {
+    if leader, _ := lb.cacheFlight.Join(key); !leader {
+        return // Запись уже обновляется
+    }
+    timeout := lb.cfg.Cache.RevalidateTimeout
+    if timeout <= 0 {
+        timeout = 30 * time.Second
+    }
+    // Клиент может уйти раньше, чем ответит backend, поэтому контекст запроса не используется
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    bg := r.Clone(ctx)
+    go func() {
+        defer cancel()
+        defer lb.cacheFlight.Done(key)
+        lb.fetchAndStore(nil, bg, rt, p, clientIP, key)
+    }()
+}
+
+// fetchAndStore проксирует запрос и сохраняет ответ. Устаревшая запись
+// проверяется условным запросом и выдаётся при ошибке backend'а (stale-if-error).
+// w == nil — фоновое обновление без клиента.
+func (lb *LoadBalancer) fetchAndStore(w http.ResponseWriter, r *http.Request, rt *route, p *pool, clientIP, key string) {
+    stale, _ := lb.cache.Get(key, r)
+
+    out := r
+    conditional := false
+    if stale != nil && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+        if etag, lastModified := stale.Validators(); etag != "" || lastModified != "" {
+            out = r.Clone(r.Context())
+            if etag != "" {
+                out.Header.Set("If-None-Match", etag)
+            }
+            if lastModified != "" {
+                out.Header.Set("If-Modified-Since", lastModified)
+            }
+            conditional = true
+        }
+    }
+
+    cw := &captureWriter{client: w, header: make(http.Header), limit: rt.cache.maxEntry}
+    cw.intercept = func(status int) bool {
+        switch {
+        case w == nil:
+            return true
+        case conditional && status == http.StatusNotModified:
+            return true // Клиент условный запрос не отправлял: отдаём сохранённое тело
+        case stale != nil && status >= http.StatusInternalServerError && stale.StaleIfError(time.Now()):
+            return true
+        }
+        return false
+    }
+    lb.forward(cw, out, rt, p, clientIP)
+
+    switch {
+    case conditional && cw.status == http.StatusNotModified:
+        e, ok := lb.cache.Refresh(stale, r, rt.cache.policy, cw.header)
+        if !ok {
+            e = stale
+        }
+        rt.cache.count("revalidated")
+        if w != nil {
+            writeEntry(w, r, e, "REVALIDATED")
+        }
+
+    case cw.intercepted && w != nil:
+        rt.cache.count("stale_if_error")
+        lb.logger.Warnf("serving stale %s: backend responded %d", key, cw.status)
+        writeEntry(w, r, stale, "STALE")
+
+    default:
+        if w != nil {
+            rt.cache.count("miss")
+        }
+        if r.Method == http.MethodGet && cw.status < http.StatusInternalServerError {
+            var e *cache.Entry
+            stored := false
+            if !cw.overflow {
+                e, stored = lb.cache.Put(key, r, rt.cache.policy, cw.status, cw.header, cw.body.Bytes())
+            }
+            // Несвежий или несохранённый ответ ожидающим не поможет: следующие промахи не объединяются
+            lb.cacheFlight.SetCacheable(key, stored && e.Fresh(time.Now()))
+        }
+    }
+}
+
+// writeEntry отдаёт сохранённый ответ с возрастом и меткой X-Cache
+func writeEntry(w http.ResponseWriter, r *http.Request, e *cache.Entry, label string) {
+    h := w.Header()
+    for k, vs := range e.Header {
+        h[k] = append([]string(nil), vs...)
+    }
+    h.Set("Age", strconv.Itoa(int(e.Age(time.Now()).Seconds())))
+    h.Set("X-Cache", label)
+
+    if etag, _ := e.Validators(); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+        h.Del("Content-Length")
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+    h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+    w.WriteHeader(e.Status)
+    if r.Method != http.MethodHead {
+        w.Write(e.Body)
+    }
+}
+
+// etagMatches проверяет If-None-Match (слабое сравнение)
+func etagMatches(ifNoneMatch, etag string) bool {
+    if ifNoneMatch == "" {
+        return false
+    }
+    for _, candidate := range strings.Split(ifNoneMatch, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+            return true
+        }
+    }
+    return false
+}
+
+// captureWriter запоминает ответ backend'а для кэша. Ответ передаётся клиенту
+// сразу, если intercept не решит его задержать (304 на проверку, ошибка при
+// stale-if-error): тогда клиент получит сохранённую копию.
+type captureWriter struct {
+    client    http.ResponseWriter
+    header    http.Header
+    intercept func(status int) bool
+    limit     int64
+
+    status      int
+    wroteHeader bool
+    intercepted bool
+    body        bytes.Buffer
+    overflow    bool // Тело больше лимита записи — не сохраняется
+}
+
+func (cw *captureWriter) Header() http.Header {
+    return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+    if cw.wroteHeader || code < http.StatusOK {
+        return
+    }
+    cw.wroteHeader = true
+    cw.status = code
+    if cw.intercept(code) {
+        cw.intercepted = true
+        return
+    }
+    h := cw.client.Header()
+    for k, vs := range cw.header {
+        h[k] = vs
+    }
+    h.Set("X-Cache", "MISS")
+    cw.client.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    if !cw.overflow {
+        if int64(cw.body.Len()+len(b)) > cw.limit {
+            cw.overflow = true
+            cw.body = bytes.Buffer{}
+        } else {
+            cw.body.Write(b)
+        }
+    }
+    if cw.intercepted {
+        return len(b), nil
+    }
+    return cw.client.Write(b)
+}
+
+func (cw *captureWriter) Flush() {
+    if cw.wroteHeader && !cw.intercepted {
+        http.NewResponseController(cw.client).Flush()
+    }
+}
+
+// handleCache показывает заполнение кэша (GET) и удаляет записи по префиксу
+// "хост/путь" (POST или DELETE, параметр prefix; без него — весь кэш)
+func (lb *LoadBalancer) handleCache(w http.ResponseWriter, r *http.Request) {
+    if lb.cache == nil {
+        writeJSONError(w, http.StatusNotFound, "cache is not configured")
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]int64{"entries": int64(lb.cache.Len()), "bytes": lb.cache.Size()})
+
+    case http.MethodPost, http.MethodDelete:
+        prefix := r.URL.Query().Get("prefix")
+        n := lb.cache.Purge(prefix)
+        lb.logger.Infof("purged %d cache entries with prefix %q", n, prefix)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]int{"purged": n})
+
+    default:
+        writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
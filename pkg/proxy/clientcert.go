@@ -0,0 +1,84 @@
+package proxy
+
+import (
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+)
+
+// Режимы проверки клиентского сертификата для маршрута
+const (
+    clientCertOptional = "optional" // Сертификат учитывается, если клиент его предъявил
+    clientCertRequired = "required" // Без проверенного сертификата запрос отклоняется
+)
+
+// clientAuthEnabled сообщает, что HTTPS-листенер проверяет клиентские сертификаты
+func (lb *LoadBalancer) clientAuthEnabled() bool {
+    return lb.cfg.TLS.ClientAuth.CAFile != ""
+}
+
+// clientCertMode возвращает режим проверки сертификата для маршрута
+func (lb *LoadBalancer) clientCertMode(routeMode string) (string, error) {
+    mode := routeMode
+    if mode == "" {
+        mode = lb.cfg.TLS.ClientAuth.Mode
+    }
+    switch mode {
+    case "", clientCertOptional:
+        return clientCertOptional, nil
+    case clientCertRequired:
+        if !lb.clientAuthEnabled() {
+            return "", fmt.Errorf("client_cert: required needs tls.client_auth.ca_file")
+        }
+        return clientCertRequired, nil
+    default:
+        return "", fmt.Errorf("unknown client_cert mode %q", mode)
+    }
+}
+
+// verifiedClientCert возвращает проверенный сертификат клиента или nil.
+// Листенер требует сертификат только "если предъявлен", поэтому непроверенный
+// сертификат до обработчика не доходит, а его отсутствие решается по маршруту.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+    if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+        return nil
+    }
+    return r.TLS.VerifiedChains[0][0]
+}
+
+// certFingerprint возвращает SHA-256 отпечаток сертификата в hex
+func certFingerprint(cert *x509.Certificate) string {
+    sum := sha256.Sum256(cert.Raw)
+    return hex.EncodeToString(sum[:])
+}
+
+// clientKey — ключ клиента для rate limiting: идентичность проверенного
+// сертификата, если он есть, иначе IP клиента
+func (lb *LoadBalancer) clientKey(r *http.Request) string {
+    if cert := verifiedClientCert(r); cert != nil {
+        return "cert:" + cert.Subject.String()
+    }
+    return extractClientIP(r)
+}
+
+// forwardClientCert передаёт backend'у данные проверенного сертификата в заголовках.
+// Одноимённые заголовки от клиента удаляются, чтобы их нельзя было подделать.
+func (lb *LoadBalancer) forwardClientCert(r *http.Request) {
+    subjectHeader := lb.cfg.TLS.ClientAuth.SubjectHeader
+    if subjectHeader == "" {
+        subjectHeader = "X-Client-Cert-Subject"
+    }
+    fingerprintHeader := lb.cfg.TLS.ClientAuth.FingerprintHeader
+    if fingerprintHeader == "" {
+        fingerprintHeader = "X-Client-Cert-Fingerprint"
+    }
+
+    r.Header.Del(subjectHeader)
+    r.Header.Del(fingerprintHeader)
+    if cert := verifiedClientCert(r); cert != nil {
+        r.Header.Set(subjectHeader, cert.Subject.String())
+        r.Header.Set(fingerprintHeader, certFingerprint(cert))
+    }
+}
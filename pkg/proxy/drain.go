@@ -0,0 +1,203 @@
+package proxy
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+)
+
+// drainPollInterval — как часто проверяется завершение запросов при GET /backends?wait=
+const drainPollInterval = 50 * time.Millisecond
+
+// defaultDrainGrace — сколько долгоживущие соединения backend'а могут завершаться сами после drain
+const defaultDrainGrace = 30 * time.Second
+
+// backendInfo — состояние backend'а в админском API
+type backendInfo struct {
+    Pool     string `json:"pool"`
+    Backend  string `json:"backend"`
+    Alive    bool   `json:"alive"`
+    Draining bool   `json:"draining"`
+    Active   int64  `json:"active_requests"`
+    Drained  bool   `json:"drained"` // Выведен из ротации и не обрабатывает ни одного запроса
+}
+
+// poolBackend — backend вместе с именем пула (или TCP/UDP-листенера), которому он принадлежит
+type poolBackend struct {
+    pool    string
+    backend *balancer.Backend
+    // closeDraining закрывает долгоживущие соединения backend'а через grace, если он
+    // всё ещё выводится из ротации (nil — таких соединений у источника нет)
+    closeDraining func(b *balancer.Backend, grace time.Duration)
+}
+
+// info возвращает текущее состояние backend'а
+func (pb poolBackend) info() backendInfo {
+    b := pb.backend
+    active := b.ActiveRequests()
+    return backendInfo{
+        Pool:     pb.pool,
+        Backend:  b.URL.String(),
+        Alive:    b.Alive.Load(),
+        Draining: b.Draining(),
+        Active:   active,
+        Drained:  b.Draining() && active == 0,
+    }
+}
+
+// matchBackends находит backend'ы HTTP-пулов и TCP/UDP-листенеров. Пустые poolName и addr
+// означают "любой"; addr сравнивается с адресом backend'а целиком или с host:port.
+// Один и тот же сервер может входить в несколько пулов — возвращаются все вхождения.
+func (lb *LoadBalancer) matchBackends(poolName, addr string) []poolBackend {
+    names := make([]string, 0, len(lb.pools))
+    for name := range lb.pools {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    type source struct {
+        name          string
+        b             balancer.Balancer
+        closeDraining func(*balancer.Backend, time.Duration)
+    }
+    sources := make([]source, 0, len(names)+len(lb.tcp)+len(lb.udp))
+    for _, name := range names {
+        sources = append(sources, source{name, lb.pools[name].balancer, func(b *balancer.Backend, grace time.Duration) {
+            lb.upgrades.closeAfter(b.URL, grace)
+        }})
+    }
+    for _, l := range lb.tcp {
+        sources = append(sources, source{l.cfg.Name, l.proxy.Balancer(), l.proxy.CloseDraining})
+    }
+    for _, l := range lb.udp {
+        sources = append(sources, source{l.cfg.Name, l.proxy.Balancer(), l.proxy.CloseDraining})
+    }
+
+    var matched []poolBackend
+    for _, src := range sources {
+        if poolName != "" && src.name != poolName {
+            continue
+        }
+        for _, b := range src.b.Backends() {
+            if addr != "" && b.URL.String() != addr && b.URL.Host != addr {
+                continue
+            }
+            matched = append(matched, poolBackend{pool: src.name, backend: b, closeDraining: src.closeDraining})
+        }
+    }
+    return matched
+}
+
+// handleBackends отдаёт состояние backend'ов (GET /backends?pool=&backend=) и выводит их
+// из ротации или возвращает в неё (POST /backends?backend=host:port&drain=true|false).
+// Долгоживущие соединения и сессии backend'а закрываются через grace (drain.grace или
+// параметр grace), если он к тому времени не вернулся в ротацию.
+// С параметром wait (например, wait=30s) GET ждёт, пока выбранные backend'ы в режиме
+// drain не завершат все запросы, — так скрипты деплоя дожидаются безопасной остановки.
+func (lb *LoadBalancer) handleBackends(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    matched := lb.matchBackends(query.Get("pool"), query.Get("backend"))
+
+    switch r.Method {
+    case http.MethodGet:
+        if raw := query.Get("wait"); raw != "" {
+            wait, err := time.ParseDuration(raw)
+            if err != nil {
+                writeJSONError(w, http.StatusBadRequest, "invalid wait: "+err.Error())
+                return
+            }
+            waitDrained(r, matched, wait)
+        }
+
+    case http.MethodPut, http.MethodPost:
+        if query.Get("backend") == "" {
+            writeJSONError(w, http.StatusBadRequest, "backend is required")
+            return
+        }
+        drain := true
+        if raw := query.Get("drain"); raw != "" {
+            var err error
+            if drain, err = strconv.ParseBool(raw); err != nil {
+                writeJSONError(w, http.StatusBadRequest, "invalid drain: "+err.Error())
+                return
+            }
+        }
+        grace := lb.drainGrace()
+        if raw := query.Get("grace"); raw != "" {
+            var err error
+            if grace, err = time.ParseDuration(raw); err != nil || grace < 0 {
+                writeJSONError(w, http.StatusBadRequest, "invalid grace: "+raw)
+                return
+            }
+        }
+        if len(matched) == 0 {
+            writeJSONError(w, http.StatusNotFound, fmt.Sprintf("backend %q not found", query.Get("backend")))
+            return
+        }
+        for _, pb := range matched {
+            pb.backend.SetDraining(drain)
+            if drain {
+                lb.logger.Infof("draining backend %s in pool %s (%d active requests)", pb.backend.URL, pb.pool, pb.backend.ActiveRequests())
+                if pb.closeDraining != nil {
+                    go pb.closeDraining(pb.backend, grace)
+                }
+            } else {
+                lb.logger.Infof("backend %s in pool %s returned to rotation", pb.backend.URL, pb.pool)
+            }
+        }
+
+    default:
+        writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+
+    infos := make([]backendInfo, 0, len(matched))
+    for _, pb := range matched {
+        infos = append(infos, pb.info())
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(infos)
+}
+
+// drainGrace возвращает, сколько ждать завершения соединений backend'а после drain
+func (lb *LoadBalancer) drainGrace() time.Duration {
+    if lb.cfg.Drain.Grace > 0 {
+        return lb.cfg.Drain.Grace
+    }
+    return defaultDrainGrace
+}
+
+// waitDrained ждёт, пока у всех backend'ов в режиме drain не останется запросов,
+// но не дольше wait и не дольше, чем клиент готов ждать ответа
+func waitDrained(r *http.Request, backends []poolBackend, wait time.Duration) {
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    ticker := time.NewTicker(drainPollInterval)
+    defer ticker.Stop()
+
+    for {
+        pending := false
+        for _, pb := range backends {
+            if pb.backend.Draining() && pb.backend.ActiveRequests() > 0 {
+                pending = true
+                break
+            }
+        }
+        if !pending {
+            return
+        }
+
+        select {
+        case <-r.Context().Done():
+            return
+        case <-timer.C:
+            return
+        case <-ticker.C:
+        }
+    }
+}
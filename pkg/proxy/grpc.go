@@ -0,0 +1,361 @@
+package proxy
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+    "go.uber.org/zap"
+)
+
+// Коды статусов gRPC (google.golang.org/grpc/codes)
+var grpcCodes = map[string]int{
+    "OK":                  0,
+    "CANCELLED":           1,
+    "UNKNOWN":             2,
+    "INVALID_ARGUMENT":    3,
+    "DEADLINE_EXCEEDED":   4,
+    "NOT_FOUND":           5,
+    "ALREADY_EXISTS":      6,
+    "PERMISSION_DENIED":   7,
+    "RESOURCE_EXHAUSTED":  8,
+    "FAILED_PRECONDITION": 9,
+    "ABORTED":             10,
+    "OUT_OF_RANGE":        11,
+    "UNIMPLEMENTED":       12,
+    "INTERNAL":            13,
+    "UNAVAILABLE":         14,
+    "DATA_LOSS":           15,
+    "UNAUTHENTICATED":     16,
+}
+
+const grpcUnavailable = 14
+
+// isGRPC сообщает, что запрос или ответ относится к gRPC
+func isGRPC(contentType string) bool {
+    return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") ||
+        strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// grpcStatusFromHTTP переводит HTTP-код в статус gRPC по таблице из спецификации
+// gRPC. 429 становится RESOURCE_EXHAUSTED, а не UNAVAILABLE, чтобы клиенты
+// не повторяли сразу запросы, отклонённые лимитами.
+func grpcStatusFromHTTP(code int) int {
+    switch code {
+    case http.StatusBadRequest, http.StatusInternalServerError:
+        return grpcCodes["INTERNAL"]
+    case http.StatusUnauthorized:
+        return grpcCodes["UNAUTHENTICATED"]
+    case http.StatusForbidden:
+        return grpcCodes["PERMISSION_DENIED"]
+    case http.StatusNotFound:
+        return grpcCodes["UNIMPLEMENTED"]
+    case http.StatusTooManyRequests, http.StatusRequestEntityTooLarge:
+        return grpcCodes["RESOURCE_EXHAUSTED"]
+    case http.StatusBadGateway, http.StatusServiceUnavailable:
+        return grpcUnavailable
+    case http.StatusGatewayTimeout:
+        return grpcCodes["DEADLINE_EXCEEDED"]
+    default:
+        return grpcCodes["UNKNOWN"]
+    }
+}
+
+// encodeGRPCMessage кодирует grpc-message: непечатные символы и '%' — как %XX
+func encodeGRPCMessage(msg string) string {
+    var b strings.Builder
+    for i := 0; i < len(msg); i++ {
+        c := msg[i]
+        if c < ' ' || c > '~' || c == '%' {
+            fmt.Fprintf(&b, "%%%02X", c)
+            continue
+        }
+        b.WriteByte(c)
+    }
+    return b.String()
+}
+
+// grpcErrors отдаёт gRPC-клиентам ошибки балансировщика (и не-gRPC ответы
+// backend'ов с кодом >= 400) как статусы gRPC вместо JSON: HTTP 200 с
+// grpc-status и grpc-message в заголовках (ответ trailers-only).
+func grpcErrors(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !isGRPC(r.Header.Get("Content-Type")) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        gw := &grpcErrorWriter{ResponseWriter: w}
+        next.ServeHTTP(gw, r)
+        gw.finish()
+    })
+}
+
+// grpcErrorWriter перехватывает ответы об ошибках, чтобы перевести их в статус gRPC
+type grpcErrorWriter struct {
+    http.ResponseWriter
+    wroteHeader bool
+    code        int          // HTTP-код перехваченной ошибки (0 — ответ передаётся как есть)
+    body        bytes.Buffer // Начало тела ошибки для grpc-message
+}
+
+func (w *grpcErrorWriter) WriteHeader(code int) {
+    if w.wroteHeader {
+        return
+    }
+    if code >= 100 && code < 200 {
+        w.ResponseWriter.WriteHeader(code) // Информационные ответы не окончательны
+        return
+    }
+    w.wroteHeader = true
+    if code >= http.StatusBadRequest && !isGRPC(w.Header().Get("Content-Type")) {
+        w.code = code
+        return
+    }
+    w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *grpcErrorWriter) Write(b []byte) (int, error) {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    if w.code != 0 {
+        if w.body.Len() < 1024 {
+            w.body.Write(b)
+        }
+        return len(b), nil
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+// Flush передаётся дальше только для проксируемых gRPC-ответов:
+// перехваченная ошибка отправляется целиком в finish
+func (w *grpcErrorWriter) Flush() {
+    if w.code == 0 {
+        http.NewResponseController(w.ResponseWriter).Flush()
+    }
+}
+
+func (w *grpcErrorWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// finish отправляет перехваченную ошибку как ответ gRPC
+func (w *grpcErrorWriter) finish() {
+    if w.code == 0 {
+        return
+    }
+    msg := http.StatusText(w.code)
+    var er errorResponse
+    if err := json.Unmarshal(w.body.Bytes(), &er); err == nil && er.Message != "" {
+        msg = er.Message
+    } else if text := strings.TrimSpace(w.body.String()); text != "" {
+        msg = text
+    }
+
+    h := w.Header()
+    h.Del("Content-Length")
+    h.Del("Content-Encoding")
+    h.Set("Content-Type", "application/grpc")
+    h.Set("Grpc-Status", strconv.Itoa(grpcStatusFromHTTP(w.code)))
+    h.Set("Grpc-Message", encodeGRPCMessage(msg))
+    w.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+// grpcRetry — скомпилированная политика повторов gRPC-запросов маршрута
+type grpcRetry struct {
+    attempts int
+    codes    map[string]bool // Значения grpc-status для повтора
+    maxBody  int64
+    counters map[string]*metrics.Counter // lb_grpc_retries_total по статусам
+}
+
+// newGRPCRetry проверяет политику повторов маршрута
+func newGRPCRetry(route string, rc config.Retry) (*grpcRetry, error) {
+    if rc.Attempts < 0 {
+        return nil, fmt.Errorf("retry.attempts must not be negative")
+    }
+    names := rc.GRPCCodes
+    if len(names) == 0 {
+        names = []string{"UNAVAILABLE"}
+    }
+    r := &grpcRetry{
+        attempts: rc.Attempts,
+        codes:    make(map[string]bool, len(names)),
+        maxBody:  rc.MaxBodyBytes,
+        counters: make(map[string]*metrics.Counter, len(names)),
+    }
+    if r.maxBody <= 0 {
+        r.maxBody = 64 << 10
+    }
+    for _, name := range names {
+        code, ok := grpcCodes[strings.ToUpper(name)]
+        if !ok || code == 0 {
+            return nil, fmt.Errorf("unknown retry grpc code %q", name)
+        }
+        status := strconv.Itoa(code)
+        r.codes[status] = true
+        r.counters[status] = metrics.NewCounter("lb_grpc_retries_total", "route", route, "code", strings.ToUpper(name))
+    }
+    return r, nil
+}
+
+// retryTransport повторяет gRPC-запрос на следующем, ещё не опробованном backend'е пула, если
+// ответ без данных содержит статус из политики. Транспортные ошибки
+// считаются UNAVAILABLE. Если повтор был, задержку и ошибки каждой попытки учитывает
+// сам транспорт — на backend'е, который её обслужил, а не на первом.
+type retryTransport struct {
+    base     http.RoundTripper
+    pool     *pool
+    primary  *balancer.Backend // Backend первой попытки (его слот освобождает handle)
+    retry    *grpcRetry
+    clientID string
+    logger   *zap.SugaredLogger
+    retried  bool // Запрос повторялся: ModifyResponse и ErrorHandler не учитывают его на primary
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    var body *replayBody
+    if req.Body != nil && req.Body != http.NoBody {
+        body = &replayBody{src: req.Body, limit: t.retry.maxBody}
+        req.Body = body
+    }
+
+    start := time.Now()
+    resp, err := t.base.RoundTrip(req)
+    host := req.URL.Host
+    current := t.primary
+    tried := []*balancer.Backend{t.primary}
+    for attempt := 0; attempt < t.retry.attempts; attempt++ {
+        status := retryStatus(resp, err)
+        if !t.retry.codes[status] || (body != nil && !body.replayable()) {
+            break
+        }
+        backend, nerr := t.pool.balancer.NextBackendExcept(t.clientID, tried)
+        if nerr != nil {
+            break
+        }
+        t.retried = true
+        t.observe(current, start, resp, err)
+        tried = append(tried, backend)
+        if resp != nil {
+            resp.Body.Close()
+        }
+        t.retry.counters[status].Inc()
+        t.logger.Warnf("retrying grpc %s on %s (status %s, attempt %d)", req.URL.Path, backend.URL, status, attempt+1)
+
+        retryReq := req.Clone(req.Context())
+        retryReq.URL.Scheme = backend.URL.Scheme
+        retryReq.URL.Host = backend.URL.Host
+        if req.Host == host {
+            retryReq.Host = backend.URL.Host // Host не сохраняется от клиента (preserve_host выключен)
+        }
+        if body != nil {
+            retryReq.Body = body.replay()
+        }
+
+        current = backend
+        start = time.Now()
+        resp, err = t.base.RoundTrip(retryReq)
+        if err != nil {
+            backend.Release()
+            continue
+        }
+        resp.Body = &releaseOnClose{ReadCloser: resp.Body, backend: backend}
+    }
+    if t.retried {
+        t.observe(current, start, resp, err)
+    }
+    return resp, err
+}
+
+// observe учитывает попытку на обслужившем её backend'е, как ModifyResponse и
+// ErrorHandler при обычном проксировании
+func (t *retryTransport) observe(b *balancer.Backend, start time.Time, resp *http.Response, err error) {
+    b.ObserveLatency(time.Since(start), err != nil || resp.StatusCode >= http.StatusInternalServerError)
+    if err != nil {
+        t.logger.Errorf("proxy error for backend %s: %v", b.URL, err)
+        t.pool.balancer.MarkBackendDead(b.URL)
+    }
+}
+
+// retryStatus возвращает grpc-status ответа, пригодного для повтора, или ""
+func retryStatus(resp *http.Response, err error) string {
+    if err != nil {
+        return strconv.Itoa(grpcUnavailable)
+    }
+    if status := resp.Header.Get("Grpc-Status"); status != "" {
+        return status // Trailers-only: данных клиенту ещё не отправлено
+    }
+    if resp.StatusCode != http.StatusOK {
+        return strconv.Itoa(grpcStatusFromHTTP(resp.StatusCode))
+    }
+    return ""
+}
+
+// replayBody запоминает прочитанное тело запроса, чтобы отправить его повторно
+type replayBody struct {
+    src   io.ReadCloser
+    limit int64
+
+    mu       sync.Mutex // Тело читает транспорт в своей горутине
+    buf      bytes.Buffer
+    eof      bool
+    overflow bool
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+    n, err := b.src.Read(p)
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if n > 0 {
+        if int64(b.buf.Len()+n) > b.limit {
+            b.overflow = true
+        } else if !b.overflow {
+            b.buf.Write(p[:n])
+        }
+    }
+    if err == io.EOF {
+        b.eof = true
+    }
+    return n, err
+}
+
+func (b *replayBody) Close() error {
+    return b.src.Close()
+}
+
+// replayable сообщает, что тело прочитано целиком и уместилось в лимит
+func (b *replayBody) replayable() bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.eof && !b.overflow
+}
+
+// replay возвращает копию тела для повторного запроса
+func (b *replayBody) replay() io.ReadCloser {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return io.NopCloser(bytes.NewReader(b.buf.Bytes()))
+}
+
+// releaseOnClose освобождает слот backend'а повторного запроса после ответа
+type releaseOnClose struct {
+    io.ReadCloser
+    backend *balancer.Backend
+    once    sync.Once
+}
+
+func (r *releaseOnClose) Close() error {
+    err := r.ReadCloser.Close()
+    r.once.Do(r.backend.Release)
+    return err
+}
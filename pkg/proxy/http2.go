@@ -0,0 +1,62 @@
+package proxy
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/http"
+
+    "golang.org/x/net/http2"
+)
+
+// Протоколы соединений с backend'ами пула
+const (
+    protocolAuto  = ""      // HTTP/2 по ALPN для https, иначе HTTP/1.1
+    protocolHTTP1 = "http1" // Только HTTP/1.1
+    protocolHTTP2 = "http2" // Только HTTP/2 поверх TLS
+    protocolH2C   = "h2c"   // HTTP/2 без TLS с "предварительным знанием" (prior knowledge)
+)
+
+// newTransport создаёт транспорт запросов к backend'ам пула для указанного протокола
+func newTransport(protocol string, tlsConfig *tls.Config) (http.RoundTripper, error) {
+    switch protocol {
+    case protocolAuto:
+        t := http.DefaultTransport.(*http.Transport).Clone()
+        t.TLSClientConfig = tlsConfig
+        return t, nil
+
+    case protocolHTTP1:
+        t := http.DefaultTransport.(*http.Transport).Clone()
+        t.TLSClientConfig = tlsConfig
+        t.ForceAttemptHTTP2 = false
+        t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper) // Отключает h2
+        return t, nil
+
+    case protocolHTTP2:
+        return &http2.Transport{TLSClientConfig: tlsConfig}, nil
+
+    case protocolH2C:
+        // Для http:// http2.Transport всё равно вызывает DialTLSContext,
+        // поэтому подменяем его обычным TCP-соединением
+        return &http2.Transport{
+            AllowHTTP: true,
+            DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+                var d net.Dialer
+                return d.DialContext(ctx, network, addr)
+            },
+        }, nil
+
+    default:
+        return nil, fmt.Errorf("unknown protocol %q", protocol)
+    }
+}
+
+// http2Server возвращает настройки HTTP/2 для листенеров балансировщика
+func (lb *LoadBalancer) http2Server() *http2.Server {
+    streams := lb.cfg.HTTP2.MaxConcurrentStreams
+    if streams == 0 {
+        streams = 250
+    }
+    return &http2.Server{MaxConcurrentStreams: streams}
+}
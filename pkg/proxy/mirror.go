@@ -0,0 +1,187 @@
+package proxy
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "net/http/httputil"
+    "strconv"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+    "go.uber.org/zap"
+)
+
+// mirror — зеркалирование копий запросов маршрута в отдельный пул.
+// Запросы к зеркалу отправляются в фоне и не влияют на ответ клиенту.
+type mirror struct {
+    pool    *pool
+    percent float64
+    maxBody int64
+    timeout time.Duration
+    slots   chan struct{} // Ограничение одновременных запросов к зеркалу
+    logger  *zap.SugaredLogger
+}
+
+// primaryResult — итог основного запроса, с которым сравнивается ответ зеркала
+type primaryResult struct {
+    status  int
+    latency time.Duration
+}
+
+// newMirror проверяет настройки зеркала и подставляет значения по умолчанию
+func (lb *LoadBalancer) newMirror(mc config.Mirror) (*mirror, error) {
+    p, ok := lb.pools[mc.Pool]
+    if !ok {
+        return nil, fmt.Errorf("unknown pool %q in mirror", mc.Pool)
+    }
+    percent := 100.0
+    if mc.Percent != nil {
+        percent = *mc.Percent // 0 — зеркалирование выключено
+    }
+    if percent < 0 || percent > 100 {
+        return nil, fmt.Errorf("mirror percent must be between 0 and 100")
+    }
+
+    m := &mirror{
+        pool:    p,
+        percent: percent,
+        maxBody: mc.MaxBodyBytes,
+        timeout: mc.Timeout,
+        logger:  lb.logger,
+    }
+    if m.maxBody <= 0 {
+        m.maxBody = 64 << 10
+    }
+    if m.timeout <= 0 {
+        m.timeout = 5 * time.Second
+    }
+    maxInflight := mc.MaxInflight
+    if maxInflight <= 0 {
+        maxInflight = 100
+    }
+    m.slots = make(chan struct{}, maxInflight)
+    return m, nil
+}
+
+// start решает, зеркалировать ли запрос, и если да — отправляет его копию в фоне.
+// Тело запроса буферизуется и подставляется обратно, чтобы основной backend
+// получил его целиком. Возвращает канал, в который обработчик должен передать
+// итог основного запроса, или nil, если запрос не зеркалируется.
+func (m *mirror) start(rt *route, r *http.Request, clientID string) chan<- primaryResult {
+    if m.percent < 100 && rand.Float64()*100 >= m.percent {
+        return nil
+    }
+
+    var body []byte
+    if r.Body != nil && r.Body != http.NoBody {
+        if r.ContentLength > m.maxBody {
+            m.skip(rt, "body_too_large")
+            return nil
+        }
+        buf, err := io.ReadAll(io.LimitReader(r.Body, m.maxBody+1))
+        r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+        if err != nil {
+            m.skip(rt, "error")
+            return nil
+        }
+        if int64(len(buf)) > m.maxBody {
+            m.skip(rt, "body_too_large")
+            return nil
+        }
+        body = buf
+    }
+
+    select {
+    case m.slots <- struct{}{}:
+    default:
+        m.skip(rt, "dropped") // Зеркало не успевает — не копим горутины
+        return nil
+    }
+
+    // Копия запроса не зависит от контекста клиента: зеркало дорабатывает
+    // даже после того, как клиент получил ответ и отключился
+    ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+    req := r.Clone(ctx)
+    if body != nil {
+        req.Body = io.NopCloser(bytes.NewReader(body))
+        req.ContentLength = int64(len(body))
+    }
+
+    primary := make(chan primaryResult, 1)
+    go func() {
+        defer func() { <-m.slots }()
+        defer cancel()
+        m.send(rt, req, clientID, primary)
+    }()
+    return primary
+}
+
+// send выполняет запрос к зеркалу, отбрасывает ответ и сравнивает его с основным
+func (m *mirror) send(rt *route, req *http.Request, clientID string, primary <-chan primaryResult) {
+    backend, err := m.pool.balancer.NextBackend(clientID)
+    if err != nil {
+        m.skip(rt, "no_backend")
+        return
+    }
+    defer backend.Release()
+
+    director := httputil.NewSingleHostReverseProxy(backend.URL).Director
+    rt.rewrite.rewritePath(req)
+    director(req)
+    rt.rewrite.rewriteRequest(req)
+    if !rt.rewrite.preserveHost {
+        req.Host = backend.URL.Host
+    }
+    req.RequestURI = ""
+
+    status := "error"
+    start := time.Now()
+    resp, err := m.pool.transport.RoundTrip(req)
+    if err == nil {
+        io.Copy(io.Discard, resp.Body)
+        resp.Body.Close()
+        status = strconv.Itoa(resp.StatusCode)
+    }
+    latency := time.Since(start)
+
+    backend.ObserveLatency(latency, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+    if err != nil {
+        m.logger.Warnf("mirror request to %s failed: %v", backend.URL, err)
+        m.pool.balancer.MarkBackendDead(backend.URL)
+    }
+    metrics.NewCounter("lb_mirror_requests_total", "route", rt.name, "result", "sent").Inc()
+
+    // Ждём завершения основного запроса, чтобы сравнить ответы
+    p := <-primary
+    match := status == strconv.Itoa(p.status)
+    metrics.NewCounter("lb_mirror_compared_total", "route", rt.name, "match", strconv.FormatBool(match)).Inc()
+    metrics.NewCounter("lb_mirror_latency_seconds_sum", "route", rt.name, "target", "primary").Add(p.latency.Seconds())
+    metrics.NewCounter("lb_mirror_latency_seconds_sum", "route", rt.name, "target", "mirror").Add(latency.Seconds())
+
+    m.logger.Infow("Mirrored request",
+        "route", rt.name,
+        "method", req.Method,
+        "path", req.URL.Path,
+        "mirror_status", status,
+        "primary_status", p.status,
+        "mirror_latency", latency,
+        "primary_latency", p.latency,
+        "match", match,
+    )
+}
+
+// skip учитывает запрос, который не удалось зеркалировать
+func (m *mirror) skip(rt *route, reason string) {
+    metrics.NewCounter("lb_mirror_requests_total", "route", rt.name, "result", reason).Inc()
+}
+
+// readCloser соединяет буферизованное начало тела с его непрочитанным остатком
+type readCloser struct {
+    io.Reader
+    io.Closer
+}
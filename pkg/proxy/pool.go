@@ -0,0 +1,133 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "fmt"
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/certs"
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// defaultPool — пул, собранный из верхнеуровневого списка backends
+const defaultPool = "default"
+
+// pool — именованная группа backend'ов со своей стратегией балансировки
+type pool struct {
+    name      string
+    balancer  balancer.Balancer
+    tlsConfig *tls.Config     // TLS-настройки соединений с backend'ами (nil — по умолчанию)
+    transport http.RoundTripper // Транспорт запросов к backend'ам пула (HTTP/1.1 или HTTP/2)
+}
+
+// newPool создаёт пул по конфигурации и применяет к нему общие лимиты параллелизма
+func (lb *LoadBalancer) newPool(name string, pc config.Pool) (*pool, error) {
+    var tlsConfig *tls.Config
+    if t := pc.TLS; t != (config.UpstreamTLS{}) {
+        var err error
+        tlsConfig, err = certs.ClientConfig(certs.ClientOptions{
+            CAFile:             t.CAFile,
+            CertFile:           t.CertFile,
+            KeyFile:            t.KeyFile,
+            ServerName:         t.ServerName,
+            InsecureSkipVerify: t.InsecureSkipVerify,
+        })
+        if err != nil {
+            return nil, err
+        }
+        if t.InsecureSkipVerify {
+            lb.logger.Warnf("pool %s: TLS certificate verification of backends is disabled", name)
+        }
+    }
+    transport, err := newTransport(pc.Protocol, tlsConfig)
+    if err != nil {
+        return nil, err
+    }
+    switch pc.HealthCheck.Protocol {
+    case "", "http":
+    case "grpc":
+        // gRPC работает только поверх HTTP/2
+        if pc.Protocol != protocolH2C && pc.Protocol != protocolHTTP2 {
+            return nil, fmt.Errorf("health_check protocol grpc requires pool protocol %s or %s", protocolH2C, protocolHTTP2)
+        }
+    default:
+        return nil, fmt.Errorf("unknown health_check protocol %q", pc.HealthCheck.Protocol)
+    }
+
+    b, err := balancer.New(pc.Strategy, pc.Backends, balancer.HealthCheck{
+        Path:      pc.HealthCheck.Path,
+        Interval:  pc.HealthCheck.Interval,
+        Timeout:   pc.HealthCheck.Timeout,
+        Transport: transport, // Проверки идут с теми же TLS-настройками, что и запросы
+        GRPC:      pc.HealthCheck.Protocol == "grpc",
+        Service:   pc.HealthCheck.Service,
+    }, lb.logger.With("pool", name))
+    if err != nil {
+        return nil, err
+    }
+
+    maxPerBackend := lb.cfg.Concurrency.MaxPerBackend
+    if pc.MaxPerBackend > 0 {
+        maxPerBackend = pc.MaxPerBackend
+    }
+    b.SetMaxConnsPerBackend(maxPerBackend)
+
+    if ac := lb.cfg.AdaptiveConcurrency; ac.Enabled {
+        b.EnableAdaptiveConcurrency(balancer.AdaptiveOptions{
+            InitialLimit:  ac.InitialLimit,
+            MinLimit:      ac.MinLimit,
+            MaxLimit:      ac.MaxLimit,
+            Backoff:       ac.Backoff,
+            Tolerance:     ac.Tolerance,
+            TargetLatency: ac.TargetLatency,
+        })
+    }
+
+    for _, be := range b.Backends() {
+        be := be
+        metrics.Default.GaugeFunc("lb_backend_inflight_requests", func() float64 {
+            return float64(be.ActiveRequests())
+        }, "pool", name, "backend", be.URL.String())
+        metrics.Default.GaugeFunc("lb_backend_concurrency_limit", func() float64 {
+            return float64(be.ConcurrencyLimit())
+        }, "pool", name, "backend", be.URL.String())
+        metrics.Default.GaugeFunc("lb_backend_draining", func() float64 {
+            if be.Draining() {
+                return 1
+            }
+            return 0
+        }, "pool", name, "backend", be.URL.String())
+    }
+
+    return &pool{name: name, balancer: b, tlsConfig: tlsConfig, transport: transport}, nil
+}
+
+// initPools создаёт пулы из конфигурации. Верхнеуровневый список backends
+// становится пулом "default", поэтому в pools это имя зарезервировано. Пулы
+// с ошибками в конфигурации пропускаются и считаются ошибкой конфигурации.
+func (lb *LoadBalancer) initPools() {
+    lb.pools = make(map[string]*pool)
+
+    pools := make(map[string]config.Pool, len(lb.cfg.Pools)+1)
+    if len(lb.cfg.Backends) > 0 || len(lb.cfg.Pools) == 0 {
+        pools[defaultPool] = config.Pool{Backends: lb.cfg.Backends}
+    }
+    for name, pc := range lb.cfg.Pools {
+        if name == defaultPool {
+            lb.configError("pool name %q is reserved for top-level backends", defaultPool)
+            continue
+        }
+        pools[name] = pc
+    }
+
+    for name, pc := range pools {
+        p, err := lb.newPool(name, pc)
+        if err != nil {
+            lb.configError("failed to create pool %s: %v", name, err)
+            continue
+        }
+        lb.pools[name] = p
+    }
+}
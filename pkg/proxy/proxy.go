@@ -3,16 +3,25 @@ package proxy
 import (
     "context"
     "encoding/json"
+    "errors"
+    "fmt"
     "net"
     "net/http"
     "net/http/httputil"
     "strings"
+    "sync"
     "time"
 
     "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/cache"
+    "github.com/Manzo48/loadBalancer/pkg/compress"
     "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxyproto"
+    "github.com/Manzo48/loadBalancer/pkg/quota"
     "github.com/Manzo48/loadBalancer/pkg/ratelimiter"
+    "github.com/Manzo48/loadBalancer/pkg/shedding"
     "go.uber.org/zap"
+    "golang.org/x/net/http2/h2c"
 )
 
 // Структура для представления ошибки в формате JSON
@@ -30,25 +39,116 @@ func writeJSONError(w http.ResponseWriter, code int, msg string) {
 
 // LoadBalancer — основной тип, реализующий поведение прокси-сервера с балансировкой нагрузки и rate limiting
 type LoadBalancer struct {
-    balancer    *balancer.RoundRobinBalancer     // Round-robin балансировщик
+    cfg         *config.Config                   // Конфигурация
+    pools       map[string]*pool                 // Пулы backend'ов по именам
+    routes      []*route                         // Таблица маршрутизации запросов в пулы
     logger      *zap.SugaredLogger               // Логгер
+    mu          sync.Mutex                       // Защищает серверы, которые запускает ListenAndServe
     server      *http.Server                     // HTTP сервер
+    adminServer *http.Server                     // HTTP сервер админского API
+    tlsServer   *http.Server                     // HTTPS сервер (nil — TLS выключен)
+    stopCerts   chan struct{}                    // Останавливает перезагрузку сертификатов
+    tcp         []*tcpListener                   // L4-прокси для протоколов не поверх HTTP
+    udp         []*udpListener                   // Балансировка UDP
+    proxyProto  *proxyproto.Options              // Приём заголовков PROXY protocol (nil — выключен)
+    cache       *cache.Cache                     // Кэш ответов маршрутов (nil — ни на одном маршруте не включён)
+    cacheFlight *cache.Flight                    // Объединение одновременных промахов кэша
+    compressor  *compress.Compressor             // Сжатие ответов (nil — выключено)
     rateLimiter *ratelimiter.RateLimiter         // Rate limiter на основе Token Bucket
+    concurrency *ratelimiter.ConcurrencyLimiter  // Ограничение одновременных запросов
+    shedder     *shedding.Shedder                // Сброс нагрузки по приоритетам (nil — выключен)
+    quotas      *quota.Manager                   // Долгосрочные квоты (nil — выключены)
+    stopQuotas  chan struct{}                    // Останавливает периодический сброс квот на диск
+    upgrades    *upgradeTracker                  // Соединения после смены протокола (WebSocket)
+    configErrs  []error                          // Ошибки конфигурации: с ними ListenAndServe не запускается
 }
 
 // NewLoadBalancer инициализирует новый LoadBalancer с заданной конфигурацией
 func NewLoadBalancer(cfg *config.Config, logger *zap.SugaredLogger) *LoadBalancer {
-    rr := balancer.NewRoundRobin(cfg.Backends, logger) // Инициализация RoundRobin балансировщика
     rl := ratelimiter.NewRateLimiter(cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate, logger) // Инициализация rate limiter'а
+    if cfg.RateLimit.MaxClients > 0 {
+        rl.SetMaxBuckets(cfg.RateLimit.MaxClients)
+    }
 
     lb := &LoadBalancer{
-        balancer:    rr,
+        cfg:         cfg,
         logger:      logger,
         rateLimiter: rl,
+        concurrency: ratelimiter.NewConcurrencyLimiter(cfg.Concurrency.MaxPerClient, cfg.Concurrency.MaxGlobal),
+        upgrades:    newUpgradeTracker(),
+    }
+    for _, rule := range cfg.RateLimit.Rules {
+        err := rl.AddRule(ratelimiter.Rule{
+            Name:              rule.Name,
+            PathPrefix:        rule.PathPrefix,
+            Methods:           rule.Methods,
+            Cost:              rule.Cost,
+            BodyBytesPerToken: rule.BodyBytesPerToken,
+            CostHeader:        rule.CostHeader,
+            Capacity:          rule.Capacity,
+            RefillRate:        rule.RefillRate,
+            MaxWait:           rule.MaxWait,
+            MaxQueue:          rule.MaxQueue,
+            Mode:              ratelimiter.Mode(rule.Mode),
+        })
+        if err != nil {
+            lb.configError("invalid rate_limit rule: %v", err)
+        }
+    }
+    pp, err := newProxyProtocol(cfg.ProxyProtocol)
+    if err != nil {
+        lb.configError("invalid proxy_protocol: %v", err)
+    }
+    lb.proxyProto = pp
+
+    if cc := cfg.Compression; cc.Enabled {
+        c, err := compress.New(compress.Options{
+            Encodings:      cc.Encodings,
+            Types:          cc.Types,
+            StreamingTypes: cc.StreamingTypes,
+            MinSize:        cc.MinSize,
+        })
+        if err != nil {
+            lb.configError("invalid compression: %v", err)
+        }
+        lb.compressor = c
+    }
+
+    if lb.clientAuthEnabled() {
+        rl.SetKeyFunc(lb.clientKey) // Партнёры с сертификатами лимитируются по сертификату, а не по IP
+    }
+    lb.initPools()  // Инициализация пулов backend'ов со своими стратегиями балансировки
+    lb.initRoutes() // Таблица маршрутизации запросов в пулы
+    lb.initTCP()    // TCP-листенеры со своими backend'ами
+    lb.initUDP()    // UDP-листенеры
+
+    if cfg.LoadShedding.Enabled {
+        opts, err := sheddingOptions(cfg.LoadShedding, logger)
+        if err != nil {
+            lb.configError("invalid load_shedding: %v", err)
+        }
+        lb.shedder = shedding.NewShedder(opts)
+    }
+    if len(cfg.Quotas.Limits) > 0 || len(cfg.Quotas.Clients) > 0 {
+        lb.initQuotas(cfg.Quotas)
     }
 
-    logger.Infof("Initialized LoadBalancer on :%d with %d backends and rate limit %d/%ds",
-        cfg.Port, len(cfg.Backends), cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate)
+    logger.Infof("Initialized LoadBalancer on :%d with %d pools, %d routes and rate limit %d/%ds",
+        cfg.Port, len(lb.pools), len(lb.routes), cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate)
+
+    bucketTTL := cfg.RateLimit.BucketTTL
+    if bucketTTL <= 0 {
+        bucketTTL = 5 * time.Minute
+    }
+
+    // Восстанавливаем бакеты, сохранённые при предыдущей остановке
+    if path := cfg.RateLimit.SnapshotFile; path != "" {
+        if n, err := rl.LoadSnapshot(path, bucketTTL); err != nil {
+            logger.Warnf("failed to restore rate limiter snapshot %s: %v", path, err)
+        } else {
+            logger.Infof("restored %d rate limit buckets from %s", n, path)
+        }
+    }
 
     // Фоновая очистка неактивных токен-бакетов (например, старых IP-адресов)
     go func() {
@@ -56,28 +156,120 @@ func NewLoadBalancer(cfg *config.Config, logger *zap.SugaredLogger) *LoadBalance
         defer ticker.Stop()
         for range ticker.C {
             lb.logger.Debug("running rate limiter cleanup")
-            lb.rateLimiter.Cleanup(5 * time.Minute)
+            lb.rateLimiter.Cleanup(bucketTTL)
         }
     }()
 
     return lb
 }
 
-// ListenAndServe запускает HTTP-сервер на указанном адресе
-func (lb *LoadBalancer) ListenAndServe(addr string) error {
+// configError логирует ошибку конфигурации и запоминает её (см. ConfigError)
+func (lb *LoadBalancer) configError(format string, args ...any) {
+    err := fmt.Errorf(format, args...)
+    lb.logger.Error(err)
+    lb.configErrs = append(lb.configErrs, err)
+}
+
+// ConfigError возвращает ошибки конфигурации, найденные при создании балансировщика
+// (nil — ошибок нет). Части конфигурации с ошибками пропускаются, поэтому
+// ListenAndServe с ними не запускается, а не работает вполсилы.
+func (lb *LoadBalancer) ConfigError() error {
+    return errors.Join(lb.configErrs...)
+}
+
+// Handler возвращает HTTP-обработчик балансировщика со всеми middleware
+func (lb *LoadBalancer) Handler() http.Handler {
     mux := http.NewServeMux()
     mux.HandleFunc("/", lb.handle) // Роутинг всех запросов к lb.handle
 
-    // Оборачивание mux в middleware для лимитирования скорости
-    handler := ratelimiter.RateLimitMiddleware(lb.rateLimiter, lb.logger)(mux)
+    // Сжатие ответов — ближе всего к проксированию: кэш хранит несжатые ответы
+    var handler http.Handler = mux
+    if lb.compressor != nil {
+        handler = compress.Middleware(lb.compressor, lb.logger)(handler)
+    }
+
+    // Квоты учитывают только запросы, прошедшие rate limiting и лимит параллелизма:
+    // отклонённый запрос не должен расходовать квоту клиента
+    if lb.quotas != nil {
+        handler = quota.Middleware(lb.quotas, extractClientIP, lb.logger)(handler)
+    }
+
+    // Ограничение параллелизма проверяется после rate limiting,
+    // чтобы ожидающие токены запросы не занимали слоты
+    handler = ratelimiter.ConcurrencyMiddleware(lb.concurrency, lb.logger)(handler)
+
+    // Оборачивание в middleware для лимитирования скорости
+    handler = ratelimiter.RateLimitMiddleware(lb.rateLimiter, lb.logger)(handler)
+
+    // Сброс нагрузки: учитывает время запроса во всех очередях
+    if lb.shedder != nil {
+        handler = lb.shedder.Middleware(lb.logger)(handler)
+    }
+
+    // gRPC-клиенты получают ошибки всех слоёв как статусы gRPC, а не JSON
+    return grpcErrors(handler)
+}
+
+// ListenAndServe запускает HTTP-сервер на указанном адресе. После Shutdown
+// возвращает nil: остановка сервера — штатное завершение, а не ошибка.
+func (lb *LoadBalancer) ListenAndServe(addr string) error {
+    server, err := lb.start(addr)
+    if err != nil {
+        return err
+    }
+    ln, err := lb.listen(addr)
+    if err != nil {
+        return err
+    }
+    lb.logger.Infof("starting HTTP server on %s", addr)
+    if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    return nil
+}
+
+// start запускает вспомогательные листенеры и создаёт основной HTTP-сервер
+func (lb *LoadBalancer) start(addr string) (*http.Server, error) {
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+
+    if err := lb.ConfigError(); err != nil {
+        return nil, fmt.Errorf("invalid configuration: %w", err)
+    }
+
+    handler := lb.Handler()
+    plain := handler
+
+    if lb.cfg.TLS.Port != 0 {
+        if err := lb.startTLS(fmt.Sprintf(":%d", lb.cfg.TLS.Port), handler); err != nil {
+            return nil, fmt.Errorf("failed to start HTTPS server: %w", err)
+        }
+        if lb.cfg.TLS.RedirectHTTP {
+            plain = http.HandlerFunc(lb.redirectToHTTPS)
+        }
+    }
+
+    if err := lb.startTCP(); err != nil {
+        return nil, err
+    }
+    if err := lb.startUDP(); err != nil {
+        return nil, err
+    }
+
+    // h2c: HTTP/2 без TLS, как с prior knowledge, так и через Upgrade: h2c
+    if lb.cfg.HTTP2.H2C {
+        plain = h2c.NewHandler(plain, lb.http2Server())
+    }
 
     lb.server = &http.Server{
         Addr:    addr,
-        Handler: handler,
+        Handler: plain,
     }
 
-    lb.logger.Infof("starting HTTP server on %s", addr)
-    return lb.server.ListenAndServe()
+    if lb.cfg.Admin.Port != 0 {
+        lb.startAdmin(fmt.Sprintf(":%d", lb.cfg.Admin.Port))
+    }
+    return lb.server, nil
 }
 
 // Shutdown — корректное завершение работы сервера с таймаутом
@@ -85,11 +277,56 @@ func (lb *LoadBalancer) Shutdown() {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
 
-    lb.logger.Info("shutting down HTTP server...")
-    if err := lb.server.Shutdown(ctx); err != nil {
-        lb.logger.Errorf("graceful shutdown failed: %v", err)
-    } else {
-        lb.logger.Info("shutdown complete")
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+
+    if lb.adminServer != nil {
+        lb.adminServer.Shutdown(ctx)
+    }
+
+    if lb.tlsServer != nil {
+        if err := lb.tlsServer.Shutdown(ctx); err != nil {
+            lb.logger.Errorf("HTTPS graceful shutdown failed: %v", err)
+        }
+        close(lb.stopCerts)
+    }
+
+    if lb.server != nil {
+        lb.logger.Info("shutting down HTTP server...")
+        if err := lb.server.Shutdown(ctx); err != nil {
+            lb.logger.Errorf("graceful shutdown failed: %v", err)
+        } else {
+            lb.logger.Info("shutdown complete")
+        }
+    }
+
+    lb.shutdownTCP()
+    lb.shutdownUDP()
+
+    // Server.Shutdown не ждёт перехваченных соединений (WebSocket): даём им
+    // завершиться самостоятельно, затем закрываем
+    if n := lb.upgrades.len(); n > 0 {
+        grace := lb.cfg.Upgrade.ShutdownGrace
+        if grace <= 0 {
+            grace = 10 * time.Second
+        }
+        lb.logger.Infof("waiting up to %s for %d upgraded connections", grace, n)
+        lb.upgrades.closeAfter(nil, grace)
+    }
+
+    // Сохраняем бакеты, чтобы лимиты пережили перезапуск
+    if path := lb.cfg.RateLimit.SnapshotFile; path != "" {
+        if err := lb.rateLimiter.SaveSnapshot(path); err != nil {
+            lb.logger.Errorf("failed to save rate limiter snapshot: %v", err)
+        }
+    }
+
+    // Сохраняем счётчики квот после завершения всех запросов
+    if lb.quotas != nil {
+        close(lb.stopQuotas)
+        if err := lb.quotas.Close(); err != nil {
+            lb.logger.Errorf("failed to persist quotas: %v", err)
+        }
     }
 }
 
@@ -97,31 +334,122 @@ func (lb *LoadBalancer) Shutdown() {
 func (lb *LoadBalancer) handle(w http.ResponseWriter, r *http.Request) {
     clientIP := extractClientIP(r) // Извлекаем IP клиента
 
-    backend := lb.balancer.NextBackend() // Получаем следующий бэкенд по round-robin
-    if backend == nil {
-        lb.logger.Warn("no available backends")
+    if lb.shedder != nil {
+        lb.shedder.Dispatched(r) // Запрос покинул очереди лимитеров
+    }
+
+    rt := lb.matchRoute(r) // Выбираем пул по таблице маршрутизации
+    if rt == nil {
+        lb.logger.Warnf("no route for %s %s%s", r.Method, r.Host, r.URL.Path)
+        writeJSONError(w, http.StatusNotFound, "no route for request")
+        return
+    }
+
+    if lb.clientAuthEnabled() {
+        if rt.clientCert == clientCertRequired && verifiedClientCert(r) == nil {
+            lb.logger.Warnf("client certificate required for route %s, client %s", rt.name, clientIP)
+            writeJSONError(w, http.StatusForbidden, "client certificate required")
+            return
+        }
+        lb.forwardClientCert(r)
+    }
+
+    p := rt.selectPool(r, clientIP) // Пул маршрута с учётом разделения трафика
+    if rt.cache != nil && cache.Cacheable(r) && !isUpgrade(r) {
+        lb.serveCached(w, r, rt, p, clientIP)
+        return
+    }
+    lb.forward(w, r, rt, p, clientIP)
+}
+
+// forward проксирует запрос на backend выбранного пула маршрута
+func (lb *LoadBalancer) forward(w http.ResponseWriter, r *http.Request, rt *route, p *pool, clientIP string) {
+    backend, err := p.balancer.NextBackend(clientIP) // Получаем бэкенд по стратегии пула
+    if err != nil {
+        lb.logger.Warnf("no available backends: %v", err)
+        if errors.Is(err, balancer.ErrBackendsAtCapacity) {
+            writeJSONError(w, http.StatusServiceUnavailable, "all backends are busy")
+            return
+        }
         writeJSONError(w, http.StatusServiceUnavailable, "no available backends")
         return
     }
+    defer backend.Release()
+
+    // WebSocket и другие протоколы поверх Upgrade проксируются отдельно
+    if isUpgrade(r) {
+        lb.handleUpgrade(w, r, rt, p, backend)
+        return
+    }
 
     // Создаём ReverseProxy на выбранный backend
     proxy := httputil.NewSingleHostReverseProxy(backend.URL)
+    proxy.Transport = p.transport // TLS-настройки пула
+    var retries *retryTransport
+    if isGRPC(r.Header.Get("Content-Type")) {
+        proxy.FlushInterval = -1 // Потоковые RPC: сообщения передаются без буферизации
+        if rt.retry != nil {
+            retries = &retryTransport{
+                base:     p.transport,
+                pool:     p,
+                primary:  backend,
+                retry:    rt.retry,
+                clientID: clientIP,
+                logger:   lb.logger,
+            }
+            proxy.Transport = retries
+        }
+    }
 
-    // Переопределяем director, чтобы указать правильный Host
+    // Переопределяем director: правила перезаписи маршрута и правильный Host
     originalDirector := proxy.Director
     proxy.Director = func(req *http.Request) {
+        rt.rewrite.rewritePath(req) // Путь меняем до склейки с путём backend'а
         originalDirector(req)
-        req.Host = backend.URL.Host
+        if r.TLS != nil {
+            req.Header.Set("X-Forwarded-Proto", "https") // TLS завершается на балансировщике
+        }
+        rt.rewrite.rewriteRequest(req)
+        if !rt.rewrite.preserveHost {
+            req.Host = backend.URL.Host
+        }
+    }
+
+    // Копия запроса уходит в зеркало до проксирования, пока тело ещё не прочитано
+    var mirrored chan<- primaryResult
+    primaryStatus := http.StatusBadGateway
+    if rt.mirror != nil {
+        mirrored = rt.mirror.start(rt, r, clientIP)
+    }
+
+    // Замеряем задержку upstream (до получения заголовков ответа) для адаптивного лимита
+    start := time.Now()
+    if mirrored != nil {
+        defer func() { mirrored <- primaryResult{status: primaryStatus, latency: time.Since(start)} }()
+    }
+    // После повтора gRPC-запроса попытки уже учтены retryTransport'ом на своих backend'ах
+    retried := func() bool { return retries != nil && retries.retried }
+    proxy.ModifyResponse = func(resp *http.Response) error {
+        primaryStatus = resp.StatusCode
+        if !retried() {
+            backend.ObserveLatency(time.Since(start), resp.StatusCode >= http.StatusInternalServerError)
+        }
+        rt.rewrite.rewriteResponse(resp)
+        return nil
     }
 
     // Обработка ошибок проксирования
     proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-        lb.logger.Errorf("proxy error for backend %s: %v", backend.URL, err)
-        lb.balancer.MarkBackendDead(backend.URL) // Отмечаем backend как нерабочий
+        if !retried() {
+            backend.ObserveLatency(time.Since(start), true)
+            lb.logger.Errorf("proxy error for backend %s: %v", backend.URL, err)
+            p.balancer.MarkBackendDead(backend.URL) // Отмечаем backend как нерабочий
+        }
+        primaryStatus = http.StatusServiceUnavailable
         writeJSONError(rw, http.StatusServiceUnavailable, "backend unavailable")
     }
 
-    lb.logger.Infof("forwarding %s → %s", clientIP, backend.URL)
+    lb.logger.Infof("forwarding %s → %s (route %s)", clientIP, backend.URL, rt.name)
     proxy.ServeHTTP(w, r)
 }
 
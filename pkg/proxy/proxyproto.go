@@ -0,0 +1,34 @@
+package proxy
+
+import (
+    "errors"
+    "net"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxyproto"
+)
+
+// newProxyProtocol проверяет настройки приёма PROXY protocol (nil — выключен)
+func newProxyProtocol(pc config.ProxyProtocol) (*proxyproto.Options, error) {
+    if !pc.Enabled {
+        return nil, nil
+    }
+    if len(pc.TrustedCIDRs) == 0 {
+        return nil, errors.New("trusted_cidrs must not be empty: headers from any client would allow spoofing addresses")
+    }
+    trusted, err := proxyproto.ParseCIDRs(pc.TrustedCIDRs)
+    if err != nil {
+        return nil, err
+    }
+    return &proxyproto.Options{Trusted: trusted, Required: pc.Required, Timeout: pc.Timeout}, nil
+}
+
+// listen открывает TCP-листенер. С PROXY protocol адрес клиента соединения
+// (и r.RemoteAddr, по которому работает extractClientIP) берётся из заголовка.
+func (lb *LoadBalancer) listen(addr string) (net.Listener, error) {
+    ln, err := net.Listen("tcp", addr)
+    if err != nil || lb.proxyProto == nil {
+        return ln, err
+    }
+    return proxyproto.NewListener(ln, *lb.proxyProto), nil
+}
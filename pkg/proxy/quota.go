@@ -0,0 +1,66 @@
+package proxy
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/quota"
+)
+
+// initQuotas создаёт менеджер квот и запускает периодический сброс счётчиков на диск.
+// Если журнал не удалось прочитать, квоты работают только в памяти.
+func (lb *LoadBalancer) initQuotas(cfg config.Quotas) {
+    convert := func(limits []config.QuotaLimit) []quota.Limit {
+        out := make([]quota.Limit, 0, len(limits))
+        for _, l := range limits {
+            period := quota.Period(l.Period)
+            if period != quota.Daily && period != quota.Monthly {
+                lb.logger.Warnf("unknown quota period %q, skipping", l.Period)
+                continue
+            }
+            out = append(out, quota.Limit{Period: period, Max: l.Limit})
+        }
+        return out
+    }
+
+    clients := make(map[string][]quota.Limit, len(cfg.Clients))
+    for id, limits := range cfg.Clients {
+        clients[id] = convert(limits)
+    }
+
+    m, err := quota.NewManager(cfg.File, convert(cfg.Limits), clients, lb.logger)
+    if err != nil {
+        lb.logger.Errorf("failed to load quotas from %s, counting in memory only: %v", cfg.File, err)
+        m, _ = quota.NewManager("", convert(cfg.Limits), clients, lb.logger)
+    }
+
+    interval := cfg.FlushInterval
+    if interval <= 0 {
+        interval = 5 * time.Second
+    }
+    lb.quotas = m
+    lb.stopQuotas = make(chan struct{})
+    go m.Run(interval, lb.stopQuotas)
+}
+
+// handleQuotas отдаёт использование квот: одного клиента (?client=...) или всех
+func (lb *LoadBalancer) handleQuotas(w http.ResponseWriter, r *http.Request) {
+    if lb.quotas == nil {
+        writeJSONError(w, http.StatusNotFound, "quotas are not configured")
+        return
+    }
+
+    usage := make(map[string][]quota.Usage)
+    if client := r.URL.Query().Get("client"); client != "" {
+        usage[client] = lb.quotas.Usage(client)
+    } else {
+        for _, client := range lb.quotas.Clients() {
+            usage[client] = lb.quotas.Usage(client)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(usage)
+}
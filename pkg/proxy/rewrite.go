@@ -0,0 +1,106 @@
+package proxy
+
+import (
+    "fmt"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+)
+
+// rewriter применяет правила маршрута к запросу (в Director) и к ответу (в ModifyResponse)
+type rewriter struct {
+    stripPrefix     string
+    addPrefix       string
+    pathRegex       *regexp.Regexp
+    pathReplacement string
+    requestHeaders  config.HeaderRewrite
+    query           config.HeaderRewrite
+    responseHeaders config.HeaderRewrite
+    preserveHost    bool
+}
+
+// newRewriter компилирует правила перезаписи маршрута
+func newRewriter(rc config.Rewrite) (*rewriter, error) {
+    rw := &rewriter{
+        stripPrefix:     rc.StripPrefix,
+        addPrefix:       rc.AddPrefix,
+        pathReplacement: rc.PathReplacement,
+        requestHeaders:  rc.RequestHeaders,
+        query:           rc.Query,
+        responseHeaders: rc.ResponseHeaders,
+        preserveHost:    rc.PreserveHost,
+    }
+    if rc.PathRegex != "" {
+        re, err := regexp.Compile(rc.PathRegex)
+        if err != nil {
+            return nil, fmt.Errorf("invalid rewrite path_regex: %w", err)
+        }
+        rw.pathRegex = re
+    }
+    return rw, nil
+}
+
+// rewritePath меняет путь запроса до того, как Director склеит его с путём backend'а.
+// Порядок: удаление префикса, регулярное выражение, добавление префикса.
+func (rw *rewriter) rewritePath(req *http.Request) {
+    path := req.URL.Path
+    if rw.stripPrefix != "" {
+        path = strings.TrimPrefix(path, rw.stripPrefix)
+    }
+    if rw.pathRegex != nil {
+        path = rw.pathRegex.ReplaceAllString(path, rw.pathReplacement)
+    }
+    path = rw.addPrefix + path
+    if !strings.HasPrefix(path, "/") {
+        path = "/" + path
+    }
+    if path != req.URL.Path {
+        req.URL.Path = path
+        req.URL.RawPath = "" // Иначе Go возьмёт старый экранированный путь
+    }
+}
+
+// rewriteRequest применяет правила к заголовкам и query-параметрам запроса
+func (rw *rewriter) rewriteRequest(req *http.Request) {
+    applyHeaders(req.Header, rw.requestHeaders)
+
+    if len(rw.query.Add)+len(rw.query.Set)+len(rw.query.Remove) > 0 {
+        query := req.URL.Query()
+        applyValues(query, rw.query)
+        req.URL.RawQuery = query.Encode()
+    }
+}
+
+// rewriteResponse применяет правила к заголовкам ответа
+func (rw *rewriter) rewriteResponse(resp *http.Response) {
+    applyHeaders(resp.Header, rw.responseHeaders)
+}
+
+// applyHeaders применяет операции к заголовкам: удаление, замена, добавление
+func applyHeaders(h http.Header, ops config.HeaderRewrite) {
+    for _, name := range ops.Remove {
+        h.Del(name)
+    }
+    for name, value := range ops.Set {
+        h.Set(name, value)
+    }
+    for name, value := range ops.Add {
+        h.Add(name, value)
+    }
+}
+
+// applyValues применяет операции к query-параметрам
+func applyValues(v url.Values, ops config.HeaderRewrite) {
+    for _, name := range ops.Remove {
+        v.Del(name)
+    }
+    for name, value := range ops.Set {
+        v.Set(name, value)
+    }
+    for name, value := range ops.Add {
+        v.Add(name, value)
+    }
+}
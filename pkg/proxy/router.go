@@ -0,0 +1,209 @@
+package proxy
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "regexp"
+    "strings"
+    "sync/atomic"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// route — скомпилированное правило маршрутизации запросов в пул
+type route struct {
+    name       string
+    host       string // Точный хост или шаблон "*.example.com"
+    pathPrefix string
+    pathRegex  *regexp.Regexp
+    methods    []string
+    headers    map[string]string
+    query      map[string]string
+    pool       *pool
+    requests   *metrics.Counter // Запросы, направленные в pool (без разделения и явного выбора)
+    rewrite    *rewriter        // Правила изменения запроса и ответа
+
+    split    atomic.Pointer[trafficSplit] // Разделение трафика между пулами (nil — только pool)
+    override *splitOverride               // Принудительный выбор пула (nil — выключен)
+    mirror   *mirror                      // Зеркалирование трафика (nil — выключено)
+    retry    *grpcRetry                   // Повторы gRPC-запросов (nil — выключены)
+    cache    *routeCache                  // Кэширование ответов (nil — выключено)
+
+    clientCert string // Режим проверки клиентского сертификата: optional или required
+}
+
+// compileRoute проверяет правило маршрутизации и связывает его с пулом
+func (lb *LoadBalancer) compileRoute(rc config.Route) (*route, error) {
+    p, ok := lb.pools[rc.Pool]
+    if !ok && (rc.Pool != "" || len(rc.Split) == 0) {
+        return nil, fmt.Errorf("unknown pool %q", rc.Pool)
+    }
+
+    rt := &route{
+        name:       rc.Name,
+        host:       strings.ToLower(rc.Host),
+        pathPrefix: rc.PathPrefix,
+        methods:    rc.Methods,
+        headers:    rc.Headers,
+        query:      rc.Query,
+        pool:       p,
+    }
+    if rt.name == "" {
+        rt.name = rc.Pool
+    }
+    if p != nil {
+        rt.requests = newRouteTarget(rt.name, p).requests
+    }
+    if rc.PathRegex != "" {
+        re, err := regexp.Compile(rc.PathRegex)
+        if err != nil {
+            return nil, fmt.Errorf("invalid path_regex: %w", err)
+        }
+        rt.pathRegex = re
+    }
+    mode, err := lb.clientCertMode(rc.ClientCert)
+    if err != nil {
+        return nil, err
+    }
+    rt.clientCert = mode
+
+    rw, err := newRewriter(rc.Rewrite)
+    if err != nil {
+        return nil, err
+    }
+    rt.rewrite = rw
+
+    if len(rc.Split) > 0 {
+        split, err := lb.newTrafficSplit(rt.name, rc.Split)
+        if err != nil {
+            return nil, err
+        }
+        rt.split.Store(split)
+    }
+    if len(rc.SplitOverride.Values) > 0 {
+        ov, err := lb.newSplitOverride(rt.name, rc.SplitOverride)
+        if err != nil {
+            return nil, err
+        }
+        rt.override = ov
+    }
+    if rc.Mirror.Pool != "" {
+        m, err := lb.newMirror(rc.Mirror)
+        if err != nil {
+            return nil, err
+        }
+        rt.mirror = m
+    }
+    if rc.Retry.Attempts > 0 {
+        retry, err := newGRPCRetry(rt.name, rc.Retry)
+        if err != nil {
+            return nil, err
+        }
+        rt.retry = retry
+    }
+    if rc.Cache.Enabled {
+        rt.cache = lb.newRouteCache(rt.name, rc.Cache)
+    }
+    return rt, nil
+}
+
+// initRoutes компилирует таблицу маршрутизации. Если маршруты не заданы,
+// все запросы идут в пул "default".
+func (lb *LoadBalancer) initRoutes() {
+    routes := lb.cfg.Routes
+    if len(routes) == 0 {
+        if len(lb.cfg.Backends) == 0 && len(lb.cfg.Pools) > 0 {
+            lb.configError("routes are required when pools are configured without top-level backends")
+            return
+        }
+        routes = []config.Route{{Name: defaultPool, Pool: defaultPool}}
+    }
+
+    for _, rc := range routes {
+        rt, err := lb.compileRoute(rc)
+        if err != nil {
+            lb.configError("invalid route %s: %v", rc.Name, err)
+            continue
+        }
+        lb.routes = append(lb.routes, rt)
+    }
+}
+
+// matchRoute возвращает первый маршрут, подходящий под запрос, или nil
+func (lb *LoadBalancer) matchRoute(r *http.Request) *route {
+    for _, rt := range lb.routes {
+        if rt.matches(r) {
+            return rt
+        }
+    }
+    return nil
+}
+
+// matches проверяет, что запрос удовлетворяет всем условиям маршрута
+func (rt *route) matches(r *http.Request) bool {
+    if rt.host != "" && !matchHost(rt.host, r.Host) {
+        return false
+    }
+    if !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+        return false
+    }
+    if rt.pathRegex != nil && !rt.pathRegex.MatchString(r.URL.Path) {
+        return false
+    }
+    if len(rt.methods) > 0 && !containsFold(rt.methods, r.Method) {
+        return false
+    }
+    for name, want := range rt.headers {
+        if !matchValue(want, r.Header.Values(name)) {
+            return false
+        }
+    }
+    query := r.URL.Query()
+    for name, want := range rt.query {
+        if !matchValue(want, query[name]) {
+            return false
+        }
+    }
+    return true
+}
+
+// matchHost сравнивает хост запроса (без порта) с точным хостом или шаблоном "*.example.com"
+func matchHost(pattern, host string) bool {
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    host = strings.ToLower(host)
+
+    if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+        return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+    }
+    return host == pattern
+}
+
+// matchValue проверяет значения заголовка/параметра: "*" — достаточно присутствия
+func matchValue(want string, values []string) bool {
+    if len(values) == 0 {
+        return false
+    }
+    if want == "*" {
+        return true
+    }
+    for _, v := range values {
+        if v == want {
+            return true
+        }
+    }
+    return false
+}
+
+// containsFold проверяет наличие строки в списке без учёта регистра
+func containsFold(list []string, s string) bool {
+    for _, item := range list {
+        if strings.EqualFold(item, s) {
+            return true
+        }
+    }
+    return false
+}
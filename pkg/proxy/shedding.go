@@ -0,0 +1,56 @@
+package proxy
+
+import (
+    "fmt"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxyproto"
+    "github.com/Manzo48/loadBalancer/pkg/shedding"
+    "go.uber.org/zap"
+)
+
+// sheddingOptions преобразует конфигурацию сброса нагрузки в настройки Shedder.
+// Неизвестные имена классов приоритета логируются и заменяются на normal.
+func sheddingOptions(cfg config.LoadShedding, logger *zap.SugaredLogger) (shedding.Options, error) {
+    parse := func(name string) shedding.Priority {
+        p, ok := shedding.ParsePriority(name)
+        if !ok && name != "" {
+            logger.Warnf("unknown priority class %q, using normal", name)
+        }
+        return p
+    }
+
+    trusted, err := proxyproto.ParseCIDRs(cfg.TrustedCIDRs)
+    if err != nil {
+        return shedding.Options{}, fmt.Errorf("trusted_cidrs: %w", err)
+    }
+    if cfg.PriorityHeader != "" && len(trusted) == 0 {
+        logger.Warnf("load_shedding.priority_header %s is ignored: no trusted_cidrs configured", cfg.PriorityHeader)
+    }
+
+    opts := shedding.Options{
+        Header:          cfg.PriorityHeader,
+        TrustedNets:     trusted,
+        APIKeyHeader:    cfg.APIKeyHeader,
+        APIKeys:         make(map[string]shedding.Priority, len(cfg.APIKeys)),
+        Default:         parse(cfg.DefaultPriority),
+        MaxInflight:     cfg.MaxInflight,
+        MaxQueueLatency: cfg.MaxQueueLatency,
+        Thresholds:      make(map[shedding.Priority]float64, len(cfg.Thresholds)),
+        Status:          cfg.Status,
+        ExemptPaths:     cfg.ExemptPaths,
+    }
+    for key, name := range cfg.APIKeys {
+        opts.APIKeys[key] = parse(name)
+    }
+    for name, threshold := range cfg.Thresholds {
+        opts.Thresholds[parse(name)] = threshold
+    }
+    for _, route := range cfg.Routes {
+        opts.Routes = append(opts.Routes, shedding.RoutePriority{
+            PathPrefix: route.PathPrefix,
+            Priority:   parse(route.Priority),
+        })
+    }
+    return opts, nil
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+    "encoding/json"
+    "fmt"
+    "hash/fnv"
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// routeTarget — пул, в который маршрут направляет запросы, и счётчик этих запросов.
+// Счётчик создаётся заранее, чтобы не обращаться к реестру метрик на каждый запрос.
+type routeTarget struct {
+    pool     *pool
+    requests *metrics.Counter
+}
+
+// newRouteTarget связывает пул со счётчиком lb_route_requests_total маршрута
+func newRouteTarget(route string, p *pool) routeTarget {
+    return routeTarget{
+        pool:     p,
+        requests: metrics.NewCounter("lb_route_requests_total", "route", route, "pool", p.name),
+    }
+}
+
+// splitTarget — пул и его вес в разделении трафика
+type splitTarget struct {
+    routeTarget
+    weight int
+}
+
+// trafficSplit — неизменяемая таблица весов; при изменении через админский API
+// заменяется целиком, поэтому читается без блокировок
+type trafficSplit struct {
+    targets []splitTarget
+    total   int
+}
+
+// newTrafficSplit проверяет веса и связывает их с пулами маршрута route
+func (lb *LoadBalancer) newTrafficSplit(route string, weights []config.Split) (*trafficSplit, error) {
+    split := &trafficSplit{}
+    for _, w := range weights {
+        p, ok := lb.pools[w.Pool]
+        if !ok {
+            return nil, fmt.Errorf("unknown pool %q in split", w.Pool)
+        }
+        if w.Weight < 0 {
+            return nil, fmt.Errorf("negative weight for pool %q", w.Pool)
+        }
+        split.targets = append(split.targets, splitTarget{routeTarget: newRouteTarget(route, p), weight: w.Weight})
+        split.total += w.Weight
+    }
+    if split.total == 0 {
+        return nil, fmt.Errorf("split weights sum to zero")
+    }
+    return split, nil
+}
+
+// weights возвращает таблицу весов в виде конфигурации (для админского API)
+func (s *trafficSplit) weights() []config.Split {
+    out := make([]config.Split, 0, len(s.targets))
+    for _, t := range s.targets {
+        out = append(out, config.Split{Pool: t.pool.name, Weight: t.weight})
+    }
+    return out
+}
+
+// splitOverride — принудительный выбор пула по заголовку или cookie
+type splitOverride struct {
+    header string
+    cookie string
+    values map[string]routeTarget
+}
+
+// newSplitOverride связывает значения заголовка/cookie с пулами маршрута route
+func (lb *LoadBalancer) newSplitOverride(route string, oc config.SplitOverride) (*splitOverride, error) {
+    ov := &splitOverride{header: oc.Header, cookie: oc.Cookie, values: make(map[string]routeTarget)}
+    for value, name := range oc.Values {
+        p, ok := lb.pools[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown pool %q in split override", name)
+        }
+        ov.values[value] = newRouteTarget(route, p)
+    }
+    return ov, nil
+}
+
+// lookup возвращает пул, явно запрошенный клиентом; false — клиент ничего не запрашивал
+func (ov *splitOverride) lookup(r *http.Request) (routeTarget, bool) {
+    if ov.header != "" {
+        if t, ok := ov.values[r.Header.Get(ov.header)]; ok {
+            return t, true
+        }
+    }
+    if ov.cookie != "" {
+        if c, err := r.Cookie(ov.cookie); err == nil {
+            if t, ok := ov.values[c.Value]; ok {
+                return t, true
+            }
+        }
+    }
+    return routeTarget{}, false
+}
+
+// selectPool выбирает пул для запроса: явный выбор клиента, затем разделение
+// трафика по весам. Клиент стабильно попадает в одну и ту же долю, пока веса
+// не изменились, поэтому не "прыгает" между версиями. Явный выбор клиента
+// окончателен, даже если он совпадает с основным пулом маршрута.
+func (rt *route) selectPool(r *http.Request, clientID string) *pool {
+    if rt.override != nil {
+        if t, ok := rt.override.lookup(r); ok {
+            t.requests.Inc()
+            return t.pool
+        }
+    }
+    if split := rt.split.Load(); split != nil {
+        hash := fnv.New32a()
+        hash.Write([]byte(rt.name + "|" + clientID))
+        point := int(hash.Sum32() % uint32(split.total))
+
+        for _, t := range split.targets {
+            if point < t.weight {
+                t.requests.Inc()
+                return t.pool
+            }
+            point -= t.weight
+        }
+    }
+
+    rt.requests.Inc()
+    return rt.pool
+}
+
+// routeInfo — описание маршрута для админского API
+type routeInfo struct {
+    Name  string         `json:"name"`
+    Pool  string         `json:"pool,omitempty"`
+    Split []config.Split `json:"split,omitempty"`
+}
+
+// handleRoutes отдаёт список маршрутов (GET /routes) и меняет веса разделения
+// трафика маршрута на лету (PUT /routes?route=name, тело — [{"pool":..,"weight":..}])
+func (lb *LoadBalancer) handleRoutes(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        infos := make([]routeInfo, 0, len(lb.routes))
+        for _, rt := range lb.routes {
+            info := routeInfo{Name: rt.name}
+            if rt.pool != nil {
+                info.Pool = rt.pool.name
+            }
+            if split := rt.split.Load(); split != nil {
+                info.Split = split.weights()
+            }
+            infos = append(infos, info)
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(infos)
+
+    case http.MethodPut, http.MethodPost:
+        name := r.URL.Query().Get("route")
+        var rt *route
+        for _, candidate := range lb.routes {
+            if candidate.name == name {
+                rt = candidate
+            }
+        }
+        if rt == nil {
+            writeJSONError(w, http.StatusNotFound, fmt.Sprintf("route %q not found", name))
+            return
+        }
+
+        var weights []config.Split
+        if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+            writeJSONError(w, http.StatusBadRequest, "invalid split: "+err.Error())
+            return
+        }
+        split, err := lb.newTrafficSplit(rt.name, weights)
+        if err != nil {
+            writeJSONError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        rt.split.Store(split)
+        lb.logger.Infof("traffic split for route %s changed to %v", rt.name, weights)
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(routeInfo{Name: rt.name, Split: split.weights()})
+
+    default:
+        writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/proxyproto"
+    "github.com/Manzo48/loadBalancer/pkg/tcpproxy"
+)
+
+// tcpListener — L4-прокси и его настройки из конфигурации
+type tcpListener struct {
+    cfg   config.TCPListener
+    proxy *tcpproxy.Proxy
+}
+
+// initTCP создаёт TCP-листенеры из конфигурации. Листенеры с ошибками пропускаются
+// и считаются ошибкой конфигурации.
+func (lb *LoadBalancer) initTCP() {
+    for _, tc := range lb.cfg.TCP {
+        if tc.Name == "" {
+            tc.Name = fmt.Sprintf("tcp-%d", tc.Port)
+        }
+        if tc.HealthCheck.Protocol != "" && tc.HealthCheck.Protocol != "tcp" {
+            lb.configError("failed to create tcp listener %s: unsupported health_check protocol %q", tc.Name, tc.HealthCheck.Protocol)
+            continue
+        }
+        sendProxy, err := proxyproto.ParseVersion(tc.SendProxyProtocol)
+        if err != nil {
+            lb.configError("failed to create tcp listener %s: %v", tc.Name, err)
+            continue
+        }
+        p, err := tcpproxy.New(tcpproxy.Options{
+            Name:           tc.Name,
+            Backends:       tc.Backends,
+            Strategy:       tc.Strategy,
+            MaxPerBackend:  tc.MaxPerBackend,
+            IdleTimeout:    tc.IdleTimeout,
+            ConnectTimeout: tc.ConnectTimeout,
+            HealthCheck: balancer.HealthCheck{
+                Interval: tc.HealthCheck.Interval,
+                Timeout:  tc.HealthCheck.Timeout,
+            },
+            SendProxyProto: sendProxy,
+        }, lb.logger)
+        if err != nil {
+            lb.configError("failed to create tcp listener %s: %v", tc.Name, err)
+            continue
+        }
+        lb.tcp = append(lb.tcp, &tcpListener{cfg: tc, proxy: p})
+    }
+}
+
+// startTCP открывает порты TCP-листенеров и принимает соединения в фоне
+func (lb *LoadBalancer) startTCP() error {
+    for _, l := range lb.tcp {
+        ln, err := lb.listen(fmt.Sprintf(":%d", l.cfg.Port))
+        if err != nil {
+            return fmt.Errorf("tcp listener %s: %w", l.cfg.Name, err)
+        }
+        go func(l *tcpListener) {
+            if err := l.proxy.Serve(ln); err != nil {
+                lb.logger.Errorf("tcp listener %s failed: %v", l.cfg.Name, err)
+            }
+        }(l)
+    }
+    return nil
+}
+
+// shutdownTCP закрывает TCP-листенеры и дожидается завершения соединений
+func (lb *LoadBalancer) shutdownTCP() {
+    var wg sync.WaitGroup
+    for _, l := range lb.tcp {
+        grace := l.cfg.ShutdownGrace
+        if grace <= 0 {
+            grace = 30 * time.Second
+        }
+        wg.Add(1)
+        go func(l *tcpListener) {
+            defer wg.Done()
+            l.proxy.Shutdown(grace)
+        }(l)
+    }
+    wg.Wait()
+}
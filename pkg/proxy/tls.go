@@ -0,0 +1,105 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "net"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/certs"
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "go.uber.org/zap"
+    "golang.org/x/net/http2"
+)
+
+// newTLSConfig загружает сертификаты HTTPS-листенера и собирает настройки TLS
+func (lb *LoadBalancer) newTLSConfig(tc config.TLS) (*tls.Config, *certs.Store, error) {
+    pairs := make([]certs.Pair, 0, len(tc.Certificates))
+    for _, c := range tc.Certificates {
+        pairs = append(pairs, certs.Pair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+    }
+    store, err := certs.NewStore(pairs, lb.logger)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    minVersion, err := certs.ParseVersion(tc.MinVersion)
+    if err != nil {
+        return nil, nil, err
+    }
+    suites, err := certs.ParseCipherSuites(tc.CipherSuites)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    config := &tls.Config{
+        GetCertificate: store.GetCertificate,
+        MinVersion:     minVersion,
+        CipherSuites:   suites,
+    }
+
+    // Сертификат проверяется, если клиент его предъявил; обязательность решает маршрут
+    if tc.ClientAuth.CAFile != "" {
+        pool, err := certs.LoadCAPool(tc.ClientAuth.CAFile)
+        if err != nil {
+            return nil, nil, err
+        }
+        config.ClientCAs = pool
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+    }
+    return config, store, nil
+}
+
+// startTLS запускает HTTPS-листенер и периодическую перезагрузку сертификатов.
+// Ошибки конфигурации и занятый порт возвращаются сразу, до начала обслуживания.
+func (lb *LoadBalancer) startTLS(addr string, handler http.Handler) error {
+    tlsConfig, store, err := lb.newTLSConfig(lb.cfg.TLS)
+    if err != nil {
+        return err
+    }
+    ln, err := lb.listen(addr)
+    if err != nil {
+        return err
+    }
+
+    lb.tlsServer = &http.Server{
+        Addr:      addr,
+        Handler:   handler,
+        TLSConfig: tlsConfig,
+        ErrorLog:  zap.NewStdLog(lb.logger.Desugar()), // Ошибки рукопожатий — в общий лог
+    }
+
+    // HTTP/2 по ALPN с общими для листенеров настройками
+    if err := http2.ConfigureServer(lb.tlsServer, lb.http2Server()); err != nil {
+        ln.Close()
+        return err
+    }
+
+    interval := lb.cfg.TLS.ReloadInterval
+    if interval <= 0 {
+        interval = 30 * time.Second
+    }
+    lb.stopCerts = make(chan struct{})
+    go store.Run(interval, lb.stopCerts)
+
+    go func() {
+        lb.logger.Infof("starting HTTPS server on %s", addr)
+        if err := lb.tlsServer.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+            lb.logger.Errorf("HTTPS server failed: %v", err)
+        }
+    }()
+    return nil
+}
+
+// redirectToHTTPS перенаправляет запросы с HTTP-листенера на тот же адрес по HTTPS
+func (lb *LoadBalancer) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    if port := lb.cfg.TLS.Port; port != 443 {
+        host = net.JoinHostPort(host, strconv.Itoa(port))
+    }
+    http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
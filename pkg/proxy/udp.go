@@ -0,0 +1,88 @@
+package proxy
+
+import (
+    "fmt"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/config"
+    "github.com/Manzo48/loadBalancer/pkg/ratelimiter"
+    "github.com/Manzo48/loadBalancer/pkg/udpproxy"
+)
+
+// udpListener — UDP-прокси и его настройки из конфигурации
+type udpListener struct {
+    cfg   config.UDPListener
+    proxy *udpproxy.Proxy
+}
+
+// initUDP создаёт UDP-листенеры из конфигурации. Листенеры с ошибками пропускаются
+// и считаются ошибкой конфигурации.
+func (lb *LoadBalancer) initUDP() {
+    for _, uc := range lb.cfg.UDP {
+        if uc.Name == "" {
+            uc.Name = fmt.Sprintf("udp-%d", uc.Port)
+        }
+
+        // У UDP нет универсальной проверки: по умолчанию backend'ы не проверяются,
+        // а для DNS и подобных серверов можно проверять TCP на том же порту
+        health := balancer.HealthCheck{
+            Interval: uc.HealthCheck.Interval,
+            Timeout:  uc.HealthCheck.Timeout,
+        }
+        switch uc.HealthCheck.Protocol {
+        case "", "none":
+            health.Disabled = true
+        case "tcp":
+        default:
+            lb.configError("failed to create udp listener %s: unsupported health_check protocol %q", uc.Name, uc.HealthCheck.Protocol)
+            continue
+        }
+
+        var limiter *ratelimiter.RateLimiter
+        if uc.RateLimit.Capacity > 0 {
+            limiter = ratelimiter.NewRateLimiter(uc.RateLimit.Capacity, uc.RateLimit.RefillRate, lb.logger)
+            if lb.cfg.RateLimit.MaxClients > 0 {
+                limiter.SetMaxBuckets(lb.cfg.RateLimit.MaxClients)
+            }
+        }
+
+        p, err := udpproxy.New(udpproxy.Options{
+            Name:           uc.Name,
+            Backends:       uc.Backends,
+            Strategy:       uc.Strategy,
+            MaxPerBackend:  uc.MaxPerBackend,
+            MaxSessions:    uc.MaxSessions,
+            SessionTimeout: uc.SessionTimeout,
+            HealthCheck:    health,
+            RateLimiter:    limiter,
+        }, lb.logger)
+        if err != nil {
+            lb.configError("failed to create udp listener %s: %v", uc.Name, err)
+            continue
+        }
+        lb.udp = append(lb.udp, &udpListener{cfg: uc, proxy: p})
+    }
+}
+
+// startUDP открывает порты UDP-листенеров и обрабатывает датаграммы в фоне
+func (lb *LoadBalancer) startUDP() error {
+    for _, l := range lb.udp {
+        conn, err := l.proxy.Listen(fmt.Sprintf(":%d", l.cfg.Port))
+        if err != nil {
+            return fmt.Errorf("udp listener %s: %w", l.cfg.Name, err)
+        }
+        go func(l *udpListener) {
+            if err := l.proxy.Serve(conn); err != nil {
+                lb.logger.Errorf("udp listener %s failed: %v", l.cfg.Name, err)
+            }
+        }(l)
+    }
+    return nil
+}
+
+// shutdownUDP закрывает UDP-листенеры и их сессии
+func (lb *LoadBalancer) shutdownUDP() {
+    for _, l := range lb.udp {
+        l.proxy.Shutdown()
+    }
+}
@@ -0,0 +1,369 @@
+package proxy
+
+import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httputil"
+    "net/url"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/pkg/balancer"
+    "github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// hopHeaders — hop-by-hop заголовки, которые относятся к конкретному соединению
+// и не передаются дальше (RFC 7230, раздел 6.1)
+var hopHeaders = []string{
+    "Connection",
+    "Proxy-Connection",
+    "Keep-Alive",
+    "Proxy-Authenticate",
+    "Proxy-Authorization",
+    "Te",
+    "Trailer",
+    "Transfer-Encoding",
+    "Upgrade",
+}
+
+// isUpgrade проверяет, что клиент просит сменить протокол (например, на WebSocket)
+func isUpgrade(r *http.Request) bool {
+    return r.Header.Get("Upgrade") != "" && headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+// headerHasToken проверяет наличие токена в заголовке со списком через запятую
+func headerHasToken(h http.Header, name, token string) bool {
+    for _, value := range h.Values(name) {
+        for _, t := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// removeHopHeaders удаляет hop-by-hop заголовки, включая перечисленные в Connection
+func removeHopHeaders(h http.Header) {
+    for _, value := range h.Values("Connection") {
+        for _, name := range strings.Split(value, ",") {
+            if name = strings.TrimSpace(name); name != "" {
+                h.Del(name)
+            }
+        }
+    }
+    for _, name := range hopHeaders {
+        h.Del(name)
+    }
+}
+
+// upgradedConn — долгоживущее соединение после смены протокола
+type upgradedConn struct {
+    backend    *balancer.Backend
+    client     net.Conn
+    upstream   net.Conn
+    idle       time.Duration
+    lastActive atomic.Int64  // Время последнего трафика в любую сторону, UnixNano
+    done       chan struct{} // Закрывается, когда соединение завершено
+}
+
+// touch отмечает активность соединения
+func (c *upgradedConn) touch() {
+    c.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor возвращает время без трафика в обе стороны
+func (c *upgradedConn) idleFor() time.Duration {
+    return time.Since(time.Unix(0, c.lastActive.Load()))
+}
+
+// close разрывает обе стороны соединения
+func (c *upgradedConn) close() {
+    c.client.Close()
+    c.upstream.Close()
+}
+
+// pipe копирует данные из src в dst. Соединение считается простаивающим, только
+// если трафика не было в обе стороны дольше idle.
+func (c *upgradedConn) pipe(dst, src net.Conn) error {
+    buf := make([]byte, 32<<10)
+    for {
+        if c.idle > 0 {
+            src.SetReadDeadline(time.Now().Add(c.idle))
+        }
+        n, err := src.Read(buf)
+        if n > 0 {
+            c.touch()
+            if _, werr := dst.Write(buf[:n]); werr != nil {
+                return werr
+            }
+        }
+        if err != nil {
+            if ne, ok := err.(net.Error); ok && ne.Timeout() && c.idle > 0 && c.idleFor() < c.idle {
+                continue // Трафик шёл в обратную сторону — соединение живо
+            }
+            return err
+        }
+    }
+}
+
+// serve передаёт данные в обе стороны, пока одна из сторон не закроет соединение
+func (c *upgradedConn) serve() {
+    c.touch()
+    errc := make(chan error, 2)
+    go func() { errc <- c.pipe(c.upstream, c.client) }()
+    go func() { errc <- c.pipe(c.client, c.upstream) }()
+    <-errc
+    c.close() // Разблокирует вторую горутину
+    <-errc
+}
+
+// upgradeTracker отслеживает соединения после смены протокола. http.Server.Shutdown
+// их не ждёт, поэтому балансировщик закрывает их сам.
+type upgradeTracker struct {
+    mu    sync.Mutex
+    conns map[*upgradedConn]struct{}
+}
+
+func newUpgradeTracker() *upgradeTracker {
+    t := &upgradeTracker{conns: make(map[*upgradedConn]struct{})}
+    metrics.Default.GaugeFunc("lb_upgraded_connections", func() float64 {
+        return float64(t.len())
+    })
+    return t
+}
+
+func (t *upgradeTracker) add(c *upgradedConn) {
+    t.mu.Lock()
+    t.conns[c] = struct{}{}
+    t.mu.Unlock()
+}
+
+func (t *upgradeTracker) remove(c *upgradedConn) {
+    t.mu.Lock()
+    delete(t.conns, c)
+    t.mu.Unlock()
+}
+
+func (t *upgradeTracker) len() int {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return len(t.conns)
+}
+
+// closeAfter даёт соединениям backend'а (nil — всем) grace на самостоятельное
+// завершение, после чего принудительно закрывает оставшиеся. Соединения конкретного
+// backend'а закрываются, только если он к этому времени всё ещё в режиме drain.
+func (t *upgradeTracker) closeAfter(backend *url.URL, grace time.Duration) {
+    t.mu.Lock()
+    var conns []*upgradedConn
+    for c := range t.conns {
+        if backend == nil || c.backend.URL.String() == backend.String() {
+            conns = append(conns, c)
+        }
+    }
+    t.mu.Unlock()
+
+    deadline := time.NewTimer(grace)
+    defer deadline.Stop()
+    for i, c := range conns {
+        select {
+        case <-c.done:
+        case <-deadline.C:
+            for _, rest := range conns[i:] {
+                if backend == nil || rest.backend.Draining() {
+                    rest.close()
+                }
+            }
+            return
+        }
+    }
+}
+
+// dialBackend открывает TCP (или TLS для https) соединение с backend'ом
+func dialBackend(ctx context.Context, u *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
+    host := u.Host
+    if u.Port() == "" {
+        port := "80"
+        if u.Scheme == "https" {
+            port = "443"
+        }
+        host = net.JoinHostPort(u.Hostname(), port)
+    }
+
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    if u.Scheme == "https" {
+        config := &tls.Config{}
+        if tlsConfig != nil {
+            config = tlsConfig.Clone()
+        }
+        if config.ServerName == "" {
+            config.ServerName = u.Hostname()
+        }
+        config.NextProtos = []string{"http/1.1"} // Upgrade есть только в HTTP/1.1
+        td := &tls.Dialer{NetDialer: dialer, Config: config}
+        return td.DialContext(ctx, "tcp", host)
+    }
+    return dialer.DialContext(ctx, "tcp", host)
+}
+
+// handleUpgrade проксирует запрос со сменой протокола: передаёт рукопожатие
+// backend'у, при ответе 101 перехватывает соединение клиента и соединяет его
+// с backend'ом напрямую. Обработчик не завершается, пока соединение живо, поэтому
+// оно учитывается в активных запросах backend'а (и в least_connections), но не
+// в лимитах параллелизма и нагрузке Shedder'а.
+func (lb *LoadBalancer) handleUpgrade(w http.ResponseWriter, r *http.Request, rt *route, p *pool, backend *balancer.Backend) {
+    upgrade := r.Header.Get("Upgrade")
+
+    out := r.Clone(r.Context())
+    removeHopHeaders(out.Header)
+    rt.rewrite.rewritePath(out)
+    httputil.NewSingleHostReverseProxy(backend.URL).Director(out)
+    rt.rewrite.rewriteRequest(out)
+    if !rt.rewrite.preserveHost {
+        out.Host = backend.URL.Host
+    }
+    out.Header.Set("Connection", "Upgrade")
+    out.Header.Set("Upgrade", upgrade)
+    if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+        if prior := out.Header.Get("X-Forwarded-For"); prior != "" {
+            ip = prior + ", " + ip
+        }
+        out.Header.Set("X-Forwarded-For", ip)
+    }
+
+    start := time.Now()
+    fail := func(err error) {
+        backend.ObserveLatency(time.Since(start), true)
+        lb.logger.Errorf("upgrade proxy error for backend %s: %v", backend.URL, err)
+        p.balancer.MarkBackendDead(backend.URL)
+        writeJSONError(w, http.StatusServiceUnavailable, "backend unavailable")
+    }
+
+    upstream, err := dialBackend(r.Context(), backend.URL, p.tlsConfig)
+    if err != nil {
+        fail(err)
+        return
+    }
+    // Backend, принявший соединение, но не ответивший на рукопожатие,
+    // не должен бесконечно держать обработчик и слоты параллелизма
+    upstream.SetDeadline(time.Now().Add(lb.upgradeHandshakeTimeout()))
+    if err := out.Write(upstream); err != nil {
+        upstream.Close()
+        fail(err)
+        return
+    }
+    br := bufio.NewReader(upstream)
+    resp, err := http.ReadResponse(br, out)
+    if err != nil {
+        upstream.Close()
+        fail(err)
+        return
+    }
+    upstream.SetDeadline(time.Time{}) // Дальше время жизни ограничивает только idle_timeout
+    // Замеряем только рукопожатие: время жизни соединения не говорит о загрузке backend'а
+    backend.ObserveLatency(time.Since(start), resp.StatusCode >= http.StatusInternalServerError)
+
+    // Backend отказался менять протокол — отдаём его ответ как обычный
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        defer upstream.Close()
+        defer resp.Body.Close()
+        removeHopHeaders(resp.Header)
+        rt.rewrite.rewriteResponse(resp)
+        for name, values := range resp.Header {
+            w.Header()[name] = values
+        }
+        w.WriteHeader(resp.StatusCode)
+        io.Copy(w, resp.Body)
+        return
+    }
+
+    if !strings.EqualFold(resp.Header.Get("Upgrade"), upgrade) {
+        upstream.Close()
+        lb.logger.Errorf("backend %s switched to %q instead of %q", backend.URL, resp.Header.Get("Upgrade"), upgrade)
+        writeJSONError(w, http.StatusBadGateway, "backend switched to unexpected protocol")
+        return
+    }
+
+    client, brw, err := http.NewResponseController(w).Hijack()
+    if err != nil {
+        upstream.Close()
+        lb.logger.Errorf("failed to hijack connection: %v", err)
+        writeJSONError(w, http.StatusInternalServerError, "connection upgrade not supported")
+        return
+    }
+
+    removeHopHeaders(resp.Header)
+    rt.rewrite.rewriteResponse(resp)
+    resp.Header.Set("Connection", "Upgrade")
+    resp.Header.Set("Upgrade", upgrade)
+    fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status)
+    resp.Header.Write(brw)
+    brw.WriteString("\r\n")
+
+    // Данные, которые backend успел прислать сразу после рукопожатия
+    if n := br.Buffered(); n > 0 {
+        data, _ := br.Peek(n)
+        brw.Write(data)
+    }
+    if err := brw.Flush(); err != nil {
+        client.Close()
+        upstream.Close()
+        return
+    }
+    // Данные, которые клиент успел прислать вслед за запросом
+    if n := brw.Reader.Buffered(); n > 0 {
+        data, _ := brw.Reader.Peek(n)
+        if _, err := upstream.Write(data); err != nil {
+            client.Close()
+            upstream.Close()
+            return
+        }
+    }
+
+    conn := &upgradedConn{
+        backend:  backend,
+        client:   client,
+        upstream: upstream,
+        idle:     lb.upgradeIdleTimeout(),
+        done:     make(chan struct{}),
+    }
+    lb.upgrades.add(conn)
+    // Соединение больше не запрос в обработке: слоты лимитера параллелизма и учёт
+    // Shedder'а освобождаются сразу, backend остаётся занятым до закрытия
+    lb.concurrency.Detach(r)
+    if lb.shedder != nil {
+        lb.shedder.Detach(r)
+    }
+    defer func() {
+        lb.upgrades.remove(conn)
+        close(conn.done)
+    }()
+
+    lb.logger.Infof("upgraded connection to %s via %s (route %s)", upgrade, backend.URL, rt.name)
+    conn.serve()
+    lb.logger.Debugf("upgraded connection to %s closed after %s", backend.URL, time.Since(start))
+}
+
+// upgradeHandshakeTimeout возвращает время ожидания ответа backend'а на рукопожатие
+func (lb *LoadBalancer) upgradeHandshakeTimeout() time.Duration {
+    if lb.cfg.Upgrade.HandshakeTimeout > 0 {
+        return lb.cfg.Upgrade.HandshakeTimeout
+    }
+    return 10 * time.Second
+}
+
+// upgradeIdleTimeout возвращает таймаут простоя соединения после смены протокола
+func (lb *LoadBalancer) upgradeIdleTimeout() time.Duration {
+    if lb.cfg.Upgrade.IdleTimeout > 0 {
+        return lb.cfg.Upgrade.IdleTimeout
+    }
+    return 10 * time.Minute
+}
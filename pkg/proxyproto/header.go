@@ -0,0 +1,223 @@
+// Package proxyproto читает и формирует заголовки PROXY protocol v1 и v2
+// (https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt), которыми
+// L4-балансировщики передают исходный адрес клиента.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// signature — начало заголовка версии 2
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length — максимальная длина заголовка версии 1 вместе с CRLF
+const maxV1Length = 107
+
+// ErrNoHeader — соединение не начинается с заголовка PROXY protocol
+var ErrNoHeader = errors.New("proxy protocol header not found")
+
+// Header — адреса соединения из заголовка PROXY protocol
+type Header struct {
+	Version     int      // 1 или 2
+	Source      net.Addr // Адрес клиента (nil — не передан: UNKNOWN или LOCAL)
+	Destination net.Addr // Адрес, к которому подключался клиент
+}
+
+// Read читает заголовок любой версии из начала потока. Если поток начинается
+// не с заголовка, возвращается ErrNoHeader, а данные остаются в r.
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case signature[0]:
+		if b, err := r.Peek(len(signature)); err != nil || !bytes.Equal(b, signature) {
+			return nil, ErrNoHeader
+		}
+		return readV2(r)
+	case 'P':
+		if b, err := r.Peek(6); err != nil || string(b) != "PROXY " {
+			return nil, ErrNoHeader
+		}
+		return readV1(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+// readV1 разбирает текстовый заголовок: "PROXY TCP4 src dst sport dport\r\n"
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol v1 header is too long")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil // Адреса неизвестны: остаётся адрес соединения
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed proxy protocol v1 header %q", line)
+	}
+	src, err := parseAddr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseAddr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseAddr(host, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, fmt.Errorf("invalid address %q in proxy protocol header", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in proxy protocol header", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 разбирает двоичный заголовок версии 2. TLV-расширения пропускаются.
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy protocol version %d", fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0f
+	family := fixed[13]
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch command {
+	case 0x0: // LOCAL: соединение самого балансировщика (например, health-check)
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unknown proxy protocol v2 command %d", command)
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		return h, nil // AF_UNSPEC и AF_UNIX: адрес соединения не меняется
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, errors.New("truncated proxy protocol v2 addresses")
+	}
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+	if family&0x0f == 0x2 { // DGRAM
+		h.Source = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		h.Destination = &net.UDPAddr{IP: dstIP, Port: dstPort}
+	} else {
+		h.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		h.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	}
+	return h, nil
+}
+
+// Format возвращает заголовок указанной версии для адресов соединения
+func Format(version int, src, dst net.Addr) ([]byte, error) {
+	srcIP, srcPort := splitAddr(src)
+	dstIP, dstPort := splitAddr(dst)
+	v4 := srcIP != nil && dstIP != nil && srcIP.To4() != nil && dstIP.To4() != nil
+	v6 := srcIP != nil && dstIP != nil && !v4 && srcIP.To4() == nil && dstIP.To4() == nil
+
+	switch version {
+	case 1:
+		switch {
+		case v4:
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", srcIP.To4(), dstIP.To4(), srcPort, dstPort)), nil
+		case v6:
+			return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", srcIP, dstIP, srcPort, dstPort)), nil
+		default:
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+
+	case 2:
+		buf := append([]byte(nil), signature...)
+		var addrs []byte
+		family := byte(0x00)
+		switch {
+		case v4:
+			family = 0x11
+			addrs = append(append(addrs, srcIP.To4()...), dstIP.To4()...)
+		case v6:
+			family = 0x21
+			addrs = append(append(addrs, srcIP.To16()...), dstIP.To16()...)
+		}
+		if addrs != nil {
+			addrs = binary.BigEndian.AppendUint16(addrs, uint16(srcPort))
+			addrs = binary.BigEndian.AppendUint16(addrs, uint16(dstPort))
+		}
+		buf = append(buf, 0x21, family) // Версия 2, команда PROXY
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(addrs)))
+		return append(buf, addrs...), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy protocol version %d", version)
+	}
+}
+
+func splitAddr(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	default:
+		return nil, 0
+	}
+}
+
+// ParseVersion разбирает версию из конфигурации: "v1", "v2" ("" — не отправлять)
+func ParseVersion(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "v1", "1":
+		return 1, nil
+	case "v2", "2":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("unknown proxy protocol version %q", s)
+	}
+}
@@ -0,0 +1,171 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options — приём заголовков PROXY protocol на листенере
+type Options struct {
+	Trusted  []*net.IPNet  // Адреса, от которых заголовок принимается; от остальных — игнорируется
+	Required bool          // Закрывать соединения доверенных адресов без заголовка
+	Timeout  time.Duration // Ожидание заголовка (5s)
+}
+
+// ParseCIDRs разбирает список сетей; отдельный адрес означает сеть из одного адреса
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Listener принимает соединения, начинающиеся с заголовка PROXY protocol
+type Listener struct {
+	net.Listener
+	opts Options
+}
+
+// NewListener оборачивает листенер. Заголовок читается не в Accept, а при
+// первом обращении к соединению, чтобы медленный клиент не задерживал остальных.
+func NewListener(inner net.Listener, opts Options) *Listener {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	return &Listener{Listener: inner, opts: opts}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c, trusted: l.trusted(c.RemoteAddr()), opts: &l.opts}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.opts.Trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn — соединение, адреса которого берутся из заголовка PROXY protocol
+type Conn struct {
+	net.Conn
+	trusted bool
+	opts    *Options
+
+	once   sync.Once
+	r      *bufio.Reader
+	header *Header
+	err    error
+}
+
+// init читает заголовок при первом обращении к соединению
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if !c.trusted {
+			return // Заголовок от недоверенного адреса не разбирается и дойдёт до приложения как есть
+		}
+		c.r = bufio.NewReader(c.Conn)
+		c.Conn.SetReadDeadline(time.Now().Add(c.opts.Timeout))
+		h, err := Read(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		switch {
+		case err == nil:
+			c.header = h
+		case errors.Is(err, ErrNoHeader) && !c.opts.Required:
+		case errors.Is(err, ErrNoHeader):
+			c.err = fmt.Errorf("%w from %s", err, c.Conn.RemoteAddr())
+		default:
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && !c.opts.Required {
+				return // Клиент ждёт данных от сервера — заголовка не было
+			}
+			c.err = fmt.Errorf("proxy protocol header from %s: %w", c.Conn.RemoteAddr(), err)
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.r != nil {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr возвращает адрес клиента из заголовка или адрес соединения
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr возвращает адрес назначения из заголовка или адрес соединения
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Header возвращает разобранный заголовок (nil — заголовка не было)
+func (c *Conn) Header() *Header {
+	c.init()
+	return c.header
+}
+
+// Err возвращает ошибку чтения заголовка; соединение с ошибкой уже закрыто
+func (c *Conn) Err() error {
+	c.init()
+	return c.err
+}
+
+// CloseWrite закрывает запись, если это поддерживает исходное соединение
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
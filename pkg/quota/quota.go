@@ -0,0 +1,349 @@
+package quota
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Period — календарное окно квоты
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// window возвращает идентификатор календарного окна (в UTC), в которое попадает t
+func (p Period) window(t time.Time) string {
+	t = t.UTC()
+	if p == Monthly {
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// resetsAt возвращает момент начала следующего окна
+func (p Period) resetsAt(t time.Time) time.Time {
+	t = t.UTC()
+	if p == Monthly {
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Limit — максимальное количество запросов за календарное окно
+type Limit struct {
+	Period Period
+	Max    int64
+}
+
+// Usage — использование квоты клиентом в текущем окне
+type Usage struct {
+	Period   Period    `json:"period"`
+	Window   string    `json:"window"`
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// record — строка журнала: абсолютное значение счётчика клиента в окне
+type record struct {
+	Client string `json:"client"`
+	Period Period `json:"period"`
+	Window string `json:"window"`
+	Used   int64  `json:"used"`
+}
+
+// counterKey — ключ счётчика: клиент, период и окно
+type counterKey struct {
+	client string
+	period Period
+	window string
+}
+
+// ErrQuotaExceeded возвращается, если клиент исчерпал квоту
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// compactMinRecords — сколько записей можно дописать в журнал до уплотнения,
+// если счётчиков немного (иначе порог — compactRatio записей на счётчик)
+const (
+	compactMinRecords = 256
+	compactRatio      = 4
+)
+
+// Manager считает использование долгосрочных квот (за день/месяц) по клиентам.
+// В отличие от токен-бакетов, счётчики переживают перезапуск: они периодически
+// дописываются в журнал (append-only файл), который перечитывается при старте.
+type Manager struct {
+	limits       []Limit            // Квоты по умолчанию
+	clientLimits map[string][]Limit // Индивидуальные квоты клиентов
+	path         string             // Путь к журналу ("" — без сохранения)
+	logger       *zap.SugaredLogger
+
+	mu       sync.Mutex
+	counters map[counterKey]int64
+	dirty    map[counterKey]bool // Счётчики, изменившиеся с последнего сброса на диск
+	file     *os.File
+	appended int // Записей, дописанных в журнал после последнего уплотнения
+	now      func() time.Time
+}
+
+// NewManager создаёт менеджер квот и восстанавливает счётчики из журнала path.
+// Журнал сразу уплотняется: в нём остаются только значения текущих окон.
+func NewManager(path string, limits []Limit, clientLimits map[string][]Limit, logger *zap.SugaredLogger) (*Manager, error) {
+	m := &Manager{
+		limits:       limits,
+		clientLimits: clientLimits,
+		path:         path,
+		logger:       logger,
+		counters:     make(map[counterKey]int64),
+		dirty:        make(map[counterKey]bool),
+		now:          time.Now,
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if err := m.compact(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// load перечитывает журнал; последние записи перекрывают предыдущие
+func (m *Manager) load() error {
+	f, err := os.Open(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := m.now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Недописанная строка после аварийного завершения — пропускаем
+			m.logger.Warnf("skipping corrupt quota record: %v", err)
+			continue
+		}
+		if rec.Window != rec.Period.window(now) {
+			continue // Окно уже закончилось
+		}
+		m.counters[counterKey{rec.Client, rec.Period, rec.Window}] = rec.Used
+	}
+	return scanner.Err()
+}
+
+// compact переписывает журнал текущими значениями счётчиков и открывает его на дозапись.
+// Вызывается из NewManager и из Flush под m.mu.
+func (m *Manager) compact() error {
+	tmp := m.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for key, used := range m.counters {
+		if err := enc.Encode(record{key.client, key.period, key.window, used}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+
+	// Старый дескриптор указывает на заменённый файл — дозапись идёт в новый
+	if m.file != nil {
+		m.file.Close()
+	}
+	m.file, err = os.OpenFile(m.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	m.appended = 0
+	return err
+}
+
+// limitsFor возвращает квоты клиента: индивидуальные или по умолчанию
+func (m *Manager) limitsFor(clientID string) []Limit {
+	if limits, ok := m.clientLimits[clientID]; ok {
+		return limits
+	}
+	return m.limits
+}
+
+// Allow проверяет все квоты клиента и, если ни одна не исчерпана, учитывает запрос.
+// При отказе возвращает исчерпанную квоту.
+func (m *Manager) Allow(clientID string) (Limit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	limits := m.limitsFor(clientID)
+	for _, l := range limits {
+		if m.counters[counterKey{clientID, l.Period, l.Period.window(now)}] >= l.Max {
+			return l, ErrQuotaExceeded
+		}
+	}
+	for _, l := range limits {
+		key := counterKey{clientID, l.Period, l.Period.window(now)}
+		m.counters[key]++
+		m.dirty[key] = true
+	}
+	return Limit{}, nil
+}
+
+// Usage возвращает использование квот клиентом в текущих окнах
+func (m *Manager) Usage(clientID string) []Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	usage := make([]Usage, 0, len(m.limitsFor(clientID)))
+	for _, l := range m.limitsFor(clientID) {
+		window := l.Period.window(now)
+		usage = append(usage, Usage{
+			Period:   l.Period,
+			Window:   window,
+			Used:     m.counters[counterKey{clientID, l.Period, window}],
+			Limit:    l.Max,
+			ResetsAt: l.Period.resetsAt(now),
+		})
+	}
+	return usage
+}
+
+// Clients возвращает клиентов, у которых есть использование в текущих окнах
+func (m *Manager) Clients() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	seen := make(map[string]bool)
+	var clients []string
+	for key := range m.counters {
+		if key.window == key.period.window(now) && !seen[key.client] {
+			seen[key.client] = true
+			clients = append(clients, key.client)
+		}
+	}
+	return clients
+}
+
+// Flush дописывает изменившиеся счётчики в журнал и забывает закончившиеся окна.
+// Когда окно закончилось или дозаписи разрослись, журнал переписывается целиком,
+// чтобы он не рос без ограничений у долго работающего процесса.
+func (m *Manager) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	expired := false
+	for key := range m.counters {
+		if key.window != key.period.window(now) {
+			delete(m.counters, key)
+			delete(m.dirty, key)
+			expired = true
+		}
+	}
+	if m.file == nil {
+		m.dirty = make(map[counterKey]bool)
+		return nil
+	}
+
+	if expired || m.appended+len(m.dirty) > max(compactMinRecords, compactRatio*len(m.counters)) {
+		if err := m.compact(); err != nil {
+			return err
+		}
+		m.dirty = make(map[counterKey]bool)
+		return nil
+	}
+
+	w := bufio.NewWriter(m.file)
+	enc := json.NewEncoder(w)
+	for key := range m.dirty {
+		if err := enc.Encode(record{key.client, key.period, key.window, m.counters[key]}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	m.appended += len(m.dirty)
+	m.dirty = make(map[counterKey]bool)
+	return m.file.Sync()
+}
+
+// Run периодически сбрасывает счётчики на диск, пока не закрыт stop
+func (m *Manager) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				m.logger.Errorf("quota flush failed: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close сбрасывает счётчики на диск и закрывает журнал
+func (m *Manager) Close() error {
+	err := m.Flush()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.file != nil {
+		if cerr := m.file.Close(); err == nil {
+			err = cerr
+		}
+		m.file = nil
+	}
+	return err
+}
+
+// Middleware отклоняет запросы клиентов, исчерпавших квоту, с кодом 429
+func Middleware(m *Manager, clientID func(*http.Request) string, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := clientID(r)
+
+			if limit, err := m.Allow(id); err != nil {
+				logger.Warnw("Quota exceeded", "client_ip", id, "period", limit.Period, "limit", limit.Max)
+
+				w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(limit.Period.resetsAt(time.Now())).Seconds())+1))
+				http.Error(w, fmt.Sprintf("Quota exceeded: %s limit of %d requests", limit.Period, limit.Max), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/Manzo48/loadBalancer/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrClientConcurrency — у клиента слишком много одновременных запросов
+	ErrClientConcurrency = errors.New("too many concurrent requests from client")
+	// ErrGlobalConcurrency — достигнут общий лимит одновременных запросов
+	ErrGlobalConcurrency = errors.New("too many concurrent requests")
+)
+
+var (
+	inflightGauge         = metrics.NewGauge("lb_inflight_requests")
+	clientRejectedCounter = metrics.NewCounter("lb_concurrency_rejected_total", "scope", "client")
+	globalRejectedCounter = metrics.NewCounter("lb_concurrency_rejected_total", "scope", "global")
+)
+
+// ConcurrencyLimiter ограничивает количество одновременно обрабатываемых запросов:
+// на одного клиента и в целом по балансировщику. В отличие от токен-бакета,
+// ограничивает не частоту, а параллелизм (например, долгие медленные запросы).
+type ConcurrencyLimiter struct {
+	maxPerClient int            // Максимум запросов в обработке на клиента (0 — без ограничения)
+	maxGlobal    int            // Общий максимум запросов в обработке (0 — без ограничения)
+	mu           sync.Mutex     // Мьютекс для счётчиков
+	clients      map[string]int // Запросы в обработке по клиентам
+	inflight     int            // Всего запросов в обработке
+}
+
+// NewConcurrencyLimiter создаёт лимитер параллелизма
+func NewConcurrencyLimiter(maxPerClient, maxGlobal int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		maxPerClient: maxPerClient,
+		maxGlobal:    maxGlobal,
+		clients:      make(map[string]int),
+	}
+}
+
+// Acquire занимает слот для запроса клиента. После обработки запроса
+// необходимо вызвать Release с тем же clientID.
+func (cl *ConcurrencyLimiter) Acquire(clientID string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.maxGlobal > 0 && cl.inflight >= cl.maxGlobal {
+		globalRejectedCounter.Inc()
+		return ErrGlobalConcurrency
+	}
+	if cl.maxPerClient > 0 && cl.clients[clientID] >= cl.maxPerClient {
+		clientRejectedCounter.Inc()
+		return ErrClientConcurrency
+	}
+
+	cl.clients[clientID]++
+	cl.inflight++
+	inflightGauge.Inc()
+	return nil
+}
+
+// Release освобождает слот, занятый Acquire
+func (cl *ConcurrencyLimiter) Release(clientID string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if n := cl.clients[clientID]; n <= 1 {
+		delete(cl.clients, clientID) // Не храним клиентов без активных запросов
+	} else {
+		cl.clients[clientID] = n - 1
+	}
+	cl.inflight--
+	inflightGauge.Dec()
+}
+
+// Inflight возвращает общее количество запросов в обработке
+func (cl *ConcurrencyLimiter) Inflight() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.inflight
+}
+
+type releaseKey struct{}
+
+// Detach досрочно освобождает слот запроса, не дожидаясь конца обработки. Нужен
+// долгоживущим соединениям (WebSocket после смены протокола): они больше не
+// запросы в обработке и не должны занимать лимит до закрытия.
+func (cl *ConcurrencyLimiter) Detach(r *http.Request) {
+	if release, ok := r.Context().Value(releaseKey{}).(func()); ok {
+		release()
+	}
+}
+
+// ConcurrencyMiddleware ограничивает параллелизм запросов. Превышение лимита клиента
+// возвращает 429, превышение общего лимита — 503.
+func ConcurrencyMiddleware(cl *ConcurrencyLimiter, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientID := extractClientIP(r)
+
+			if err := cl.Acquire(clientID); err != nil {
+				logger.Warnw("Concurrency limit exceeded", "client_ip", clientID, "error", err)
+
+				code := http.StatusServiceUnavailable
+				if errors.Is(err, ErrClientConcurrency) {
+					code = http.StatusTooManyRequests
+				}
+				http.Error(w, "Concurrency limit exceeded: "+err.Error(), code)
+				return
+			}
+			release := sync.OnceFunc(func() { cl.Release(clientID) })
+			defer release()
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), releaseKey{}, release)))
+		})
+	}
+}
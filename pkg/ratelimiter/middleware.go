@@ -3,31 +3,104 @@ package ratelimiter
 import (
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
-
+	"github.com/Manzo48/loadBalancer/pkg/metrics"
 	"go.uber.org/zap"
 )
 
+// ShadowHeader — заголовок ответа со списком теневых правил, которые отклонили бы запрос
+const ShadowHeader = "X-RateLimit-Shadow"
+
 func RateLimitMiddleware(rl *RateLimiter, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientID := extractClientIP(r)
+			clientID := rl.clientKey(r)
+
+			// По умолчанию запрос стоит один токен общего бакета клиента
+			limiter, cost := rl, 1
+			rule := rl.matchRule(r)
+			if rule != nil {
+				limiter, cost = rule.limiterFor(rl), rule.cost(r)
+			}
+
+			allowed := limiter.AllowN(clientID, cost)
 
-			if !rl.Allow(clientID) {
+			// Режим ожидания: задерживаем запрос, пока не появятся токены
+			var waitErr error
+			if !allowed && rule != nil && rule.MaxWait > 0 {
+				waitErr = limiter.WaitN(r.Context(), clientID, cost, rule.MaxWait, rule.MaxQueue)
+				allowed = waitErr == nil
+			}
+
+			if !allowed {
 				// Логируем превышение лимита
-				logger.Warnw("Rate limit exceeded", "client_ip", clientID)
+				logger.Warnw("Rate limit exceeded", "client_ip", clientID, "cost", cost, "wait_error", waitErr)
 
 				// Отправляем ошибку с кодом 429
 				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 				return
 			}
 
+			// Теневые правила: считаем и логируем несостоявшиеся отказы, но пропускаем запрос
+			for _, shadow := range rl.matchShadowRules(r) {
+				if shadow.limiter.AllowN(clientID, shadow.cost(r)) {
+					continue
+				}
+				metrics.NewCounter("lb_ratelimit_shadow_rejections_total", "rule", shadow.Name).Inc()
+				logger.Infow("Rate limit would be exceeded (shadow)", "client_ip", clientID, "rule", shadow.Name)
+				w.Header().Add(ShadowHeader, shadow.Name)
+			}
+
+			// Корректируем стоимость по заголовку ответа upstream (например, X-Cost)
+			if rule != nil && rule.CostHeader != "" {
+				w = &costWriter{
+					ResponseWriter: w,
+					onHeader: func(h http.Header) {
+						actual, err := strconv.Atoi(h.Get(rule.CostHeader))
+						if err != nil {
+							return
+						}
+						limiter.Charge(clientID, actual-cost)
+					},
+				}
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// costWriter перехватывает момент отправки заголовков ответа,
+// чтобы прочитать фактическую стоимость запроса до того, как они уйдут клиенту.
+type costWriter struct {
+	http.ResponseWriter
+	onHeader    func(http.Header)
+	wroteHeader bool
+}
+
+func (cw *costWriter) WriteHeader(code int) {
+	// Информационные ответы 1xx не несут итоговых заголовков
+	if !cw.wroteHeader && code >= http.StatusOK {
+		cw.wroteHeader = true
+		cw.onHeader(cw.ResponseWriter.Header())
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *costWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap позволяет http.ResponseController добраться до Flush/Hijack исходного writer'а
+func (cw *costWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
 func extractClientIP(r *http.Request) string {
 	ip := r.Header.Get("X-Real-IP")
 	if ip == "" {
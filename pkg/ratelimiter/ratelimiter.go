@@ -1,6 +1,9 @@
 package ratelimiter
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"sync"
 	"time"
 
@@ -16,8 +19,16 @@ type TokenBucket struct {
 	mu         sync.Mutex    // Мьютекс для потокобезопасного доступа
 	lastRefill time.Time     // Последнее время пополнения токенов
 	lastSeen   time.Time     // Последнее время активности клиента
+	waiters    int           // Количество запросов, ожидающих токены (см. WaitN)
 }
 
+var (
+	// ErrQueueFull возвращается WaitN, если очередь ожидания клиента переполнена
+	ErrQueueFull = errors.New("rate limit wait queue is full")
+	// ErrWaitTimeout возвращается WaitN, если токены не появятся за допустимое время ожидания
+	ErrWaitTimeout = errors.New("rate limit max wait exceeded")
+)
+
 // NewTokenBucket создает новый токен-бакет с заданной ёмкостью и скоростью пополнения
 func NewTokenBucket(capacity, refillRate int) *TokenBucket {
 	now := time.Now()
@@ -47,26 +58,119 @@ func (tb *TokenBucket) refill() {
 // Allow проверяет, есть ли доступный токен для клиента
 // Возвращает true, если токен доступен, иначе false
 func (tb *TokenBucket) Allow() bool {
+	return tb.AllowN(1)
+}
+
+// AllowN проверяет, есть ли в бакете n токенов, и списывает их разом.
+// Если токенов не хватает, бакет не изменяется и возвращается false.
+func (tb *TokenBucket) AllowN(n int) bool {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
+	return tb.take(n)
+}
+
+// take пытается списать n токенов. Вызывается под tb.mu.
+func (tb *TokenBucket) take(n int) bool {
 	tb.refill()                  // Пополняем токены
 	tb.lastSeen = time.Now()    // Обновляем время последней активности
 
-	if tb.Tokens > 0 {
-		tb.Tokens-- // Используем токен
+	if tb.Tokens >= n {
+		tb.Tokens -= n // Используем токены
 		return true
 	}
 	return false // Нет токенов — лимит превышен
 }
 
+// untilAvailable возвращает время, через которое в бакете накопится n токенов.
+// Вызывается под tb.mu; false — если n токенов не накопится никогда.
+func (tb *TokenBucket) untilAvailable(n int) (time.Duration, bool) {
+	if n > tb.Capacity || tb.RefillRate <= 0 {
+		return 0, false
+	}
+	deficit := n - tb.Tokens
+	need := time.Duration(float64(deficit) / float64(tb.RefillRate) * float64(time.Second))
+	return time.Until(tb.lastRefill.Add(need)), true
+}
+
+// WaitN ждёт, пока в бакете появятся n токенов, и списывает их.
+// Ожидание ограничено maxWait, отменой ctx и глубиной очереди maxQueue
+// (0 — без ограничения очереди).
+func (tb *TokenBucket) WaitN(ctx context.Context, n int, maxWait time.Duration, maxQueue int) error {
+	deadline := time.Now().Add(maxWait)
+
+	tb.mu.Lock()
+	if tb.take(n) {
+		tb.mu.Unlock()
+		return nil
+	}
+	if maxQueue > 0 && tb.waiters >= maxQueue {
+		tb.mu.Unlock()
+		return ErrQueueFull
+	}
+	tb.waiters++
+	tb.mu.Unlock()
+
+	defer func() {
+		tb.mu.Lock()
+		tb.waiters--
+		tb.mu.Unlock()
+	}()
+
+	for {
+		tb.mu.Lock()
+		if tb.take(n) {
+			tb.mu.Unlock()
+			return nil
+		}
+		delay, ok := tb.untilAvailable(n)
+		tb.mu.Unlock()
+
+		// Нет смысла ждать, если токены не успеют накопиться до дедлайна
+		if !ok || time.Now().Add(delay).After(deadline) {
+			return ErrWaitTimeout
+		}
+		if delay < time.Millisecond {
+			delay = time.Millisecond
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Charge безусловно списывает n токенов (или возвращает их при n < 0).
+// Используется для корректировки стоимости уже выполненного запроса:
+// бакет может уйти в "долг", но не глубже, чем на одну ёмкость.
+func (tb *TokenBucket) Charge(n int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.Tokens -= n
+	if tb.Tokens < -tb.Capacity {
+		tb.Tokens = -tb.Capacity
+	}
+	if tb.Tokens > tb.Capacity {
+		tb.Tokens = tb.Capacity
+	}
+}
+
 // RateLimiter управляет токен-бакетами для всех клиентов
 type RateLimiter struct {
-	buckets           map[string]*TokenBucket // Мапа токен-бакетов по IP/ClientID
-	mu                sync.RWMutex            // RW-мьютекс для безопасного доступа
+	buckets           *bucketStore            // Шардированное хранилище токен-бакетов по IP/ClientID
+	mu                sync.RWMutex            // RW-мьютекс для лимитов клиентов и правил
 	clientLimits      map[string]ClientLimit  // Индивидуальные лимиты для клиентов
 	defaultCapacity   int                     // Значение по умолчанию: ёмкость бакета
 	defaultRefillRate int                     // Значение по умолчанию: скорость пополнения
+	rules             []*Rule                 // Правила со стоимостью запросов (см. AddRule)
+	keyFunc           func(*http.Request) string // Ключ клиента в middleware (nil — IP клиента)
+	logger            *zap.SugaredLogger
 }
 
 // ClientLimit описывает лимит токен-бакета для конкретного клиента
@@ -78,11 +182,26 @@ type ClientLimit struct {
 // NewRateLimiter создает новый rate limiter с настройками по умолчанию
 func NewRateLimiter(capacity, refillRate int, logger *zap.SugaredLogger) *RateLimiter {
 	return &RateLimiter{
-		buckets:           make(map[string]*TokenBucket),
+		buckets:           newBucketStore(DefaultMaxBuckets),
 		clientLimits:      make(map[string]ClientLimit),
 		defaultCapacity:   capacity,
 		defaultRefillRate: refillRate,
+		logger:            logger,
+	}
+}
+
+// SetKeyFunc задаёт, по какому ключу RateLimitMiddleware различает клиентов,
+// например по идентичности клиентского сертификата вместо IP
+func (rl *RateLimiter) SetKeyFunc(fn func(*http.Request) string) {
+	rl.keyFunc = fn
+}
+
+// clientKey возвращает ключ клиента для запроса
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if rl.keyFunc != nil {
+		return rl.keyFunc(r)
 	}
+	return extractClientIP(r)
 }
 
 // SetClientLimit задаёт индивидуальный лимит для конкретного клиента
@@ -92,16 +211,31 @@ func (rl *RateLimiter) SetClientLimit(clientID string, limit ClientLimit) {
 	rl.clientLimits[clientID] = limit
 }
 
+// SetMaxBuckets ограничивает количество хранимых бакетов (в том числе у правил
+// с собственными лимитами). Вызывается до начала обработки запросов.
+func (rl *RateLimiter) SetMaxBuckets(n int) {
+	rl.buckets.setMax(n)
+
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	for _, rule := range rl.rules {
+		if rule.limiter != nil {
+			rule.limiter.SetMaxBuckets(n)
+		}
+	}
+}
+
+// Len возвращает количество хранимых бакетов
+func (rl *RateLimiter) Len() int {
+	return rl.buckets.len()
+}
+
 // getBucket возвращает токен-бакет для клиента.
 // Если он не существует — создаёт его с индивидуальным или дефолтным лимитом.
 func (rl *RateLimiter) getBucket(clientID string) *TokenBucket {
-	rl.mu.RLock()
-	bucket, exists := rl.buckets[clientID]
-	rl.mu.RUnlock()
-
-	if !exists {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
+	return rl.buckets.get(clientID, func() *TokenBucket {
+		rl.mu.RLock()
+		defer rl.mu.RUnlock()
 
 		// Проверяем, есть ли индивидуальный лимит
 		limit, exists := rl.clientLimits[clientID]
@@ -112,11 +246,9 @@ func (rl *RateLimiter) getBucket(clientID string) *TokenBucket {
 			}
 		}
 
-		// Создаём и сохраняем новый бакет
-		bucket = NewTokenBucket(limit.Capacity, limit.RefillRate)
-		rl.buckets[clientID] = bucket
-	}
-	return bucket
+		// Создаём новый бакет
+		return NewTokenBucket(limit.Capacity, limit.RefillRate)
+	})
 }
 
 // Allow проверяет, можно ли обслужить клиента с данным ID (IP, токен и т.п.)
@@ -125,19 +257,35 @@ func (rl *RateLimiter) Allow(clientID string) bool {
 	return bucket.Allow()
 }
 
+// AllowN проверяет, может ли клиент оплатить запрос стоимостью n токенов
+func (rl *RateLimiter) AllowN(clientID string, n int) bool {
+	return rl.getBucket(clientID).AllowN(n)
+}
+
+// WaitN ждёт, пока клиент сможет оплатить запрос стоимостью n токенов (см. TokenBucket.WaitN)
+func (rl *RateLimiter) WaitN(ctx context.Context, clientID string, n int, maxWait time.Duration, maxQueue int) error {
+	return rl.getBucket(clientID).WaitN(ctx, n, maxWait, maxQueue)
+}
+
+// Charge корректирует баланс клиента на n токенов после выполнения запроса
+func (rl *RateLimiter) Charge(clientID string, n int) {
+	if n == 0 {
+		return
+	}
+	rl.getBucket(clientID).Charge(n)
+}
+
 // Cleanup удаляет неактивные токен-бакеты, которые не использовались дольше заданного времени
 func (rl *RateLimiter) Cleanup(expiration time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+	rl.buckets.cleanup(expiration)
 
-	now := time.Now()
-	for clientID, bucket := range rl.buckets {
-		bucket.mu.Lock()
-		lastSeen := bucket.lastSeen
-		bucket.mu.Unlock()
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
 
-		if now.Sub(lastSeen) > expiration {
-			delete(rl.buckets, clientID)
+	// Правила с собственными лимитами хранят свои бакеты отдельно
+	for _, rule := range rl.rules {
+		if rule.limiter != nil {
+			rule.limiter.Cleanup(expiration)
 		}
 	}
 }
@@ -0,0 +1,141 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Rule описывает правило rate limiting для части запросов (по маршруту и методу).
+// Правило задаёт стоимость запроса в токенах и, при необходимости, собственный лимит.
+type Rule struct {
+	Name       string   // Имя правила (для логов)
+	PathPrefix string   // Префикс пути; пустой — любой путь
+	Methods    []string // HTTP-методы; пустой список — любой метод
+
+	Cost              int    // Базовая стоимость запроса в токенах (по умолчанию 1)
+	BodyBytesPerToken int64  // Дополнительный токен за каждые N байт тела запроса (0 — не учитывать)
+	CostHeader        string // Заголовок ответа upstream с фактической стоимостью (например, X-Cost)
+
+	Capacity   int // Собственная ёмкость бакета правила; 0 — общий бакет клиента
+	RefillRate int // Собственная скорость пополнения бакета правила
+
+	MaxWait  time.Duration // Если > 0, при нехватке токенов запрос ждёт вместо немедленного 429
+	MaxQueue int           // Максимум ожидающих запросов на клиента (0 — без ограничения)
+
+	Mode Mode // Режим правила: enforce (по умолчанию) или shadow
+
+	limiter *RateLimiter // Отдельный лимитер, если у правила свой лимит
+}
+
+// Mode — режим применения правила
+type Mode string
+
+const (
+	// ModeEnforce — превышение лимита отклоняется с кодом 429
+	ModeEnforce Mode = "enforce"
+	// ModeShadow — превышение лимита только логируется и учитывается в метриках,
+	// запрос проходит дальше. Нужен, чтобы оценить эффект нового лимита до включения.
+	ModeShadow Mode = "shadow"
+)
+
+// AddRule регистрирует правило. Правила проверяются в порядке добавления,
+// применяется первое подходящее. Теневые (shadow) правила проверяются
+// дополнительно ко всем остальным и всегда используют собственные бакеты,
+// чтобы не расходовать реальный бюджет клиента. Правило с неизвестным режимом
+// не добавляется: опечатка в режиме не должна молча превращать правило в другое.
+// Не добавляется и правило, базовая стоимость которого больше ёмкости бакета:
+// такой запрос не прошёл бы никогда.
+func (rl *RateLimiter) AddRule(rule Rule) error {
+	if rule.Cost <= 0 {
+		rule.Cost = 1
+	}
+	switch rule.Mode {
+	case "":
+		rule.Mode = ModeEnforce
+	case ModeEnforce, ModeShadow:
+	default:
+		return fmt.Errorf("rule %s: unknown mode %q (expected %s or %s)", rule.Name, rule.Mode, ModeEnforce, ModeShadow)
+	}
+	if rule.Mode == ModeShadow && rule.Capacity <= 0 {
+		rule.Capacity, rule.RefillRate = rl.defaultCapacity, rl.defaultRefillRate
+	}
+	capacity := rule.Capacity
+	if capacity <= 0 {
+		capacity = rl.defaultCapacity
+	}
+	if rule.Cost > capacity {
+		return fmt.Errorf("rule %s: cost %d exceeds bucket capacity %d", rule.Name, rule.Cost, capacity)
+	}
+	if rule.Capacity > 0 {
+		rule.limiter = NewRateLimiter(rule.Capacity, rule.RefillRate, rl.logger)
+		rule.limiter.buckets.maxPerShard = rl.buckets.maxPerShard
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rules = append(rl.rules, &rule)
+	return nil
+}
+
+// matchRule возвращает первое применяемое правило, подходящее под запрос, или nil
+func (rl *RateLimiter) matchRule(r *http.Request) *Rule {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	for _, rule := range rl.rules {
+		if rule.Mode != ModeShadow && rule.matches(r) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchShadowRules возвращает все теневые правила, подходящие под запрос
+func (rl *RateLimiter) matchShadowRules(r *http.Request) []*Rule {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	var matched []*Rule
+	for _, rule := range rl.rules {
+		if rule.Mode == ModeShadow && rule.matches(r) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// matches проверяет, попадает ли запрос под правило
+func (rule *Rule) matches(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+		return false
+	}
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, r.Method) {
+			return true
+		}
+	}
+	return false
+}
+
+// cost вычисляет стоимость запроса: базовая стоимость плюс надбавка за размер тела.
+// Если длина тела неизвестна (chunked), надбавка не начисляется.
+func (rule *Rule) cost(r *http.Request) int {
+	cost := rule.Cost
+	if rule.BodyBytesPerToken > 0 && r.ContentLength > 0 {
+		cost += int(r.ContentLength / rule.BodyBytesPerToken)
+	}
+	return cost
+}
+
+// limiterFor возвращает лимитер, из которого правило списывает токены
+func (rule *Rule) limiterFor(rl *RateLimiter) *RateLimiter {
+	if rule.limiter != nil {
+		return rule.limiter
+	}
+	return rl
+}
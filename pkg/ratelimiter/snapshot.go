@@ -0,0 +1,115 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// bucketState — состояние одного бакета в снимке
+type bucketState struct {
+	Rule       string    `json:"rule,omitempty"` // Имя правила с собственным лимитом ("" — общий лимитер)
+	Client     string    `json:"client"`
+	Tokens     int       `json:"tokens"`
+	LastRefill time.Time `json:"last_refill"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// snapshot — содержимое файла снимка
+type snapshot struct {
+	SavedAt time.Time     `json:"saved_at"`
+	Buckets []bucketState `json:"buckets"`
+}
+
+// SaveSnapshot сохраняет состояние всех бакетов (включая бакеты правил) в файл.
+// Файл записывается атомарно через временный файл.
+func (rl *RateLimiter) SaveSnapshot(path string) error {
+	snap := snapshot{SavedAt: time.Now()}
+	rl.collect("", &snap)
+
+	rl.mu.RLock()
+	for _, rule := range rl.rules {
+		if rule.limiter != nil {
+			rule.limiter.collect(rule.Name, &snap)
+		}
+	}
+	rl.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// collect добавляет в снимок бакеты лимитера
+func (rl *RateLimiter) collect(rule string, snap *snapshot) {
+	rl.buckets.each(func(key string, bucket *TokenBucket) {
+		bucket.mu.Lock()
+		snap.Buckets = append(snap.Buckets, bucketState{
+			Rule:       rule,
+			Client:     key,
+			Tokens:     bucket.Tokens,
+			LastRefill: bucket.lastRefill,
+			LastSeen:   bucket.lastSeen,
+		})
+		bucket.mu.Unlock()
+	})
+}
+
+// LoadSnapshot восстанавливает бакеты из файла, сохранённого SaveSnapshot.
+// Бакеты клиентов, неактивных дольше maxAge, отбрасываются. Ёмкость и скорость
+// пополнения берутся из текущей конфигурации; токены, накопившиеся за время
+// простоя, добавятся при первом обращении. Возвращает число восстановленных бакетов;
+// отсутствие файла ошибкой не считается.
+func (rl *RateLimiter) LoadSnapshot(path string, maxAge time.Duration) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, err
+	}
+
+	rl.mu.RLock()
+	limiters := map[string]*RateLimiter{"": rl}
+	for _, rule := range rl.rules {
+		if rule.limiter != nil {
+			limiters[rule.Name] = rule.limiter
+		}
+	}
+	rl.mu.RUnlock()
+
+	restored := 0
+	for _, state := range snap.Buckets {
+		target, ok := limiters[state.Rule]
+		if !ok || time.Since(state.LastSeen) > maxAge {
+			continue // Правило удалено из конфигурации или клиент давно неактивен
+		}
+		target.restore(state)
+		restored++
+	}
+	return restored, nil
+}
+
+// restore создаёт бакет клиента из сохранённого состояния
+func (rl *RateLimiter) restore(state bucketState) {
+	bucket := rl.getBucket(state.Client)
+
+	bucket.mu.Lock()
+	bucket.Tokens = min(state.Tokens, bucket.Capacity)
+	bucket.lastRefill = state.LastRefill
+	bucket.lastSeen = state.LastSeen
+	bucket.mu.Unlock()
+
+	rl.buckets.put(state.Client, bucket, state.LastSeen)
+}
@@ -0,0 +1,160 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Manzo48/loadBalancer/pkg/metrics"
+)
+
+// shardCount — количество шардов хранилища бакетов. Каждый шард защищён своим
+// мьютексом, поэтому запросы разных клиентов почти не конкурируют за блокировку.
+const shardCount = 64
+
+// DefaultMaxBuckets — ограничение количества бакетов по умолчанию. Защищает память
+// при флуде с подменой IP: сверх лимита вытесняются давно не активные клиенты.
+const DefaultMaxBuckets = 1 << 20
+
+var evictionsCounter = metrics.NewCounter("lb_ratelimit_bucket_evictions_total")
+
+// bucketEntry — элемент LRU-списка шарда
+type bucketEntry struct {
+	key        string
+	bucket     *TokenBucket
+	lastAccess time.Time // Последнее обращение к бакету (под мьютексом шарда)
+}
+
+// bucketShard хранит часть бакетов в порядке последнего использования:
+// в начале списка — самые свежие, в конце — кандидаты на вытеснение.
+type bucketShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// bucketStore — шардированное хранилище бакетов с ограничением размера и LRU-вытеснением
+type bucketStore struct {
+	shards      [shardCount]bucketShard
+	maxPerShard int
+}
+
+func newBucketStore(maxEntries int) *bucketStore {
+	s := &bucketStore{}
+	s.setMax(maxEntries)
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*list.Element)
+		s.shards[i].lru = list.New()
+	}
+	return s
+}
+
+// setMax задаёт общий лимит бакетов; он равномерно делится между шардами
+func (s *bucketStore) setMax(maxEntries int) {
+	per := maxEntries / shardCount
+	if per < 1 {
+		per = 1
+	}
+	s.maxPerShard = per
+}
+
+// shard выбирает шард по FNV-1a хэшу ключа
+func (s *bucketStore) shard(key string) *bucketShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &s.shards[h%shardCount]
+}
+
+// get возвращает бакет клиента, создавая его через create при отсутствии.
+// Если шард переполнен, вытесняется наименее недавно использованный бакет.
+func (s *bucketStore) get(key string, create func() *TokenBucket) *TokenBucket {
+	sh := s.shard(key)
+	now := time.Now()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if el, ok := sh.entries[key]; ok {
+		entry := el.Value.(*bucketEntry)
+		entry.lastAccess = now
+		sh.lru.MoveToFront(el)
+		return entry.bucket
+	}
+
+	for sh.lru.Len() >= s.maxPerShard {
+		oldest := sh.lru.Back()
+		sh.lru.Remove(oldest)
+		delete(sh.entries, oldest.Value.(*bucketEntry).key)
+		evictionsCounter.Inc()
+	}
+
+	bucket := create()
+	sh.entries[key] = sh.lru.PushFront(&bucketEntry{key: key, bucket: bucket, lastAccess: now})
+	return bucket
+}
+
+// put сохраняет готовый бакет (например, восстановленный из снимка), заменяя существующий
+func (s *bucketStore) put(key string, bucket *TokenBucket, lastAccess time.Time) {
+	sh := s.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if el, ok := sh.entries[key]; ok {
+		sh.lru.Remove(el)
+		delete(sh.entries, key)
+	}
+	if sh.lru.Len() >= s.maxPerShard {
+		return // Шард заполнен — живые клиенты важнее восстановленных
+	}
+	// Восстановленные бакеты старше текущих, поэтому идут в конец LRU-списка
+	sh.entries[key] = sh.lru.PushBack(&bucketEntry{key: key, bucket: bucket, lastAccess: lastAccess})
+}
+
+// each вызывает fn для каждого бакета; шарды блокируются по очереди
+func (s *bucketStore) each(fn func(key string, bucket *TokenBucket)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for el := sh.lru.Front(); el != nil; el = el.Next() {
+			entry := el.Value.(*bucketEntry)
+			fn(entry.key, entry.bucket)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// cleanup удаляет бакеты, к которым не обращались дольше expiration.
+// Шарды обходятся по очереди, а внутри шарда — только "хвост" LRU-списка,
+// поэтому очистка не блокирует всё хранилище и не трогает активных клиентов.
+func (s *bucketStore) cleanup(expiration time.Duration) {
+	deadline := time.Now().Add(-expiration)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for el := sh.lru.Back(); el != nil; el = sh.lru.Back() {
+			entry := el.Value.(*bucketEntry)
+			if entry.lastAccess.After(deadline) {
+				break
+			}
+			sh.lru.Remove(el)
+			delete(sh.entries, entry.key)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// len возвращает общее количество бакетов
+func (s *bucketStore) len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += sh.lru.Len()
+		sh.mu.Unlock()
+	}
+	return n
+}
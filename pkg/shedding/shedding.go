@@ -0,0 +1,251 @@
+package shedding
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Manzo48/loadBalancer/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// Priority — класс приоритета запроса. Меньшее значение — более важный запрос.
+type Priority int
+
+const (
+	Critical Priority = iota
+	High
+	Normal
+	Low
+)
+
+var priorityNames = map[Priority]string{
+	Critical: "critical",
+	High:     "high",
+	Normal:   "normal",
+	Low:      "low",
+}
+
+func (p Priority) String() string {
+	return priorityNames[p]
+}
+
+// ParsePriority разбирает имя класса приоритета (critical, high, normal, low)
+func ParsePriority(s string) (Priority, bool) {
+	for p, name := range priorityNames {
+		if strings.EqualFold(name, strings.TrimSpace(s)) {
+			return p, true
+		}
+	}
+	return Normal, false
+}
+
+// RoutePriority назначает класс приоритета запросам с указанным префиксом пути
+type RoutePriority struct {
+	PathPrefix string
+	Priority   Priority
+}
+
+// Options — настройки сброса нагрузки
+type Options struct {
+	Header       string              // Заголовок с явным классом приоритета (например, X-Priority)
+	TrustedNets  []*net.IPNet        // Доверенные прокси: Header принимается только от них
+	APIKeyHeader string              // Заголовок с API-ключом клиента
+	APIKeys      map[string]Priority // Классы приоритета по API-ключам (тарифам)
+	Routes       []RoutePriority     // Классы приоритета по маршрутам
+	Default      Priority            // Класс приоритета по умолчанию
+
+	MaxInflight     int                  // Запросов в обработке, соответствующих 100% нагрузки (0 — не учитывать)
+	MaxQueueLatency time.Duration        // Задержка в очереди, соответствующая 100% нагрузки (0 — не учитывать)
+	Thresholds      map[Priority]float64 // Доля нагрузки, начиная с которой класс сбрасывается
+	Status          int                  // HTTP-статус для сброшенных запросов
+	ExemptPaths     []string             // Префиксы путей, которые никогда не сбрасываются (health-check)
+}
+
+// defaultThresholds — пороги нагрузки по умолчанию: чем ниже приоритет, тем раньше сброс
+var defaultThresholds = map[Priority]float64{
+	Critical: 1.0,
+	High:     0.95,
+	Normal:   0.8,
+	Low:      0.6,
+}
+
+// Shedder при перегрузке отклоняет запросы, начиная с наименее важных классов.
+// Нагрузка оценивается по числу запросов в обработке и по задержке в очереди
+// (время от поступления запроса до отправки на backend).
+type Shedder struct {
+	opts Options
+
+	inflight atomic.Int64 // Запросов в обработке
+
+	mu           sync.Mutex
+	queueLatency float64   // Скользящее среднее задержки в очереди, в секундах
+	updated      time.Time // Время последнего замера задержки
+}
+
+// queueLatencyAlpha — вес нового замера в скользящем среднем задержки очереди
+const queueLatencyAlpha = 0.1
+
+// NewShedder создаёт Shedder, подставляя значения по умолчанию
+func NewShedder(opts Options) *Shedder {
+	if opts.Status == 0 {
+		opts.Status = http.StatusServiceUnavailable
+	}
+	if opts.APIKeyHeader == "" {
+		opts.APIKeyHeader = "X-API-Key"
+	}
+	if opts.ExemptPaths == nil {
+		opts.ExemptPaths = []string{"/health"}
+	}
+	thresholds := make(map[Priority]float64, len(defaultThresholds))
+	for p, t := range defaultThresholds {
+		thresholds[p] = t
+	}
+	for p, t := range opts.Thresholds {
+		thresholds[p] = t
+	}
+	opts.Thresholds = thresholds
+
+	for p := range priorityNames {
+		metrics.Default.Counter("lb_shed_requests_total", "priority", p.String())
+	}
+	return &Shedder{opts: opts}
+}
+
+// Classify определяет класс приоритета запроса: явный заголовок от доверенного
+// прокси, затем тариф API-ключа, затем маршрут, иначе — класс по умолчанию
+func (s *Shedder) Classify(r *http.Request) Priority {
+	if s.opts.Header != "" && s.trustedPeer(r) {
+		if p, ok := ParsePriority(r.Header.Get(s.opts.Header)); ok {
+			return p
+		}
+	}
+	if key := r.Header.Get(s.opts.APIKeyHeader); key != "" {
+		if p, ok := s.opts.APIKeys[key]; ok {
+			return p
+		}
+	}
+	for _, route := range s.opts.Routes {
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			return route.Priority
+		}
+	}
+	return s.opts.Default
+}
+
+// trustedPeer проверяет, что запрос пришёл напрямую от доверенного прокси.
+// Заголовку от любого другого адреса верить нельзя: клиент выставил бы себе critical.
+func (s *Shedder) trustedPeer(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.opts.TrustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load возвращает текущую нагрузку как долю от настроенных пределов (1.0 — 100%)
+func (s *Shedder) Load() float64 {
+	var load float64
+	if s.opts.MaxInflight > 0 {
+		load = float64(s.inflight.Load()) / float64(s.opts.MaxInflight)
+	}
+	if s.opts.MaxQueueLatency > 0 {
+		// Без новых замеров среднее затухает, иначе при сбросе всех запросов
+		// задержка "застынет" и перегрузка никогда не закончится
+		s.mu.Lock()
+		latency := s.queueLatency * math.Exp(-time.Since(s.updated).Seconds())
+		s.mu.Unlock()
+		load = math.Max(load, latency/s.opts.MaxQueueLatency.Seconds())
+	}
+	return load
+}
+
+// shouldShed решает, сбрасывать ли запрос указанного класса при текущей нагрузке
+func (s *Shedder) shouldShed(p Priority) bool {
+	return s.Load() >= s.opts.Thresholds[p]
+}
+
+// exempt проверяет, что запрос не подлежит сбросу (например, health-check)
+func (s *Shedder) exempt(r *http.Request) bool {
+	for _, prefix := range s.opts.ExemptPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type arrivalKey struct{}
+
+type releaseKey struct{}
+
+// Dispatched отмечает, что запрос покинул очередь и отправляется на backend.
+// Время с момента поступления запроса учитывается в задержке очереди.
+func (s *Shedder) Dispatched(r *http.Request) {
+	arrival, ok := r.Context().Value(arrivalKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	sample := time.Since(arrival).Seconds()
+
+	s.mu.Lock()
+	s.queueLatency += queueLatencyAlpha * (sample - s.queueLatency)
+	s.updated = time.Now()
+	s.mu.Unlock()
+}
+
+// Detach перестаёт учитывать запрос в обработке, не дожидаясь её конца: соединение
+// после смены протокола может жить часами и не говорит о загрузке балансировщика
+func (s *Shedder) Detach(r *http.Request) {
+	if release, ok := r.Context().Value(releaseKey{}).(func()); ok {
+		release()
+	}
+}
+
+// Middleware сбрасывает низкоприоритетные запросы при перегрузке. Должен быть
+// внешним middleware, чтобы учитывать время, проведённое запросом в очередях.
+// Админский API обслуживается отдельным сервером и через Shedder не проходит.
+func (s *Shedder) Middleware(logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			priority := s.Classify(r)
+			if s.opts.Header != "" && !s.trustedPeer(r) {
+				// Чужой заголовок приоритета не должен дойти и до backend'ов
+				r.Header.Del(s.opts.Header)
+			}
+			if s.shouldShed(priority) {
+				metrics.Default.Counter("lb_shed_requests_total", "priority", priority.String()).Inc()
+				logger.Warnw("Request shed due to overload", "priority", priority.String(), "load", s.Load(), "path", r.URL.Path)
+				http.Error(w, "Server overloaded, request shed", s.opts.Status)
+				return
+			}
+
+			s.inflight.Add(1)
+			release := sync.OnceFunc(func() { s.inflight.Add(-1) })
+			defer release()
+
+			ctx := context.WithValue(r.Context(), arrivalKey{}, time.Now())
+			ctx = context.WithValue(ctx, releaseKey{}, release)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
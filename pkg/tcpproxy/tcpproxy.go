@@ -0,0 +1,368 @@
+// Package tcpproxy реализует L4-прокси: входящие TCP-соединения распределяются
+// между backend'ами стратегиями пакета balancer, данные копируются без разбора протокола.
+package tcpproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Manzo48/loadBalancer/pkg/balancer"
+	"github.com/Manzo48/loadBalancer/pkg/metrics"
+	"github.com/Manzo48/loadBalancer/pkg/proxyproto"
+	"go.uber.org/zap"
+)
+
+// Options — настройки TCP-листенера
+type Options struct {
+	Name           string               // Имя листенера для логов и метрик
+	Backends       []string             // Адреса backend'ов: host:port или tcp://host:port
+	Strategy       string               // Стратегия балансировки (round_robin по умолчанию)
+	MaxPerBackend  int                  // Одновременных соединений на backend (0 — без ограничения)
+	IdleTimeout    time.Duration        // Закрывать соединение без трафика дольше (10m)
+	ConnectTimeout time.Duration        // Таймаут подключения к backend'у (5s)
+	HealthCheck    balancer.HealthCheck // Interval и Timeout проверок; проверка — установка TCP-соединения
+	SendProxyProto int                  // Версия заголовка PROXY protocol для backend'ов (0 — не отправлять)
+}
+
+// Proxy — TCP-листенер с балансировкой соединений
+type Proxy struct {
+	name           string
+	balancer       balancer.Balancer
+	idle           time.Duration
+	connectTimeout time.Duration
+	sendProxyProto int
+	logger         *zap.SugaredLogger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	wg       sync.WaitGroup
+	closing  atomic.Bool
+
+	accepted  *metrics.Counter
+	rejected  *metrics.Counter
+	dialFails *metrics.Counter
+}
+
+// New создаёт TCP-прокси и запускает проверки состояния backend'ов
+func New(opts Options, logger *zap.SugaredLogger) (*Proxy, error) {
+	if len(opts.Backends) == 0 {
+		return nil, errors.New("no backends")
+	}
+	urls := make([]string, len(opts.Backends))
+	for i, addr := range opts.Backends {
+		if !strings.Contains(addr, "://") {
+			addr = "tcp://" + addr
+		}
+		urls[i] = addr
+	}
+
+	health := opts.HealthCheck
+	health.TCP = true
+	logger = logger.With("tcp_listener", opts.Name)
+	b, err := balancer.New(opts.Strategy, urls, health, logger)
+	if err != nil {
+		return nil, err
+	}
+	b.SetMaxConnsPerBackend(opts.MaxPerBackend)
+
+	p := &Proxy{
+		name:           opts.Name,
+		balancer:       b,
+		idle:           opts.IdleTimeout,
+		connectTimeout: opts.ConnectTimeout,
+		sendProxyProto: opts.SendProxyProto,
+		logger:         logger,
+		conns:          make(map[*conn]struct{}),
+		accepted:       metrics.NewCounter("lb_tcp_connections_total", "listener", opts.Name, "result", "accepted"),
+		rejected:       metrics.NewCounter("lb_tcp_connections_total", "listener", opts.Name, "result", "no_backend"),
+		dialFails:      metrics.NewCounter("lb_tcp_connections_total", "listener", opts.Name, "result", "dial_error"),
+	}
+	if p.idle <= 0 {
+		p.idle = 10 * time.Minute
+	}
+	if p.connectTimeout <= 0 {
+		p.connectTimeout = 5 * time.Second
+	}
+
+	metrics.Default.GaugeFunc("lb_tcp_active_connections", func() float64 {
+		return float64(p.ActiveConnections())
+	}, "listener", opts.Name)
+	for _, be := range b.Backends() {
+		be := be
+		metrics.Default.GaugeFunc("lb_tcp_backend_connections", func() float64 {
+			return float64(be.ActiveRequests())
+		}, "listener", opts.Name, "backend", be.URL.Host)
+	}
+	return p, nil
+}
+
+// Balancer возвращает балансировщик backend'ов листенера
+func (p *Proxy) Balancer() balancer.Balancer {
+	return p.balancer
+}
+
+// Serve принимает соединения, пока листенер не закрыт. После Shutdown возвращает nil.
+func (p *Proxy) Serve(ln net.Listener) error {
+	p.mu.Lock()
+	if p.closing.Load() {
+		p.mu.Unlock()
+		return ln.Close() // Shutdown вызван раньше, чем листенер начал работу
+	}
+	p.listener = ln
+	p.mu.Unlock()
+	p.logger.Infof("tcp listener %s on %s", p.name, ln.Addr())
+
+	var backoff time.Duration
+	for {
+		client, err := ln.Accept()
+		if err != nil {
+			if p.closing.Load() {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Любая другая ошибка (например, EMFILE при нехватке дескрипторов) считается
+			// временной, как в net/http: пауза и повтор, чтобы листенер не остановился навсегда
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			p.logger.Warnf("accept error: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.handle(client)
+		}()
+	}
+}
+
+// handle подключает клиента к backend'у и передаёт данные до закрытия одной из сторон
+func (p *Proxy) handle(client net.Conn) {
+	// Ошибочный заголовок PROXY protocol: соединение уже закрыто листенером
+	if pc, ok := client.(interface{ Err() error }); ok && pc.Err() != nil {
+		p.logger.Warnf("rejected connection: %v", pc.Err())
+		client.Close()
+		return
+	}
+	clientIP, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+
+	// Недоступный backend помечается мёртвым, и соединение пробует следующий
+	for attempt := 0; attempt < len(p.balancer.Backends()); attempt++ {
+		backend, err := p.balancer.NextBackend(clientIP)
+		if err != nil {
+			p.rejected.Inc()
+			p.logger.Warnf("no backend for %s: %v", clientIP, err)
+			client.Close()
+			return
+		}
+
+		upstream, err := net.DialTimeout("tcp", backend.URL.Host, p.connectTimeout)
+		if err != nil {
+			backend.Release()
+			p.dialFails.Inc()
+			p.logger.Errorf("dial backend %s: %v", backend.URL.Host, err)
+			p.balancer.MarkBackendDead(backend.URL)
+			continue
+		}
+
+		// Адрес клиента для backend'а: заголовок отправляется до данных клиента
+		if p.sendProxyProto != 0 {
+			if err := p.writeProxyHeader(upstream, client); err != nil {
+				p.logger.Errorf("send proxy protocol header to %s: %v", backend.URL.Host, err)
+				upstream.Close()
+				backend.Release()
+				client.Close()
+				return
+			}
+		}
+
+		p.accepted.Inc()
+		p.logger.Debugf("tcp %s → %s", client.RemoteAddr(), backend.URL.Host)
+		c := &conn{backend: backend, client: client, upstream: upstream, idle: p.idle, done: make(chan struct{})}
+		if !p.track(c) {
+			c.close() // Shutdown начался, пока подключались к backend'у
+		} else {
+			c.serve()
+			p.untrack(c)
+		}
+		close(c.done)
+		backend.Release()
+		return
+	}
+	p.rejected.Inc()
+	client.Close()
+}
+
+// writeProxyHeader отправляет backend'у заголовок PROXY protocol с адресами клиента
+func (p *Proxy) writeProxyHeader(upstream, client net.Conn) error {
+	header, err := proxyproto.Format(p.sendProxyProto, client.RemoteAddr(), client.LocalAddr())
+	if err != nil {
+		return err
+	}
+	_, err = upstream.Write(header)
+	return err
+}
+
+func (p *Proxy) track(c *conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closing.Load() {
+		return false
+	}
+	p.conns[c] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(c *conn) {
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+}
+
+// ActiveConnections возвращает количество проксируемых соединений
+func (p *Proxy) ActiveConnections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Shutdown перестаёт принимать соединения и ждёт завершения текущих не дольше
+// grace, после чего закрывает оставшиеся принудительно
+func (p *Proxy) Shutdown(grace time.Duration) {
+	p.mu.Lock()
+	p.closing.Store(true)
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	conns := make([]*conn, 0, len(p.conns))
+	for c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.mu.Unlock()
+
+	if len(conns) > 0 {
+		p.logger.Infof("draining %d tcp connections (grace %v)", len(conns), grace)
+	}
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	for i, c := range conns {
+		select {
+		case <-c.done:
+		case <-deadline.C:
+			p.logger.Warnf("closing %d tcp connections after grace period", len(conns)-i)
+			for _, rest := range conns[i:] {
+				rest.close()
+			}
+			p.wg.Wait()
+			return
+		}
+	}
+	p.wg.Wait()
+}
+
+// CloseDraining даёт соединениям с backend'ом b grace на самостоятельное завершение,
+// после чего закрывает оставшиеся, если backend всё ещё выводится из ротации
+func (p *Proxy) CloseDraining(b *balancer.Backend, grace time.Duration) {
+	p.mu.Lock()
+	var conns []*conn
+	for c := range p.conns {
+		if c.backend == b {
+			conns = append(conns, c)
+		}
+	}
+	p.mu.Unlock()
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	for i, c := range conns {
+		select {
+		case <-c.done:
+		case <-deadline.C:
+			if !b.Draining() {
+				return // Backend вернули в ротацию
+			}
+			p.logger.Infof("closing %d tcp connections to draining backend %s", len(conns)-i, b.URL.Host)
+			for _, rest := range conns[i:] {
+				rest.close()
+			}
+			return
+		}
+	}
+}
+
+// conn — пара соединений клиент ↔ backend
+type conn struct {
+	backend    *balancer.Backend
+	client     net.Conn
+	upstream   net.Conn
+	idle       time.Duration
+	lastActive atomic.Int64  // Время последнего трафика в любую сторону, UnixNano
+	done       chan struct{} // Закрывается, когда соединение завершено
+}
+
+func (c *conn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *conn) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActive.Load()))
+}
+
+func (c *conn) close() {
+	c.client.Close()
+	c.upstream.Close()
+}
+
+// pipe копирует данные из src в dst. Соединение считается простаивающим, только
+// если трафика не было в обе стороны дольше idle. По концу данных от src
+// закрывается запись в dst (half-close), чтобы протоколы с ним работали.
+func (c *conn) pipe(dst, src net.Conn) error {
+	buf := make([]byte, 32<<10)
+	for {
+		src.SetReadDeadline(time.Now().Add(c.idle))
+		n, err := src.Read(buf)
+		if n > 0 {
+			c.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+				return nil
+			}
+			return err
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && c.idleFor() < c.idle {
+				continue // Трафик шёл в обратную сторону — соединение живо
+			}
+			return err
+		}
+	}
+}
+
+// serve передаёт данные в обе стороны. Соединение завершается, когда обе стороны
+// закончили передачу, или сразу при ошибке одной из них.
+func (c *conn) serve() {
+	c.touch()
+	errc := make(chan error, 2)
+	go func() { errc <- c.pipe(c.upstream, c.client) }()
+	go func() { errc <- c.pipe(c.client, c.upstream) }()
+	if err := <-errc; err != nil {
+		c.close() // Разблокирует вторую горутину
+	}
+	<-errc
+	c.close()
+}
@@ -0,0 +1,393 @@
+// Package udpproxy реализует балансировку UDP: датаграммы клиента закрепляются
+// за backend'ом на время сессии, ответы backend'а возвращаются клиенту с адреса листенера.
+package udpproxy
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Manzo48/loadBalancer/pkg/balancer"
+	"github.com/Manzo48/loadBalancer/pkg/metrics"
+	"github.com/Manzo48/loadBalancer/pkg/ratelimiter"
+	"go.uber.org/zap"
+)
+
+// maxDatagram — максимальный размер датаграммы UDP
+const maxDatagram = 64 << 10
+
+// limiterTTL — время хранения бакета клиента без датаграмм
+const limiterTTL = 5 * time.Minute
+
+// defaultMaxSessions — лимит сессий листенера по умолчанию. Каждая сессия держит
+// сокет и горутину, а адрес источника UDP легко подделать.
+const defaultMaxSessions = 10000
+
+// errTooManySessions — новая сессия отклонена: достигнут лимит сессий листенера
+var errTooManySessions = errors.New("too many udp sessions")
+
+// Options — настройки UDP-листенера
+type Options struct {
+	Name           string                   // Имя листенера для логов и метрик
+	Backends       []string                 // Адреса backend'ов: host:port или udp://host:port
+	Strategy       string                   // Стратегия балансировки (round_robin по умолчанию)
+	MaxPerBackend  int                      // Сессий на backend (0 — без ограничения)
+	MaxSessions    int                      // Сессий на листенер; датаграммы новых клиентов сверх лимита отбрасываются (10000)
+	SessionTimeout time.Duration            // Сессия без датаграмм в обе стороны удаляется (30s)
+	HealthCheck    balancer.HealthCheck     // Проверка TCP-соединением на тот же адрес или Disabled
+	RateLimiter    *ratelimiter.RateLimiter // Лимит датаграмм от клиента (nil — без ограничения)
+}
+
+// Proxy — UDP-листенер с балансировкой сессий клиентов
+type Proxy struct {
+	name     string
+	balancer balancer.Balancer
+	timeout  time.Duration
+	maxSess  int
+	limiter  *ratelimiter.RateLimiter
+	passive  bool // Отмечать backend мёртвым по ICMP port unreachable (есть health-check для восстановления)
+	logger   *zap.SugaredLogger
+
+	mu       sync.Mutex
+	conn     *net.UDPConn
+	sessions map[string]*session // Сессии по адресу клиента
+	closing  atomic.Bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	forwarded   *metrics.Counter
+	rateLimited *metrics.Counter
+	noBackend   *metrics.Counter
+	overLimit   *metrics.Counter
+	replies     *metrics.Counter
+}
+
+// session — привязка адреса клиента к backend'у
+type session struct {
+	client     *net.UDPAddr
+	backend    *balancer.Backend
+	upstream   *net.UDPConn  // Соединённый с backend'ом сокет: ответы приходят только от него
+	lastActive atomic.Int64  // Время последней датаграммы в любую сторону, UnixNano
+	closed     chan struct{} // Закрывается при удалении сессии
+	once       sync.Once
+}
+
+func (s *session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *session) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+// New создаёт UDP-прокси и запускает проверки состояния backend'ов
+func New(opts Options, logger *zap.SugaredLogger) (*Proxy, error) {
+	if len(opts.Backends) == 0 {
+		return nil, errors.New("no backends")
+	}
+	urls := make([]string, len(opts.Backends))
+	for i, addr := range opts.Backends {
+		if !strings.Contains(addr, "://") {
+			addr = "udp://" + addr
+		}
+		urls[i] = addr
+	}
+
+	health := opts.HealthCheck
+	health.TCP = true
+	logger = logger.With("udp_listener", opts.Name)
+	b, err := balancer.New(opts.Strategy, urls, health, logger)
+	if err != nil {
+		return nil, err
+	}
+	b.SetMaxConnsPerBackend(opts.MaxPerBackend)
+
+	p := &Proxy{
+		name:        opts.Name,
+		balancer:    b,
+		timeout:     opts.SessionTimeout,
+		maxSess:     opts.MaxSessions,
+		limiter:     opts.RateLimiter,
+		passive:     !health.Disabled,
+		logger:      logger,
+		sessions:    make(map[string]*session),
+		stop:        make(chan struct{}),
+		forwarded:   metrics.NewCounter("lb_udp_datagrams_total", "listener", opts.Name, "result", "forwarded"),
+		rateLimited: metrics.NewCounter("lb_udp_datagrams_total", "listener", opts.Name, "result", "rate_limited"),
+		noBackend:   metrics.NewCounter("lb_udp_datagrams_total", "listener", opts.Name, "result", "no_backend"),
+		overLimit:   metrics.NewCounter("lb_udp_datagrams_total", "listener", opts.Name, "result", "session_limit"),
+		replies:     metrics.NewCounter("lb_udp_datagrams_total", "listener", opts.Name, "result", "reply"),
+	}
+	if p.timeout <= 0 {
+		p.timeout = 30 * time.Second
+	}
+	if p.maxSess <= 0 {
+		p.maxSess = defaultMaxSessions
+	}
+	metrics.Default.GaugeFunc("lb_udp_sessions", func() float64 {
+		return float64(p.Sessions())
+	}, "listener", opts.Name)
+	return p, nil
+}
+
+// Balancer возвращает балансировщик backend'ов листенера
+func (p *Proxy) Balancer() balancer.Balancer {
+	return p.balancer
+}
+
+// Listen открывает UDP-сокет на addr. Датаграммы обрабатываются после вызова Serve.
+func (p *Proxy) Listen(addr string) (*net.UDPConn, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+	return conn, nil
+}
+
+// Serve читает датаграммы клиентов, пока сокет не закрыт. После Shutdown возвращает nil.
+func (p *Proxy) Serve(conn *net.UDPConn) error {
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+	p.logger.Infof("udp listener %s on %s", p.name, conn.LocalAddr())
+
+	p.wg.Add(1)
+	go p.expireLoop()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if p.closing.Load() {
+				return nil
+			}
+			return err
+		}
+
+		if p.limiter != nil && !p.limiter.Allow(client.IP.String()) {
+			p.rateLimited.Inc()
+			continue // Лишние датаграммы отбрасываются: у UDP нет способа сообщить об отказе
+		}
+
+		s, err := p.session(client)
+		if errors.Is(err, errTooManySessions) {
+			p.overLimit.Inc()
+			p.logger.Debugf("dropped datagram from %s: %v", client, err)
+			continue
+		}
+		if err != nil {
+			p.noBackend.Inc()
+			p.logger.Debugf("no backend for %s: %v", client, err)
+			continue
+		}
+		s.touch()
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			p.logger.Warnf("write to backend %s: %v", s.backend.URL.Host, err)
+			p.closeSession(s)
+			continue
+		}
+		p.forwarded.Inc()
+	}
+}
+
+// session возвращает сессию клиента, при необходимости выбирая ему backend.
+// Новая сессия сверх лимита листенера не создаётся (errTooManySessions).
+func (p *Proxy) session(client *net.UDPAddr) (*session, error) {
+	key := client.String()
+	p.mu.Lock()
+	s, ok := p.sessions[key]
+	full := len(p.sessions) >= p.maxSess
+	p.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	if full {
+		return nil, errTooManySessions
+	}
+
+	// Недоступный backend помечается мёртвым, и сессия пробует следующий
+	var lastErr error
+	for attempt := 0; attempt < len(p.balancer.Backends()); attempt++ {
+		backend, err := p.balancer.NextBackend(client.IP.String())
+		if err != nil {
+			return nil, err
+		}
+		raddr, err := net.ResolveUDPAddr("udp", backend.URL.Host)
+		if err == nil {
+			var upstream *net.UDPConn
+			upstream, err = net.DialUDP("udp", nil, raddr)
+			if err == nil {
+				s = &session{client: client, backend: backend, upstream: upstream, closed: make(chan struct{})}
+				s.touch()
+				break
+			}
+		}
+		backend.Release()
+		lastErr = err
+		p.logger.Errorf("dial backend %s: %v", backend.URL.Host, err)
+		if p.passive {
+			p.balancer.MarkBackendDead(backend.URL)
+		}
+	}
+	if s == nil {
+		return nil, lastErr
+	}
+
+	p.mu.Lock()
+	if p.closing.Load() {
+		p.mu.Unlock()
+		s.upstream.Close()
+		s.backend.Release()
+		return nil, errors.New("listener is shutting down")
+	}
+	p.sessions[key] = s
+	p.wg.Add(1)
+	p.mu.Unlock()
+	p.logger.Debugf("udp session %s → %s", client, s.backend.URL.Host)
+
+	go p.replyLoop(s)
+	return s, nil
+}
+
+// replyLoop возвращает клиенту ответы backend'а, пока сессия не удалена
+func (p *Proxy) replyLoop(s *session) {
+	defer p.wg.Done()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+			default:
+				// ICMP port unreachable: на адресе backend'а никто не слушает
+				if errors.Is(err, syscall.ECONNREFUSED) && p.passive {
+					p.balancer.MarkBackendDead(s.backend.URL)
+				}
+				p.logger.Warnf("read from backend %s: %v", s.backend.URL.Host, err)
+				p.closeSession(s)
+			}
+			return
+		}
+		s.touch()
+		p.replies.Inc()
+		if _, err := p.conn.WriteToUDP(buf[:n], s.client); err != nil {
+			p.logger.Debugf("write to client %s: %v", s.client, err)
+		}
+	}
+}
+
+// closeSession удаляет сессию и освобождает слот backend'а
+func (p *Proxy) closeSession(s *session) {
+	s.once.Do(func() {
+		p.mu.Lock()
+		if p.sessions[s.client.String()] == s {
+			delete(p.sessions, s.client.String())
+		}
+		p.mu.Unlock()
+		close(s.closed)
+		s.upstream.Close()
+		s.backend.Release()
+	})
+}
+
+// expireLoop удаляет сессии без трафика дольше SessionTimeout
+func (p *Proxy) expireLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		var idle []*session
+		for _, s := range p.sessions {
+			if s.idleFor() >= p.timeout {
+				idle = append(idle, s)
+			}
+		}
+		p.mu.Unlock()
+		for _, s := range idle {
+			p.closeSession(s)
+		}
+		if p.limiter != nil {
+			p.limiter.Cleanup(limiterTTL) // Бакеты ушедших клиентов
+		}
+	}
+}
+
+// CloseDraining через grace закрывает сессии backend'а b, если он всё ещё выводится
+// из ротации. Иначе клиент, который продолжает слать датаграммы (syslog), держал бы
+// сессию на backend'е вечно; его следующая датаграмма откроет сессию на другом backend'е.
+func (p *Proxy) CloseDraining(b *balancer.Backend, grace time.Duration) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.stop:
+		return
+	}
+	if !b.Draining() {
+		return // Backend вернули в ротацию
+	}
+
+	p.mu.Lock()
+	var draining []*session
+	for _, s := range p.sessions {
+		if s.backend == b {
+			draining = append(draining, s)
+		}
+	}
+	p.mu.Unlock()
+	if len(draining) > 0 {
+		p.logger.Infof("closing %d udp sessions of draining backend %s", len(draining), b.URL.Host)
+	}
+	for _, s := range draining {
+		p.closeSession(s)
+	}
+}
+
+// Sessions возвращает количество активных сессий
+func (p *Proxy) Sessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sessions)
+}
+
+// Shutdown закрывает сокет листенера и все сессии. У UDP нет соединений,
+// завершения которых стоило бы ждать.
+func (p *Proxy) Shutdown() {
+	p.mu.Lock()
+	if !p.closing.CompareAndSwap(false, true) {
+		p.mu.Unlock()
+		return
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	sessions := make([]*session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.mu.Unlock()
+
+	close(p.stop)
+	for _, s := range sessions {
+		p.closeSession(s)
+	}
+	p.wg.Wait()
+}